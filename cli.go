@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"lenslocked.com/config"
+	"lenslocked.com/imaging"
+	"lenslocked.com/jobs"
+	"lenslocked.com/mail"
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+var (
+	// errUsage is returned by a command when it was called with bad
+	// arguments. The usage for that command is printed for the caller.
+	errUsage = errors.New("invalid usage")
+	// errShutdownTimeout is returned when in-flight requests or background
+	// workers are still running once the shutdown deadline has passed
+	errShutdownTimeout = errors.New("shutdown deadline exceeded, in-flight work was dropped")
+)
+
+// command is a single node in the CLI's command tree. A command either
+// runs something itself or groups together a set of subcommands.
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(app *app, args []string) error
+	subs  []*command
+}
+
+// app holds the state shared by every command. The database is only
+// opened when a command asks for it so that help works without one.
+type app struct {
+	cfg      config.Config
+	out      io.Writer
+	services *models.Services
+	mailer   mail.Mailer
+	store    storage.BlobStore
+	closers  []io.Closer
+
+	// workers tracks the background goroutines started with goBackground
+	workers    sync.WaitGroup
+	stopCtx    context.Context
+	stopWorker context.CancelFunc
+}
+
+// goBackground runs fn in its own goroutine. The context passed to fn
+// is cancelled when stopBackground is called, and stopBackground waits
+// for fn to return before the database is closed.
+func (a *app) goBackground(fn func(ctx context.Context)) {
+	if a.stopCtx == nil {
+		a.stopCtx, a.stopWorker = context.WithCancel(context.Background())
+	}
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		fn(a.stopCtx)
+	}()
+}
+
+// stopBackground cancels every background worker and waits for them
+// to finish, giving up once ctx is done
+func (a *app) stopBackground(ctx context.Context) error {
+	if a.stopWorker == nil {
+		return nil
+	}
+	a.stopWorker()
+	done := make(chan struct{})
+	go func() {
+		a.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errShutdownTimeout
+	}
+}
+
+// Services opens the database connection the first time it is called
+func (a *app) Services() (*models.Services, error) {
+	if a.services != nil {
+		return a.services, nil
+	}
+	store, err := a.Storage()
+	if err != nil {
+		return nil, err
+	}
+	// WebP variants are only made when cwebp is installed
+	var webp imaging.Encoder
+	if a.cfg.CWebP != "" {
+		webp, err = imaging.NewCWebP(a.cfg.CWebP, 80)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	dbCfg := a.cfg.Database
+	services, err := models.NewServices(
+		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
+		models.WithLogMode(!a.cfg.IsProd()),
+		models.WithUser(a.cfg.Pepper, a.cfg.HMACKey),
+		models.WithSession(a.cfg.HMACKey),
+		models.WithGallery(a.cfg.Pepper, a.cfg.HMACKey),
+		models.WithShareLink(a.cfg.HMACKey),
+		models.WithProof(),
+		models.WithJobs(jobs.Config{
+			Workers:      a.cfg.Jobs.Workers,
+			PollInterval: time.Duration(a.cfg.Jobs.PollInterval),
+			Attempts:     a.cfg.Jobs.Attempts,
+			Backoff:      time.Duration(a.cfg.Jobs.Backoff),
+		}),
+		models.WithImage(store, webp),
+		models.WithImageURLs(a.cfg.HMACKey),
+	)
+	if err != nil {
+		return nil, err
+	}
+	a.services = services
+	return services, nil
+}
+
+// Storage returns the blob store images are kept in
+func (a *app) Storage() (storage.BlobStore, error) {
+	if a.store != nil {
+		return a.store, nil
+	}
+	switch a.cfg.Storage.Backend {
+	case config.StorageS3:
+		s3 := a.cfg.Storage.S3
+		store, err := storage.NewS3Store(storage.S3Config{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			PathStyle: s3.PathStyle,
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.store = store
+	case config.StorageMemory:
+		a.store = storage.NewMemoryStore()
+	default:
+		a.store = storage.NewLocalStore(a.cfg.ImagesDir)
+	}
+	return a.store, nil
+}
+
+// Mailer returns the mailer outgoing email is sent through
+func (a *app) Mailer() (mail.Mailer, error) {
+	if a.mailer != nil {
+		return a.mailer, nil
+	}
+	var m mail.Mailer
+	switch a.cfg.Mail.Backend {
+	case config.MailSMTP:
+		smtp := a.cfg.Mail.SMTP
+		m = mail.NewSMTPMailer(mail.SMTPConfig{
+			Host:     smtp.Host,
+			Port:     smtp.Port,
+			Username: smtp.Username,
+			Password: smtp.Password,
+		})
+	case config.MailSpool:
+		m = mail.NewSpoolMailer(a.cfg.Mail.SpoolDir)
+	default:
+		var w io.Writer = a.out
+		if a.cfg.Mail.LogFile != "" {
+			f, err := os.OpenFile(a.cfg.Mail.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, err
+			}
+			a.closers = append(a.closers, f)
+			w = f
+		}
+		m = mail.NewLogMailer(w)
+	}
+	a.mailer = mail.WithFrom(m, a.cfg.Mail.From)
+	return a.mailer, nil
+}
+
+// Close releases anything the commands opened
+func (a *app) Close() error {
+	for _, c := range a.closers {
+		c.Close()
+	}
+	if a.services == nil {
+		return nil
+	}
+	return a.services.Close()
+}
+
+// commands returns the full command tree
+func commands() []*command {
+	return []*command{
+		serveCommand(),
+		migrateCommand(),
+		dbCommand(),
+		userCommand(),
+		galleryCommand(),
+		imageCommand(),
+		jobsCommand(),
+	}
+}
+
+// runCLI parses the global config flags, finds the command named by
+// the remaining arguments and runs it. With no command we serve.
+func runCLI(args []string, out io.Writer) error {
+	cfg, rest, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+	a := &app{cfg: cfg, out: out}
+	defer a.Close()
+
+	if len(rest) == 0 {
+		rest = []string{"serve"}
+	}
+	if rest[0] == "help" || rest[0] == "-h" || rest[0] == "--help" {
+		printUsage(out, "lenslocked", commands())
+		return nil
+	}
+	return dispatch(a, "lenslocked", commands(), rest)
+}
+
+func dispatch(a *app, path string, cmds []*command, args []string) error {
+	if len(args) == 0 {
+		printUsage(a.out, path, cmds)
+		return errUsage
+	}
+	cmd := findCommand(cmds, args[0])
+	if cmd == nil {
+		printUsage(a.out, path, cmds)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	path = path + " " + cmd.name
+	if len(cmd.subs) > 0 {
+		return dispatch(a, path, cmd.subs, args[1:])
+	}
+	err := cmd.run(a, args[1:])
+	if err == errUsage || err == flag.ErrHelp {
+		fmt.Fprintf(a.out, "usage: %s %s\n", path, cmd.args)
+	}
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+func findCommand(cmds []*command, name string) *command {
+	for _, cmd := range cmds {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer, path string, cmds []*command) {
+	fmt.Fprintf(w, "usage: %s <command> [arguments]\n\ncommands:\n", path)
+	for _, cmd := range cmds {
+		printCommand(w, "  ", cmd)
+	}
+}
+
+func printCommand(w io.Writer, indent string, cmd *command) {
+	line := strings.TrimSpace(cmd.name + " " + cmd.args)
+	fmt.Fprintf(w, "%s%-40s %s\n", indent, line, cmd.short)
+	for _, sub := range cmd.subs {
+		printCommand(w, indent+"  ", sub)
+	}
+}
+
+// newFlagSet returns a flag set for a command that reports errors
+// back to us instead of exiting
+func newFlagSet(a *app, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.out)
+	return fs
+}
+
+// exitCode turns the error returned by a command into a process
+// exit code, printing it on the way out:
+//
+//	0 - success, including a clean shutdown after SIGINT or SIGTERM
+//	1 - the command failed
+//	2 - the command was called incorrectly
+//	3 - shutdown timed out before in-flight work finished
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "lenslocked:", err)
+	switch err {
+	case errUsage:
+		return 2
+	case errShutdownTimeout:
+		return 3
+	default:
+		return 1
+	}
+}
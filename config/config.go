@@ -0,0 +1,425 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// EnvDev is the profile used when running the app locally
+	EnvDev = "dev"
+	// EnvTest is the profile used by the test suite
+	EnvTest = "test"
+	// EnvProd is the profile used when running in production
+	EnvProd = "prod"
+
+	// DefaultPepper is the development pepper. It must never be used in prod.
+	DefaultPepper = "aaaafe93-7942-4e3d-a4fc-e295ba99d571"
+	// DefaultHMACKey is the development HMAC key. It must never be used in prod.
+	DefaultHMACKey = "secret-hmac-key"
+
+	// DefaultFile is the config file that is read when one is not
+	// explicitly provided. It is fine for this file to be missing.
+	DefaultFile = ".config.json"
+
+	envPrefix = "LENSLOCKED_"
+)
+
+var (
+	// ErrDefaultSecret is returned by Validate when the prod profile is
+	// started without a pepper or HMAC key, or with the development ones
+	ErrDefaultSecret = errors.New("config: refusing to run prod with a missing or default pepper or hmac key")
+	// ErrSelfSignedProd is returned by Validate when prod is started with
+	// a self-signed certificate
+	ErrSelfSignedProd = errors.New("config: self-signed certificates cannot be used in prod")
+	// ErrTLSKeyPair is returned when only one of the cert and key files is set
+	ErrTLSKeyPair = errors.New("config: tls needs both cert_file and key_file")
+	// ErrMailBackend is returned when the mail backend is unknown or is
+	// missing the settings it needs
+	ErrMailBackend = errors.New("config: mail backend must be log, spool, or smtp with a host")
+	// ErrStorageBackend is returned when the storage backend is unknown
+	// or is missing the settings it needs
+	ErrStorageBackend = errors.New("config: storage backend must be local, memory, or s3 with a bucket")
+	// ErrUnknownEnv is returned when the requested profile does not exist
+	ErrUnknownEnv = errors.New("config: env must be one of dev, test or prod")
+)
+
+// PostgresConfig holds everything needed to connect to our database
+type PostgresConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+}
+
+// Dialect is the gorm dialect for this database
+func (c PostgresConfig) Dialect() string {
+	return "postgres"
+}
+
+// ConnectionInfo builds the connection string passed to gorm.Open
+func (c PostgresConfig) ConnectionInfo() string {
+	if c.Password == "" {
+		return fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
+			c.Host, c.Port, c.User, c.Name)
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		c.Host, c.Port, c.User, c.Password, c.Name)
+}
+
+// TemplateConfig tells the views package where to find templates on disk
+type TemplateConfig struct {
+	LayoutDir string `json:"layout_dir"`
+	Dir       string `json:"dir"`
+	Ext       string `json:"ext"`
+}
+
+// Duration is a time.Duration that is written as "30s" or "5m"
+// in config files rather than as a number of nanoseconds
+type Duration time.Duration
+
+// UnmarshalJSON parses durations using time.ParseDuration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration in the same format UnmarshalJSON reads
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ServerConfig holds the HTTP server timeouts. Uploads of large images
+// have to fit inside of ReadTimeout and finish within ShutdownTimeout
+// once the server has been asked to stop.
+type ServerConfig struct {
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout"`
+}
+
+// TLSConfig turns on HTTPS. Either a certificate and key pair is
+// provided or, outside of prod, SelfSigned generates one at startup.
+// When TLS is on, RedirectAddr gets a plain HTTP listener that sends
+// everyone to HTTPS. Leave it empty to skip that listener.
+type TLSConfig struct {
+	CertFile     string   `json:"cert_file"`
+	KeyFile      string   `json:"key_file"`
+	SelfSigned   bool     `json:"self_signed"`
+	RedirectAddr string   `json:"redirect_addr"`
+	HSTSMaxAge   Duration `json:"hsts_max_age"`
+}
+
+// Enabled reports whether the server should speak HTTPS
+func (c TLSConfig) Enabled() bool {
+	return c.SelfSigned || c.CertFile != "" || c.KeyFile != ""
+}
+
+const (
+	// MailLog writes email to MailConfig.LogFile, or stdout if it is empty
+	MailLog = "log"
+	// MailSpool writes each email to MailConfig.SpoolDir as a .eml file
+	MailSpool = "spool"
+	// MailSMTP delivers email through MailConfig.SMTP
+	MailSMTP = "smtp"
+)
+
+// SMTPConfig is the SMTP server used by the smtp mail backend
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// MailConfig controls how outgoing email is delivered. While the
+// server is running email is queued and sent in the background, with
+// each message getting up to Attempts tries.
+type MailConfig struct {
+	From      string     `json:"from"`
+	Backend   string     `json:"backend"`
+	LogFile   string     `json:"log_file"`
+	SpoolDir  string     `json:"spool_dir"`
+	SMTP      SMTPConfig `json:"smtp"`
+	QueueSize int        `json:"queue_size"`
+	Attempts  int        `json:"attempts"`
+	Backoff   Duration   `json:"backoff"`
+}
+
+const (
+	// StorageLocal keeps images on disk in Config.ImagesDir
+	StorageLocal = "local"
+	// StorageS3 keeps images in the bucket described by StorageConfig.S3
+	StorageS3 = "s3"
+	// StorageMemory keeps images in memory, they are lost on restart
+	StorageMemory = "memory"
+)
+
+// S3Config is the bucket used by the s3 storage backend. Endpoint can
+// point at any S3 compatible server.
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"`
+}
+
+// StorageConfig picks where uploaded images are kept
+type StorageConfig struct {
+	Backend string   `json:"backend"`
+	S3      S3Config `json:"s3"`
+}
+
+// JobsConfig controls the background job workers that process
+// uploaded images
+type JobsConfig struct {
+	Workers      int      `json:"workers"`
+	PollInterval Duration `json:"poll_interval"`
+	Attempts     int      `json:"attempts"`
+	Backoff      Duration `json:"backoff"`
+}
+
+// GalleryConfig holds settings for galleries. UnlockTTL is how long
+// a visitor who entered a gallery's password can see it before they
+// are asked for it again.
+type GalleryConfig struct {
+	UnlockTTL Duration `json:"unlock_ttl"`
+}
+
+// Config is the top level configuration for the whole app
+type Config struct {
+	Env       string         `json:"env"`
+	Addr      string         `json:"addr"`
+	BaseURL   string         `json:"base_url"`
+	Server    ServerConfig   `json:"server"`
+	TLS       TLSConfig      `json:"tls"`
+	Pepper    string         `json:"pepper"`
+	HMACKey   string         `json:"hmac_key"`
+	ImagesDir string         `json:"images_dir"`
+	CWebP     string         `json:"cwebp"`
+	Storage   StorageConfig  `json:"storage"`
+	Database  PostgresConfig `json:"database"`
+	Templates TemplateConfig `json:"templates"`
+	Mail      MailConfig     `json:"mail"`
+	Jobs      JobsConfig     `json:"jobs"`
+	Galleries GalleryConfig  `json:"galleries"`
+}
+
+// IsProd reports whether we are running with the prod profile
+func (c Config) IsProd() bool {
+	return c.Env == EnvProd
+}
+
+// Validate makes sure the config is safe to run with
+func (c Config) Validate() error {
+	switch c.Env {
+	case EnvDev, EnvTest, EnvProd:
+	default:
+		return ErrUnknownEnv
+	}
+	if c.IsProd() {
+		if c.Pepper == "" || c.Pepper == DefaultPepper ||
+			c.HMACKey == "" || c.HMACKey == DefaultHMACKey {
+			return ErrDefaultSecret
+		}
+		if c.TLS.SelfSigned {
+			return ErrSelfSignedProd
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return ErrTLSKeyPair
+	}
+	switch c.Mail.Backend {
+	case MailLog, MailSpool:
+	case MailSMTP:
+		if c.Mail.SMTP.Host == "" {
+			return ErrMailBackend
+		}
+	default:
+		return ErrMailBackend
+	}
+	switch c.Storage.Backend {
+	case StorageLocal, StorageMemory:
+	case StorageS3:
+		if c.Storage.S3.Bucket == "" {
+			return ErrStorageBackend
+		}
+	default:
+		return ErrStorageBackend
+	}
+	return nil
+}
+
+// Default returns the default config for the given profile
+func Default(env string) Config {
+	cfg := Config{
+		Env:  env,
+		Addr: ":3000",
+		Server: ServerConfig{
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ReadTimeout:       Duration(5 * time.Minute),
+			WriteTimeout:      Duration(5 * time.Minute),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		TLS: TLSConfig{
+			RedirectAddr: ":80",
+			HSTSMaxAge:   Duration(365 * 24 * time.Hour),
+		},
+		Pepper:    DefaultPepper,
+		HMACKey:   DefaultHMACKey,
+		ImagesDir: "images",
+		CWebP:     "cwebp",
+		Storage:   StorageConfig{Backend: StorageLocal},
+		Database: PostgresConfig{
+			Host: "localhost",
+			Port: 5432,
+			User: "fenderjazzplayer",
+			Name: "lenslocked_dev",
+		},
+		Templates: TemplateConfig{
+			LayoutDir: "views/layouts/",
+			Dir:       "views/",
+			Ext:       ".gohtml",
+		},
+		Mail: MailConfig{
+			From:      "LensLocked Support <support@lenslocked.com>",
+			Backend:   MailLog,
+			SpoolDir:  "tmp/mail",
+			SMTP:      SMTPConfig{Port: 587},
+			QueueSize: 1000,
+			Attempts:  5,
+			Backoff:   Duration(5 * time.Second),
+		},
+		Jobs: JobsConfig{
+			Workers:      2,
+			PollInterval: Duration(time.Second),
+			Attempts:     5,
+			Backoff:      Duration(30 * time.Second),
+		},
+		Galleries: GalleryConfig{
+			UnlockTTL: Duration(7 * 24 * time.Hour),
+		},
+	}
+	switch env {
+	case EnvTest:
+		cfg.Database.Name = "lenslocked_test"
+		cfg.ImagesDir = "tmp/images"
+	case EnvProd:
+		cfg.Database.Name = "lenslocked_prod"
+		cfg.BaseURL = "https://lenslocked.com"
+		cfg.Mail.Backend = MailSMTP
+		// Secrets have to be provided explicitly in prod
+		cfg.Pepper = ""
+		cfg.HMACKey = ""
+	}
+	return cfg
+}
+
+// Load builds the config from, in increasing order of precedence:
+//  1. the defaults for the selected profile
+//  2. the JSON config file
+//  3. LENSLOCKED_* environment variables
+//  4. command-line flags
+//
+// The profile itself is picked with -env or LENSLOCKED_ENV and
+// defaults to dev. Any arguments left after the flags are returned
+// so callers can treat them as positional arguments.
+func Load(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("lenslocked", flag.ContinueOnError)
+	envFlag := fs.String("env", "", "profile to run with: dev, test or prod")
+	fileFlag := fs.String("config", "", "path to a JSON config file")
+	values := make(map[string]*flagValue, len(settings))
+	for _, s := range settings {
+		values[s.name] = &flagValue{isBool: s.isBool}
+		fs.Var(values[s.name], s.name, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	env := first(*envFlag, os.Getenv(envPrefix+"ENV"), EnvDev)
+	cfg := Default(env)
+
+	path := first(*fileFlag, os.Getenv(envPrefix+"CONFIG"))
+	if err := loadFile(&cfg, path); err != nil {
+		return Config{}, nil, err
+	}
+	// The profile was already chosen above, the file cannot change it
+	cfg.Env = env
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.envVar()); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return Config{}, nil, fmt.Errorf("config: %s: %v", s.envVar(), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := lookupSetting(f.Name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := s.set(&cfg, values[f.Name].value); setErr != nil {
+			err = fmt.Errorf("config: -%s: %v", f.Name, setErr)
+		}
+	})
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile decodes the JSON file at path over the top of cfg. If path
+// is empty we fall back to DefaultFile and quietly skip it if missing.
+func loadFile(cfg *Config, path string) error {
+	required := path != ""
+	if !required {
+		path = DefaultFile
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(cfg); err != nil {
+		return fmt.Errorf("config: parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+// first returns the first non empty string
+func first(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
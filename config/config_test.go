@@ -0,0 +1,78 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenslocked-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	file := `{"addr": ":4000", "images_dir": "from-file", "database": {"name": "from_file", "port": 6543}}`
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("LENSLOCKED_IMAGES_DIR", "from-env")
+	os.Setenv("LENSLOCKED_DB_NAME", "from_env")
+	defer os.Unsetenv("LENSLOCKED_IMAGES_DIR")
+	defer os.Unsetenv("LENSLOCKED_DB_NAME")
+
+	cfg, rest, err := Load([]string{"-config", path, "-db-name", "from_flag", "serve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != EnvDev {
+		t.Errorf("Expected env %q, received %q", EnvDev, cfg.Env)
+	}
+	if cfg.Addr != ":4000" {
+		t.Errorf("Expected addr from file, received %q", cfg.Addr)
+	}
+	if cfg.Database.Port != 6543 {
+		t.Errorf("Expected db port from file, received %d", cfg.Database.Port)
+	}
+	if cfg.ImagesDir != "from-env" {
+		t.Errorf("Expected images dir from env, received %q", cfg.ImagesDir)
+	}
+	if cfg.Database.Name != "from_flag" {
+		t.Errorf("Expected db name from flag, received %q", cfg.Database.Name)
+	}
+	if cfg.Database.Host != "localhost" {
+		t.Errorf("Expected default db host, received %q", cfg.Database.Host)
+	}
+	if len(rest) != 1 || rest[0] != "serve" {
+		t.Errorf("Expected remaining args [serve], received %v", rest)
+	}
+}
+
+func TestLoadProdRequiresSecrets(t *testing.T) {
+	_, _, err := Load([]string{"-env", "prod"})
+	if err != ErrDefaultSecret {
+		t.Errorf("Expected ErrDefaultSecret, received %v", err)
+	}
+	_, _, err = Load([]string{"-env", "prod", "-pepper", DefaultPepper, "-hmac-key", "a-real-key"})
+	if err != ErrDefaultSecret {
+		t.Errorf("Expected ErrDefaultSecret for the default pepper, received %v", err)
+	}
+	cfg, _, err := Load([]string{"-env", "prod", "-pepper", "a-real-pepper", "-hmac-key", "a-real-key",
+		"-smtp-host", "smtp.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Name != "lenslocked_prod" {
+		t.Errorf("Expected prod database, received %q", cfg.Database.Name)
+	}
+}
+
+func TestLoadUnknownEnv(t *testing.T) {
+	_, _, err := Load([]string{"-env", "staging"})
+	if err != ErrUnknownEnv {
+		t.Errorf("Expected ErrUnknownEnv, received %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a single config value that can be overridden from
+// the environment or from a command-line flag
+type setting struct {
+	name   string
+	usage  string
+	set    func(cfg *Config, v string) error
+	isBool bool
+}
+
+// flagValue holds the raw string for a setting passed on the command
+// line. Bool settings can be passed as just -name like any other flag.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// envVar is the environment variable for the setting, for example
+// the setting "db-host" is read from LENSLOCKED_DB_HOST
+func (s setting) envVar() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+var settings = []setting{
+	stringSetting("addr", "address the HTTP server listens on", func(c *Config) *string { return &c.Addr }),
+	stringSetting("base-url", "URL the site is reached at, used in emailed links", func(c *Config) *string { return &c.BaseURL }),
+	durationSetting("read-header-timeout", "time allowed to read request headers", func(c *Config) *Duration { return &c.Server.ReadHeaderTimeout }),
+	durationSetting("read-timeout", "time allowed to read a whole request, including uploads", func(c *Config) *Duration { return &c.Server.ReadTimeout }),
+	durationSetting("write-timeout", "time allowed to write a response", func(c *Config) *Duration { return &c.Server.WriteTimeout }),
+	durationSetting("idle-timeout", "time keep-alive connections are kept open", func(c *Config) *Duration { return &c.Server.IdleTimeout }),
+	durationSetting("shutdown-timeout", "time in-flight requests get to finish on shutdown", func(c *Config) *Duration { return &c.Server.ShutdownTimeout }),
+	stringSetting("tls-cert", "TLS certificate file, turns on HTTPS", func(c *Config) *string { return &c.TLS.CertFile }),
+	stringSetting("tls-key", "TLS private key file", func(c *Config) *string { return &c.TLS.KeyFile }),
+	boolSetting("tls-self-signed", "serve HTTPS with a generated self-signed certificate", func(c *Config) *bool { return &c.TLS.SelfSigned }),
+	stringSetting("tls-redirect-addr", "address of the HTTP listener that redirects to HTTPS", func(c *Config) *string { return &c.TLS.RedirectAddr }),
+	stringSetting("pepper", "pepper added to passwords before hashing", func(c *Config) *string { return &c.Pepper }),
+	stringSetting("hmac-key", "secret key used to hash remember tokens and sign image urls", func(c *Config) *string { return &c.HMACKey }),
+	stringSetting("images-dir", "directory uploaded images are stored in by the local backend", func(c *Config) *string { return &c.ImagesDir }),
+	stringSetting("cwebp", "cwebp binary used to make WebP variants, empty to only make JPEGs", func(c *Config) *string { return &c.CWebP }),
+	stringSetting("storage-backend", "where images are stored: local, s3 or memory", func(c *Config) *string { return &c.Storage.Backend }),
+	stringSetting("s3-endpoint", "S3 compatible server URL, defaults to AWS", func(c *Config) *string { return &c.Storage.S3.Endpoint }),
+	stringSetting("s3-region", "S3 region", func(c *Config) *string { return &c.Storage.S3.Region }),
+	stringSetting("s3-bucket", "S3 bucket images are stored in", func(c *Config) *string { return &c.Storage.S3.Bucket }),
+	stringSetting("s3-access-key", "S3 access key ID", func(c *Config) *string { return &c.Storage.S3.AccessKey }),
+	stringSetting("s3-secret-key", "S3 secret access key", func(c *Config) *string { return &c.Storage.S3.SecretKey }),
+	boolSetting("s3-path-style", "put the bucket in the URL path instead of the host name", func(c *Config) *bool { return &c.Storage.S3.PathStyle }),
+	stringSetting("db-host", "postgres host", func(c *Config) *string { return &c.Database.Host }),
+	intSetting("db-port", "postgres port", func(c *Config) *int { return &c.Database.Port }),
+	stringSetting("db-user", "postgres user", func(c *Config) *string { return &c.Database.User }),
+	stringSetting("db-password", "postgres password", func(c *Config) *string { return &c.Database.Password }),
+	stringSetting("db-name", "postgres database name", func(c *Config) *string { return &c.Database.Name }),
+	stringSetting("mail-from", "address email is sent from", func(c *Config) *string { return &c.Mail.From }),
+	stringSetting("mail-backend", "how email is delivered: log, spool or smtp", func(c *Config) *string { return &c.Mail.Backend }),
+	stringSetting("mail-log", "file email is written to by the log backend", func(c *Config) *string { return &c.Mail.LogFile }),
+	stringSetting("mail-spool-dir", "directory .eml files are written to by the spool backend", func(c *Config) *string { return &c.Mail.SpoolDir }),
+	stringSetting("smtp-host", "SMTP server host", func(c *Config) *string { return &c.Mail.SMTP.Host }),
+	intSetting("smtp-port", "SMTP server port", func(c *Config) *int { return &c.Mail.SMTP.Port }),
+	stringSetting("smtp-username", "SMTP username", func(c *Config) *string { return &c.Mail.SMTP.Username }),
+	stringSetting("smtp-password", "SMTP password", func(c *Config) *string { return &c.Mail.SMTP.Password }),
+	intSetting("jobs-workers", "number of background jobs run at once", func(c *Config) *int { return &c.Jobs.Workers }),
+	durationSetting("jobs-poll-interval", "how often idle workers look for new jobs", func(c *Config) *Duration { return &c.Jobs.PollInterval }),
+	intSetting("jobs-attempts", "times a failing job is tried before it is dead", func(c *Config) *int { return &c.Jobs.Attempts }),
+	durationSetting("jobs-backoff", "wait before retrying a failed job, doubled each attempt", func(c *Config) *Duration { return &c.Jobs.Backoff }),
+	durationSetting("gallery-unlock-ttl", "how long entering a gallery's password lets a visitor see it", func(c *Config) *Duration { return &c.Galleries.UnlockTTL }),
+	stringSetting("layout-dir", "directory containing layout templates", func(c *Config) *string { return &c.Templates.LayoutDir }),
+	stringSetting("template-dir", "directory containing page templates", func(c *Config) *string { return &c.Templates.Dir }),
+}
+
+func lookupSetting(name string) (setting, bool) {
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func stringSetting(name, usage string, field func(*Config) *string) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(*Config) *int) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(*Config) *bool) setting {
+	return setting{name: name, usage: usage, isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func durationSetting(name, usage string, field func(*Config) *Duration) setting {
+	return setting{name: name, usage: usage, set: func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = Duration(d)
+		return nil
+	}}
+}
@@ -7,7 +7,8 @@ import (
 )
 
 const (
-	userKey privateKey = "user"
+	userKey    privateKey = "user"
+	sessionKey privateKey = "session"
 )
 
 type privateKey string
@@ -30,3 +31,18 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+// WithSession attaches the session the request was made with
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+// Session pulls the current session from a context
+func Session(ctx context.Context) *models.Session {
+	if temp := ctx.Value(sessionKey); temp != nil {
+		if session, ok := temp.(*models.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
@@ -1,15 +1,25 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"lenslocked.com/config"
 	"lenslocked.com/context"
+	"lenslocked.com/middleware"
 	"lenslocked.com/models"
+	"lenslocked.com/ratelimit"
 	"lenslocked.com/views"
 )
 
@@ -18,32 +28,169 @@ const (
 	EditGallery = "edit_gallery"
 
 	macMultipartMem = 1 << 20 // 1 megabyte
+	// maxUploadBytes limits the size of a whole upload request. Each
+	// file in it is also limited to models.MaxImageBytes.
+	maxUploadBytes = 200 << 20 // 200 megabytes
+	// signedImageTTL is how long the signed image URLs on pages of
+	// galleries that aren't public keep working
+	signedImageTTL = 24 * time.Hour
+	// discoverLimit is how many galleries the discover page lists
+	discoverLimit = 50
+	// unlockAttempts is how many passwords a visitor can try for a
+	// gallery in each unlockWindow. Unlocking it starts them over.
+	unlockAttempts = 5
+	unlockWindow   = 15 * time.Minute
 )
 
-func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
+// NewGalleries is used to create the galleries controller. us looks
+// up collaborators and the owners of profiles. Entering a gallery's
+// password lets a visitor see it for unlockTTL. baseURL is used to
+// show share links in full when they are made.
+func NewGalleries(gs models.GalleryService, sls models.ShareLinkService, ps models.ProofService, is models.ImageService, us models.UserService, urls *models.ImageURLSigner, r *mux.Router, baseURL string, tc config.TemplateConfig, unlockTTL time.Duration) *Galleries {
 	return &Galleries{
-		New:       views.NewView("bootstrap", "galleries/new"),
-		ShowView:  views.NewView("bootstrap", "galleries/show"),
-		EditView:  views.NewView("bootstrap", "galleries/edit"),
-		IndexView: views.NewView("bootstrap", "galleries/index"),
-		gs:        gs,
-		is:        is,
-		r:         r,
+		New:        views.NewView(tc, "bootstrap", "galleries/new"),
+		ShowView:   views.NewView(tc, "bootstrap", "galleries/show"),
+		EditView:   views.NewView(tc, "bootstrap", "galleries/edit"),
+		IndexView:  views.NewView(tc, "bootstrap", "galleries/index"),
+		ImageView:  views.NewView(tc, "bootstrap", "galleries/image"),
+		ListView:   views.NewView(tc, "bootstrap", "galleries/list"),
+		UnlockView: views.NewView(tc, "bootstrap", "galleries/unlock"),
+		gs:         gs,
+		sls:        sls,
+		ps:         ps,
+		is:         is,
+		us:         us,
+		urls:       urls,
+		r:          r,
+		baseURL:    baseURL,
+		unlockTTL:  unlockTTL,
+		unlocks:    ratelimit.NewLimiter(unlockAttempts, unlockWindow),
 	}
 }
 
 type Galleries struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	gs        models.GalleryService
-	is        models.ImageService
-	r         *mux.Router
+	New        *views.View
+	ShowView   *views.View
+	EditView   *views.View
+	IndexView  *views.View
+	ImageView  *views.View
+	ListView   *views.View
+	UnlockView *views.View
+	gs         models.GalleryService
+	sls        models.ShareLinkService
+	ps         models.ProofService
+	is         models.ImageService
+	us         models.UserService
+	urls       *models.ImageURLSigner
+	r          *mux.Router
+	baseURL    string
+	unlockTTL  time.Duration
+	// unlocks counts unlock attempts per visitor and gallery
+	unlocks *ratelimit.Limiter
 }
 
 type GalleryForm struct {
 	Title string `schema:"title"`
+	// MetadataPolicy is empty to use the owner's policy
+	MetadataPolicy string `schema:"metadata_policy"`
+	// Visibility is left as it is when empty
+	Visibility string `schema:"visibility"`
+	// Password is left as it is when empty, unless RemovePassword
+	// is checked
+	Password       string `schema:"password"`
+	RemovePassword bool   `schema:"remove_password"`
+	// Proofing and SelectLimit, see models.Gallery
+	Proofing    bool `schema:"proofing"`
+	SelectLimit int  `schema:"select_limit"`
+}
+
+// ShareLinkForm makes a share link. ExpiresIn is a number of days
+// and MaxViews a number of views, 0 for no limit.
+type ShareLinkForm struct {
+	ExpiresIn      int  `schema:"expires_in"`
+	MaxViews       int  `schema:"max_views"`
+	AllowDownloads bool `schema:"allow_downloads"`
+}
+
+// UnlockForm is posted by visitors to a gallery with a password
+type UnlockForm struct {
+	Password string `schema:"password"`
+}
+
+// CollaboratorForm adds a collaborator by their email address
+type CollaboratorForm struct {
+	Email string `schema:"email"`
+}
+
+// ImageOrderForm is posted by the edit page after images have been
+// dragged into a new order
+type ImageOrderForm struct {
+	Order []uint `schema:"order"`
+}
+
+// ImageMoveForm picks the gallery an image is moved or copied to
+type ImageMoveForm struct {
+	GalleryID uint `schema:"gallery_id"`
+}
+
+// ImageSortForm picks how the gallery's images are sorted, one of
+// models.SortByCaptureTime or models.SortByFilename
+type ImageSortForm struct {
+	By string `schema:"by"`
+}
+
+// ImageDetail is what the image page renders, along with the images
+// either side of it in the gallery. Owner is set when the gallery's
+// owner is looking at it, and CanDownload when it was opened with a
+// share link that allows downloads. Proof is set for clients making
+// a selection.
+type ImageDetail struct {
+	*models.Image
+	Gallery     *models.Gallery
+	Prev        *models.Image
+	Next        *models.Image
+	Owner       bool
+	CanDownload bool
+	Proof       *ProofState
+}
+
+// GalleryShow is what the gallery's page renders. Proof is set for
+// clients making a selection.
+type GalleryShow struct {
+	*models.Gallery
+	Proof *ProofState
+}
+
+// GalleryEdit is what the edit page renders. Galleries holds the
+// user's other galleries that images can be moved or copied to.
+// DefaultPolicy is the owner's metadata policy, used when the gallery
+// doesn't have its own.
+//
+// Collaborators are the users the gallery is shared with, and
+// ShareLinks the links made for it. Proofs holds what clients said
+// through each link when the gallery is in proofing mode.
+type GalleryEdit struct {
+	*models.Gallery
+	Galleries     []models.Gallery
+	DefaultPolicy string
+	Collaborators []models.User
+	ShareLinks    []models.ShareLink
+	Proofs        []ProofSet
+}
+
+// GalleryIndex is what the index page renders: the user's own
+// galleries and the ones shared with them
+type GalleryIndex struct {
+	Galleries []models.Gallery
+	Shared    []models.Gallery
+}
+
+// GalleryList is a list of public galleries for the profile and
+// discover pages. Owner is set on profiles.
+type GalleryList struct {
+	Heading   string
+	Owner     *models.User
+	Galleries []models.Gallery
 }
 
 // GET /galleries/
@@ -54,19 +201,99 @@ func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
+	shared, err := g.gs.ByCollaborator(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
 	var vd views.Data
-	vd.Yield = galleries
+	vd.Yield = GalleryIndex{Galleries: galleries, Shared: shared}
 	g.IndexView.Render(w, r, vd)
 }
 
+// Profile lists a user's public galleries
+// GET /users/:id
+func (g *Galleries) Profile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	owner, err := g.us.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.NotFound(w, r)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	galleries, err := g.gs.PublicByUserID(owner.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = GalleryList{Heading: owner.Name, Owner: owner, Galleries: galleries}
+	g.ListView.Render(w, r, vd)
+}
+
+// Discover lists the newest public galleries
+// GET /discover
+func (g *Galleries) Discover(w http.ResponseWriter, r *http.Request) {
+	galleries, err := g.gs.Public(discoverLimit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = GalleryList{Heading: "Discover", Galleries: galleries}
+	g.ListView.Render(w, r, vd)
+}
+
 // GET /galleries/:id
+// GET /g/:slug
+// GET /s/:token
 func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
 	gallery, err := g.galleryById(w, r)
 	if err != nil {
 		return
 	}
+	if !g.canView(w, r, gallery) {
+		return
+	}
+	user := context.User(r.Context())
+	if link := gallery.ShareLink(); link != nil && (user == nil || user.ID != gallery.UserID) {
+		switch err := g.sls.RecordView(link); err {
+		case nil:
+		case models.ErrShareLinkExpired, models.ErrShareLinkUsedUp:
+			http.Error(w, err.(views.PublicError).Public(), http.StatusGone)
+			return
+		default:
+			log.Println(err)
+			http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
 	var vd views.Data
-	vd.Yield = gallery
+	g.renderShow(w, r, vd, gallery)
+}
+
+// renderShow renders the gallery's page, with the client's selection
+// when they are proofing
+func (g *Galleries) renderShow(w http.ResponseWriter, r *http.Request, vd views.Data, gallery *models.Gallery) {
+	proof, err := g.proofState(gallery)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = GalleryShow{Gallery: gallery, Proof: proof}
 	g.ShowView.Render(w, r, vd)
 }
 
@@ -84,9 +311,8 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var vd views.Data
-	vd.Yield = gallery
 	fmt.Println(user)
-	g.EditView.Render(w, r, vd)
+	g.renderEdit(w, r, vd, gallery)
 }
 
 // POST /galleries/:id/delete
@@ -106,8 +332,7 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	err = g.gs.Delete(gallery.ID)
 	if err != nil {
 		vd.SetAlert(err)
-		vd.Yield = gallery
-		g.EditView.Render(w, r, vd)
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
 	http.Redirect(w, r, "/galleries", http.StatusFound)
@@ -128,26 +353,47 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 	}
 	var vd views.Data
 	var form GalleryForm
-	vd.Yield = gallery
 
 	if err := parseForm(r, &form); err != nil {
 		log.Println(err)
 		vd.SetAlert(err)
-		g.EditView.Render(w, r, vd)
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
+	policyChanged := gallery.MetadataPolicy != form.MetadataPolicy
 	gallery.Title = form.Title
+	gallery.MetadataPolicy = form.MetadataPolicy
+	gallery.Proofing = form.Proofing
+	gallery.SelectLimit = form.SelectLimit
+	if form.Visibility != "" {
+		gallery.Visibility = form.Visibility
+	}
+	if form.RemovePassword {
+		gallery.PasswordHash = ""
+	} else {
+		gallery.Password = form.Password
+	}
 	err = g.gs.Update(gallery)
 	if err != nil {
 		vd.SetAlert(err)
-		g.EditView.Render(w, r, vd)
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
+	if policyChanged {
+		// The public copies have to be made again with the new policy
+		if err := g.is.Reprocess(gallery.ID); err != nil {
+			log.Println(err)
+			vd.SetAlert(err)
+			g.renderEdit(w, r, vd, gallery)
+			return
+		}
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+	}
 	vd.Alert = &views.Alert{
 		Level:   views.AlertLvlSuccess,
 		Message: "Galery successfully updated!",
 	}
-	g.EditView.Render(w, r, vd)
+	g.renderEdit(w, r, vd, gallery)
 }
 
 // POST /galleries
@@ -167,8 +413,10 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:          form.Title,
+		UserID:         user.ID,
+		MetadataPolicy: form.MetadataPolicy,
+		Visibility:     form.Visibility,
 	}
 	if err := g.gs.Create(&gallery); err != nil {
 		vd.SetAlert(err)
@@ -197,31 +445,61 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var vd views.Data
-	vd.Yield = gallery
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
 	err = r.ParseMultipartForm(macMultipartMem)
 	if err != nil {
-		vd.SetAlert(err)
-		g.EditView.Render(w, r, vd)
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			vd.AlertError(fmt.Sprintf("You can upload at most %d MB at a time.", maxUploadBytes>>20))
+		} else {
+			vd.SetAlert(err)
+		}
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
 
+	// Every file gets a chance, ones we reject are listed afterwards.
+	// XMP sidecars go last so the images they belong with are there.
 	files := r.MultipartForm.File["images"]
+	sort.SliceStable(files, func(i, j int) bool {
+		return !isSidecar(files[i].Filename) && isSidecar(files[j].Filename)
+	})
+	var rejected []error
 	for _, f := range files {
+		if f.Size > models.MaxImageBytes {
+			rejected = append(rejected, uploadError{f.Filename, models.ErrImageTooBig})
+			continue
+		}
 		// Open the uploaded file
 		file, err := f.Open()
 		if err != nil {
 			vd.SetAlert(err)
-			g.EditView.Render(w, r, vd)
+			g.renderEdit(w, r, vd, gallery)
 			return
 		}
-		defer file.Close()
-		err = g.is.Create(gallery.ID, file, f.Filename)
+		if isSidecar(f.Filename) {
+			_, err = g.is.ApplySidecar(gallery.ID, file, f.Filename)
+		} else {
+			_, err = g.is.Create(gallery.ID, file, f.Filename)
+		}
+		file.Close()
+		if pErr, ok := err.(views.PublicError); ok {
+			rejected = append(rejected, uploadError{f.Filename, pErr})
+			continue
+		}
 		if err != nil {
 			vd.SetAlert(err)
-			g.EditView.Render(w, r, vd)
+			g.renderEdit(w, r, vd, gallery)
 			return
 		}
 	}
+	if len(rejected) > 0 {
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		vd.AlertErrors(fmt.Sprintf("%d of %d files could not be uploaded:", len(rejected), len(files)), rejected)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
 	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 	if err != nil {
 		http.Redirect(w, r, "galleries", http.StatusFound)
@@ -230,15 +508,727 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func (g *Galleries) galleryById(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
-	vars := mux.Vars(r)
-	idStr := vars["id"]
-	id, err := strconv.Atoi(idStr)
+// isSidecar reports whether an uploaded file is an XMP sidecar
+// rather than an image
+func isSidecar(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), ".xmp")
+}
+
+// GET /galleries/:id/images/:imageID
+// GET /g/:slug/images/:imageID
+// GET /s/:token/images/:imageID
+func (g *Galleries) ImageShow(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	if !g.canView(w, r, gallery) {
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	g.renderImage(w, r, vd, gallery, image)
+}
+
+// renderImage renders the image's page, with the metadata the viewer
+// may see and the client's selection when they are proofing
+func (g *Galleries) renderImage(w http.ResponseWriter, r *http.Request, vd views.Data, gallery *models.Gallery, image *models.Image) {
+	user := context.User(r.Context())
+	detail := ImageDetail{Image: image, Gallery: gallery}
+	if link := gallery.ShareLink(); link != nil {
+		detail.CanDownload = link.AllowDownloads
+	}
+	if user != nil && user.ID == gallery.UserID {
+		detail.Owner = true
+	} else {
+		// Visitors only see the metadata left in the public copy
+		policy, err := g.is.MetadataPolicy(gallery.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+			return
+		}
+		redacted := image.Redacted(policy)
+		detail.Image = &redacted
+	}
+	for i := range gallery.Images {
+		if gallery.Images[i].ID != image.ID {
+			continue
+		}
+		if i > 0 {
+			detail.Prev = &gallery.Images[i-1]
+		}
+		if i+1 < len(gallery.Images) {
+			detail.Next = &gallery.Images[i+1]
+		}
+	}
+	proof, err := g.proofState(gallery)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		return
+	}
+	detail.Proof = proof
+	vd.Yield = detail
+	g.ImageView.Render(w, r, vd)
+}
+
+// Unlock asks visitors for the gallery's password
+// GET /galleries/:id/unlock
+// GET /g/:slug/unlock
+// GET /s/:token/unlock
+func (g *Galleries) Unlock(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	if !gallery.Unlockable() {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if g.shareLinkGone(w, gallery) {
+		return
+	}
+	var vd views.Data
+	vd.Yield = gallery
+	g.UnlockView.Render(w, r, vd)
+}
+
+// UnlockSubmit checks the password a visitor entered. When it is
+// right they get a cookie that lets them see the gallery and are
+// sent on to it. Attempts are limited per visitor, and each one is
+// counted before the password is checked.
+// POST /galleries/:id/unlock
+// POST /g/:slug/unlock
+// POST /s/:token/unlock
+func (g *Galleries) UnlockSubmit(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	if !gallery.Unlockable() {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if g.shareLinkGone(w, gallery) {
+		return
+	}
+	var vd views.Data
+	vd.Yield = gallery
+	key := middleware.ClientIP(r) + " " + strconv.FormatUint(uint64(gallery.ID), 10)
+	if ok, wait := g.unlocks.Allow(key); !ok {
+		vd.AlertError(fmt.Sprintf("Too many wrong passwords. Try again in %d minutes.", int((wait+time.Minute-1)/time.Minute)))
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		g.UnlockView.Render(w, r, vd)
+		return
+	}
+	var form UnlockForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.UnlockView.Render(w, r, vd)
+		return
+	}
+	token, expires, err := g.gs.Unlock(gallery, form.Password, g.unlockTTL)
+	if err != nil {
+		vd.SetAlert(err)
+		g.UnlockView.Render(w, r, vd)
+		return
+	}
+	g.unlocks.Reset(key)
+	middleware.SetGalleryUnlockCookie(w, r, gallery.Path(), token, expires)
+	http.Redirect(w, r, gallery.Path(), http.StatusFound)
+}
+
+// RotateSlug gives the gallery a new share link so the old one stops
+// working
+// POST /galleries/:id/slug
+func (g *Galleries) RotateSlug(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	if err := g.gs.RotateSlug(gallery); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The gallery has a new link. The old one no longer works.",
+	}
+	g.renderEdit(w, r, vd, gallery)
+}
+
+// ShareLinkCreate makes a new share link for the gallery
+// POST /galleries/:id/links
+func (g *Galleries) ShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	var form ShareLinkForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	link := models.ShareLink{
+		GalleryID:      gallery.ID,
+		MaxViews:       form.MaxViews,
+		AllowDownloads: form.AllowDownloads,
+	}
+	if form.ExpiresIn != 0 {
+		expires := time.Now().AddDate(0, 0, form.ExpiresIn)
+		link.ExpiresAt = &expires
+	}
+	if err := g.sls.Create(&link); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	// Only the token's hash is kept, so this is the one time the
+	// link can be shown
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Your new link is %s%s. Copy it now, it won't be shown again.", g.baseURL, link.Path()),
+	}
+	g.renderEdit(w, r, vd, gallery)
+}
+
+// ShareLinkRevoke stops a share link from working. Its views are
+// still listed.
+// POST /galleries/:id/links/:linkID/revoke
+func (g *Galleries) ShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	link, err := g.shareLinkByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	if err := g.sls.Revoke(link); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// CollaboratorAdd shares the gallery with another user
+// POST /galleries/:id/collaborators
+func (g *Galleries) CollaboratorAdd(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	var form CollaboratorForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	collaborator, err := g.us.ByEmail(form.Email)
+	switch {
+	case err == models.ErrNotFound:
+		vd.AlertError("There's no account with that email address.")
+	case err != nil:
+		vd.SetAlert(err)
+	case collaborator.ID == gallery.UserID:
+		vd.SetAlert(models.ErrCollaboratorIsOwner)
+	default:
+		err = g.gs.AddCollaborator(gallery.ID, collaborator.ID)
+		if err != nil {
+			vd.SetAlert(err)
+		}
+	}
+	if vd.Alert != nil {
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// CollaboratorRemove stops sharing the gallery with a user
+// POST /galleries/:id/collaborators/:userID/delete
+func (g *Galleries) CollaboratorRemove(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	userID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := g.gs.RemoveCollaborator(gallery.ID, uint(userID)); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// ImageOriginal lets the gallery's owner download an image exactly
+// as it was uploaded, metadata and all
+// GET /galleries/:id/images/:imageID/original
+func (g *Galleries) ImageOriginal(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	f, err := g.is.Original(image)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": image.Filename}))
+	// The original is private, so shared caches must not keep it
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, image.Filename, image.UpdatedAt, f)
+}
+
+// ImageDownload lets visitors with a share link that allows it
+// download the full size image they are shown. Like the page, it has
+// the gallery's metadata policy applied.
+// GET /s/:token/images/:imageID/download
+func (g *Galleries) ImageDownload(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	if !g.canView(w, r, gallery) {
+		return
+	}
+	if link := gallery.ShareLink(); link == nil || !link.AllowDownloads {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	f, err := g.is.Public(image)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": image.Filename}))
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, image.Filename, image.UpdatedAt, f)
+}
+
+// POST /galleries/:id/images/:imageID/delete
+func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	if err := g.is.Delete(image); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// POST /galleries/:id/images/order
+func (g *Galleries) ImageOrder(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	var form ImageOrderForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	if err := g.is.Reorder(gallery.ID, form.Order); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// POST /galleries/:id/images/sort
+func (g *Galleries) ImageSort(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	var form ImageSortForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	if err := g.is.Sort(gallery.ID, form.By); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// imageStatus is one image in the ImageStatus response
+type imageStatus struct {
+	ID     uint   `json:"id"`
+	Status string `json:"status"`
+}
+
+// GET /galleries/:id/images/status
+//
+// ImageStatus reports which of the gallery's images are still being
+// processed, so the edit page can show them once they are ready.
+func (g *Galleries) ImageStatus(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
 	if err != nil {
-		http.Error(w, "Invalid gallery id", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	statuses := make([]imageStatus, 0, len(gallery.Images))
+	for i := range gallery.Images {
+		image := &gallery.Images[i]
+		status, err := g.is.Status(image)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, views.AlertMsgGeneric, http.StatusInternalServerError)
+			return
+		}
+		statuses = append(statuses, imageStatus{ID: image.ID, Status: status})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// POST /galleries/:id/images/:imageID/move
+func (g *Galleries) ImageMove(w http.ResponseWriter, r *http.Request) {
+	g.transferImage(w, r, false)
+}
+
+// POST /galleries/:id/images/:imageID/copy
+func (g *Galleries) ImageCopy(w http.ResponseWriter, r *http.Request) {
+	g.transferImage(w, r, true)
+}
+
+// transferImage moves, or copies when duplicate is true, an image
+// into another of the user's galleries
+func (g *Galleries) transferImage(w http.ResponseWriter, r *http.Request, duplicate bool) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	var form ImageMoveForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	// The destination has to belong to the user as well
+	dst, err := g.gs.ByID(form.GalleryID)
+	if err != nil || dst.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if duplicate {
+		_, err = g.is.Copy(image, dst.ID)
+	} else {
+		err = g.is.Move(image, dst.ID)
+	}
+	if err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// uploadError is a rejected upload, the public message says which
+// file it was
+type uploadError struct {
+	filename string
+	err      views.PublicError
+}
+
+func (e uploadError) Error() string {
+	return e.filename + ": " + e.err.Error()
+}
+
+func (e uploadError) Public() string {
+	return e.filename + ": " + e.err.Public()
+}
+
+// renderEdit renders the edit page for gallery along with the
+// user's other galleries
+func (g *Galleries) renderEdit(w http.ResponseWriter, r *http.Request, vd views.Data, gallery *models.Gallery) {
+	edit := GalleryEdit{Gallery: gallery}
+	if user := context.User(r.Context()); user != nil {
+		edit.DefaultPolicy = user.MetadataPolicy
+	}
+	collaborators, err := g.gs.Collaborators(gallery.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	edit.Collaborators = collaborators
+	links, err := g.sls.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	edit.ShareLinks = links
+	if gallery.Proofing {
+		proofs, err := g.proofSets(gallery, links)
+		if err != nil {
+			log.Println(err)
+		}
+		edit.Proofs = proofs
+	}
+	galleries, err := g.gs.ByUserID(gallery.UserID)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, other := range galleries {
+		if other.ID != gallery.ID {
+			edit.Galleries = append(edit.Galleries, other)
+		}
+	}
+	vd.Yield = edit
+	g.EditView.Render(w, r, vd)
+}
+
+func (g *Galleries) redirectToEdit(w http.ResponseWriter, r *http.Request, galleryID uint) {
+	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", galleryID))
+	if err != nil {
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// shareLinkByID looks up the share link in the URL, making sure it
+// belongs to gallery. Like galleryById it writes the error response
+// itself.
+func (g *Galleries) shareLinkByID(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.ShareLink, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["linkID"])
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, err
+	}
+	link, err := g.sls.ByID(uint(id))
+	if err == nil && link.GalleryID != gallery.ID {
+		err = models.ErrNotFound
+	}
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		http.NotFound(w, r)
+		return nil, err
+	default:
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
 		return nil, err
 	}
-	gallery, err := g.gs.ByID(uint(id))
+	return link, nil
+}
+
+// imageByID looks up the image in the URL, making sure it belongs to
+// gallery. Like galleryById it writes the error response itself.
+func (g *Galleries) imageByID(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.Image, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["imageID"])
+	if err != nil {
+		http.Error(w, "Invalid image id", http.StatusNotFound)
+		return nil, err
+	}
+	image, err := g.is.ByID(uint(id))
+	if err == nil && image.GalleryID != gallery.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	signed := g.signImages(gallery, []models.Image{*image})
+	return &signed[0], nil
+}
+
+// signImages gives images in galleries that aren't public, or have a
+// password, signed URLs so that pages showing them work for anyone
+// they are shown to
+func (g *Galleries) signImages(gallery *models.Gallery, images []models.Image) []models.Image {
+	if gallery.IsPublic() && !gallery.Protected() {
+		return images
+	}
+	return g.urls.Images(images, signedImageTTL)
+}
+
+// canView makes sure the signed in user, if any, can see the gallery.
+// Galleries they can't see are reported as not found, so their ids
+// can't be probed, unless entering the gallery's password would let
+// them in. Then they are sent to the unlock page. Share links that
+// have expired or been used up say so.
+func (g *Galleries) canView(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) bool {
+	if g.shareLinkGone(w, gallery) {
+		return false
+	}
+	var unlock string
+	if cookie, err := r.Cookie(middleware.GalleryUnlockCookie); err == nil {
+		unlock = cookie.Value
+	}
+	ok, err := g.gs.CanView(gallery, context.User(r.Context()), unlock)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		return false
+	}
+	switch {
+	case ok:
+	case gallery.Unlockable():
+		http.Redirect(w, r, gallery.Path()+"/unlock", http.StatusFound)
+	default:
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+	}
+	return ok
+}
+
+// shareLinkGone renders a 410 when the gallery was opened with a
+// share link that has expired or been used up
+func (g *Galleries) shareLinkGone(w http.ResponseWriter, gallery *models.Gallery) bool {
+	link := gallery.ShareLink()
+	if link == nil {
+		return false
+	}
+	var err views.PublicError
+	switch {
+	case link.Expired(time.Now()):
+		err = models.ErrShareLinkExpired
+	case link.UsedUp():
+		err = models.ErrShareLinkUsedUp
+	default:
+		return false
+	}
+	http.Error(w, err.Public(), http.StatusGone)
+	return true
+}
+
+// galleryById looks up the gallery in the URL, either by its id or,
+// for share links, its slug or the link's token. It doesn't check who
+// can see it.
+func (g *Galleries) galleryById(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	vars := mux.Vars(r)
+	var gallery *models.Gallery
+	var err error
+	if slug, ok := vars["slug"]; ok {
+		gallery, err = g.gs.BySlug(slug)
+	} else if token, ok := vars["token"]; ok {
+		gallery, err = g.gs.ByShareLink(token)
+	} else {
+		var id int
+		id, err = strconv.Atoi(vars["id"])
+		if err != nil {
+			http.Error(w, "Invalid gallery id", http.StatusNotFound)
+			return nil, err
+		}
+		gallery, err = g.gs.ByID(uint(id))
+	}
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
@@ -249,6 +1239,6 @@ func (g *Galleries) galleryById(w http.ResponseWriter, r *http.Request) (*models
 		return nil, err
 	}
 	images, _ := g.is.ByGalleryID(gallery.ID)
-	gallery.Images = images
+	gallery.Images = g.signImages(gallery, images)
 	return gallery, nil
 }
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+const (
+	// publicImageMaxAge is how long browsers and shared caches can
+	// keep variants and public copies. Keys of regenerated files
+	// don't change, so this is kept short.
+	publicImageMaxAge = 24 * 60 * 60
+	// privateImageMaxAge is how long a browser can keep an original
+	// for its owner
+	privateImageMaxAge = 60 * 60
+)
+
+// NewImages serves the files in store under /images/. urls checks
+// the signatures of signed URLs.
+func NewImages(gs models.GalleryService, is models.ImageService, urls *models.ImageURLSigner, store storage.BlobStore) *Images {
+	return &Images{
+		gs:    gs,
+		is:    is,
+		urls:  urls,
+		store: store,
+	}
+}
+
+// Images serves the files stored for images. Every request is
+// resolved to an image through the ImageService, so only files that
+// belong to an image can be fetched and there are no directory
+// listings. Originals, which still have all their metadata, are only
+// served to the owner of their gallery.
+//
+// A signed URL (see models.ImageURLSigner) lets anyone holding it see
+// the file until it expires, whether or not they can see the gallery.
+// The w and fmt parameters pick a variant of the image by size rather
+// than by key.
+type Images struct {
+	gs    models.GalleryService
+	is    models.ImageService
+	urls  *models.ImageURLSigner
+	store storage.BlobStore
+}
+
+// ServeHTTP expects the /images/ prefix to have been stripped
+// GET /images/*key
+func (i *Images) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	// Keys are matched exactly, so another spelling of one is never
+	// served. Directories aren't keys and are never listed.
+	if key == "" || key != path.Clean(key) {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	signed := i.urls.Signed(query)
+	var expiresIn time.Duration
+	if signed {
+		var err error
+		expiresIn, err = i.urls.Verify(key, query)
+		switch err {
+		case nil:
+		case models.ErrImageURLExpired:
+			http.Error(w, "This link has expired.", http.StatusGone)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+	}
+	file, err := i.is.File(key)
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		http.NotFound(w, r)
+		return
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	gallery, err := i.gs.ByID(file.Image.GalleryID)
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		http.NotFound(w, r)
+		return
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if ws, format := query.Get(models.ImageWidthParam), query.Get(models.ImageFormatParam); ws != "" || format != "" {
+		var width int
+		if ws != "" {
+			if width, err = strconv.Atoi(ws); err != nil || width <= 0 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		sized := file.Image.SizedKey(width, format)
+		file = &models.ImageFile{Image: file.Image, Key: sized, Original: sized == file.Image.Key}
+	}
+	user := context.User(r.Context())
+	owner := user != nil && user.ID == gallery.UserID
+	visible := signed
+	if !visible {
+		// Galleries found by id are never seen through their share
+		// link, so unlisted ones need signed URLs too. So do ones
+		// with a password, whose unlock cookie is only sent to the
+		// gallery's pages.
+		visible, err = i.gs.CanView(gallery, user, "")
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !visible || (file.Original && !owner) {
+		http.NotFound(w, r)
+		return
+	}
+	// Files anyone can see can be kept by shared caches
+	public := !file.Original && gallery.IsPublic() && !gallery.Protected()
+
+	blob, info, err := i.store.Get(file.Key)
+	switch err {
+	case nil:
+	case storage.ErrNotFound:
+		http.NotFound(w, r)
+		return
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	h := w.Header()
+	contentType := info.ContentType
+	if contentType == "" && file.Original {
+		contentType = file.Image.ContentType
+	}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("ETag", imageETag(file, info))
+	switch {
+	case public:
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", publicImageMaxAge))
+	case signed && !file.Original:
+		// Not past when the link expires
+		maxAge := int(expiresIn / time.Second)
+		if maxAge > privateImageMaxAge {
+			maxAge = privateImageMaxAge
+		}
+		h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	default:
+		// Only the signed in viewer may see it, so shared caches
+		// must not keep it
+		h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", privateImageMaxAge))
+		h.Set("Vary", "Cookie")
+	}
+	// ServeContent handles Range, If-Range, If-None-Match and
+	// If-Modified-Since for us
+	http.ServeContent(w, r, path.Base(file.Key), info.ModTime, blob)
+}
+
+// imageETag is a strong validator for the stored file. Originals are
+// named by the hash of their contents, but variants and public copies
+// are made again under the same key, so their ETag changes whenever
+// they are rewritten.
+func imageETag(file *models.ImageFile, info storage.BlobInfo) string {
+	if file.Original && file.Image.Checksum != "" {
+		return `"` + file.Image.Checksum + `"`
+	}
+	return fmt.Sprintf(`"%.16s-%x-%x"`, file.Image.Checksum, info.ModTime.UnixNano(), info.Size)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+type fakeImageGalleries struct {
+	models.GalleryService
+	gallery *models.Gallery
+}
+
+func (gs *fakeImageGalleries) ByID(id uint) (*models.Gallery, error) {
+	if id != gs.gallery.ID {
+		return nil, models.ErrNotFound
+	}
+	return gs.gallery, nil
+}
+
+func (gs *fakeImageGalleries) CanView(gallery *models.Gallery, user *models.User, unlock string) (bool, error) {
+	return gallery.IsPublic() || (user != nil && user.ID == gallery.UserID), nil
+}
+
+type fakeImageFiles struct {
+	models.ImageService
+	image *models.Image
+}
+
+func (is *fakeImageFiles) File(key string) (*models.ImageFile, error) {
+	if key != is.image.Key && key != is.image.PublicKey {
+		return nil, models.ErrNotFound
+	}
+	return &models.ImageFile{Image: is.image, Key: key, Original: key == is.image.Key}, nil
+}
+
+func TestImagesServeHTTP(t *testing.T) {
+	store := storage.NewMemoryStore()
+	store.Put("galleries/1/ab.jpg", strings.NewReader("original"), "image/jpeg")
+	store.Put("galleries/1/ab_public.jpg", strings.NewReader("public copy"), "image/jpeg")
+	image := &models.Image{
+		Model:       gorm.Model{ID: 3},
+		GalleryID:   1,
+		Key:         "galleries/1/ab.jpg",
+		PublicKey:   "galleries/1/ab_public.jpg",
+		ContentType: "image/jpeg",
+		Checksum:    "ab",
+	}
+	gallery := &models.Gallery{Model: gorm.Model{ID: 1}, UserID: 9, Visibility: models.VisibilityPublic}
+	urls := models.NewImageURLSigner("test-key")
+	images := NewImages(&fakeImageGalleries{gallery: gallery}, &fakeImageFiles{image: image}, urls, store)
+	handler := http.StripPrefix("/images/", images)
+	owner := &models.User{Model: gorm.Model{ID: 9}}
+	stranger := &models.User{Model: gorm.Model{ID: 10}}
+
+	get := func(target string, user *models.User) (int, string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if user != nil {
+			r = r.WithContext(context.WithUser(r.Context(), user))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		b, _ := ioutil.ReadAll(w.Result().Body)
+		return w.Code, string(b)
+	}
+
+	if code, body := get("/images/galleries/1/ab_public.jpg", nil); code != http.StatusOK || body != "public copy" {
+		t.Errorf("Expected the public copy of a public gallery, received %d %q", code, body)
+	}
+	if code, _ := get("/images/galleries/1/ab.jpg", nil); code != http.StatusNotFound {
+		t.Errorf("Expected originals to be hidden from visitors, received %d", code)
+	}
+	if code, _ := get("/images/galleries/1/ab.jpg", stranger); code != http.StatusNotFound {
+		t.Errorf("Expected originals to be hidden from other users, received %d", code)
+	}
+	if code, body := get("/images/galleries/1/ab.jpg", owner); code != http.StatusOK || body != "original" {
+		t.Errorf("Expected the owner to get the original, received %d %q", code, body)
+	}
+	if code, _ := get("/images/galleries/1/missing.jpg", owner); code != http.StatusNotFound {
+		t.Errorf("Expected files that aren't an image's to be 404, received %d", code)
+	}
+
+	gallery.Visibility = models.VisibilityPrivate
+	if code, _ := get("/images/galleries/1/ab_public.jpg", nil); code != http.StatusNotFound {
+		t.Errorf("Expected private galleries to be 404, received %d", code)
+	}
+	if code, _ := get("/images/galleries/1/ab_public.jpg", stranger); code != http.StatusNotFound {
+		t.Errorf("Expected private galleries to be 404 for other users, received %d", code)
+	}
+
+	signed := urls.Sign(image.PublicKey, nil, time.Hour)
+	if code, body := get(signed, nil); code != http.StatusOK || body != "public copy" {
+		t.Errorf("Expected a signed URL to work for a private gallery, received %d %q", code, body)
+	}
+	if code, _ := get(urls.Sign(image.Key, nil, time.Hour), nil); code != http.StatusNotFound {
+		t.Errorf("Expected a signed URL not to give away the original, received %d", code)
+	}
+	if code, _ := get(strings.Replace(signed, "sig=", "sig=x", 1), nil); code != http.StatusNotFound {
+		t.Errorf("Expected a changed signature to be 404, received %d", code)
+	}
+	if code, _ := get(urls.Sign(image.PublicKey, nil, -time.Hour), nil); code != http.StatusGone {
+		t.Errorf("Expected an expired signature to be 410, received %d", code)
+	}
+}
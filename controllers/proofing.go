@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/views"
+)
+
+// ProofState is a client's selection so far, shown on the pages of a
+// gallery in proofing mode that was opened with a share link
+type ProofState struct {
+	Limit     int
+	Count     int
+	Submitted bool
+	Selected  map[uint]bool
+	Notes     map[uint]string
+}
+
+// Full reports whether the client can't select any more images
+func (p *ProofState) Full() bool {
+	return p.Limit > 0 && p.Count >= p.Limit
+}
+
+// ProofSet is what a client said through one of the gallery's share
+// links, for the edit page
+type ProofSet struct {
+	Link   models.ShareLink
+	Images []ProofImage
+	Count  int
+}
+
+// ProofImage is an image a client selected or left a note on
+type ProofImage struct {
+	*models.Image
+	Selected bool
+	Note     string
+}
+
+// ProofSelectForm selects an image, or unselects it when Selected is
+// false
+type ProofSelectForm struct {
+	Selected bool `schema:"selected"`
+}
+
+// ProofNoteForm leaves a note on an image
+type ProofNoteForm struct {
+	Note string `schema:"note"`
+}
+
+// ProofSelect selects or unselects an image for the client
+// POST /s/:token/images/:imageID/select
+func (g *Galleries) ProofSelect(w http.ResponseWriter, r *http.Request) {
+	g.proofImage(w, r, func(gallery *models.Gallery, image *models.Image) error {
+		var form ProofSelectForm
+		if err := parseForm(r, &form); err != nil {
+			return err
+		}
+		return g.ps.Select(gallery, gallery.ShareLink(), image.ID, form.Selected)
+	})
+}
+
+// ProofNote leaves the client's note on an image
+// POST /s/:token/images/:imageID/note
+func (g *Galleries) ProofNote(w http.ResponseWriter, r *http.Request) {
+	g.proofImage(w, r, func(gallery *models.Gallery, image *models.Image) error {
+		var form ProofNoteForm
+		if err := parseForm(r, &form); err != nil {
+			return err
+		}
+		return g.ps.SetNote(gallery, gallery.ShareLink(), image.ID, strings.TrimSpace(form.Note))
+	})
+}
+
+// proofImage runs change for the image in the URL of a gallery opened
+// with a share link. The image's page is shown again afterwards, with
+// an alert when change failed.
+func (g *Galleries) proofImage(w http.ResponseWriter, r *http.Request, change func(*models.Gallery, *models.Image) error) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	if !g.canView(w, r, gallery) {
+		return
+	}
+	if !gallery.Proofing || gallery.ShareLink() == nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	if err := change(gallery, image); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		g.renderImage(w, r, vd, gallery, image)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s/images/%d", gallery.Path(), image.ID), http.StatusFound)
+}
+
+// ProofSubmit sends the client's selection to the photographer
+// POST /s/:token/submit
+func (g *Galleries) ProofSubmit(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	if !g.canView(w, r, gallery) {
+		return
+	}
+	if !gallery.Proofing || gallery.ShareLink() == nil {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	if err := g.ps.Submit(gallery, gallery.ShareLink()); err != nil {
+		vd.SetAlert(err)
+		g.renderShow(w, r, vd, gallery)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Thanks! Your selection has been sent.",
+	}
+	g.renderShow(w, r, vd, gallery)
+}
+
+// ProofReopen lets the client change a selection they have sent
+// POST /galleries/:id/links/:linkID/reopen
+func (g *Galleries) ProofReopen(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	link, err := g.shareLinkByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	if err := g.ps.Reopen(link); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	g.redirectToEdit(w, r, gallery.ID)
+}
+
+// ProofExport downloads the images a client selected through a share
+// link. The csv format lists each image with the client's note. The
+// txt format is the filenames without their extensions, separated by
+// commas, to paste into Lightroom's Library Filter to find them.
+// GET /galleries/:id/links/:linkID/selects.csv
+// GET /galleries/:id/links/:linkID/selects.txt
+func (g *Galleries) ProofExport(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryById(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	link, err := g.shareLinkByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	set, err := g.proofSet(gallery, *link)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Woops something went wrong", http.StatusInternalServerError)
+		return
+	}
+	format := mux.Vars(r)["format"]
+	filename := fmt.Sprintf("gallery-%d-link-%d-selects.%s", gallery.ID, link.ID, format)
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "private, no-store")
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"filename", "title", "note"})
+		for _, image := range set.Images {
+			if image.Selected {
+				cw.Write([]string{csvCell(image.Filename), csvCell(image.Title), csvCell(image.Note)})
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Println(err)
+		}
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var names []string
+		for _, image := range set.Images {
+			if image.Selected {
+				names = append(names, strings.TrimSuffix(image.Filename, path.Ext(image.Filename)))
+			}
+		}
+		fmt.Fprintln(w, strings.Join(names, ", "))
+	}
+}
+
+// csvCell stops text clients and owners typed from being run as a
+// formula when the export is opened in a spreadsheet, by starting
+// cells that look like one with a quote
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// proofState loads the selection of the client looking at the
+// gallery. It is nil unless the gallery is in proofing mode and was
+// opened with a share link.
+func (g *Galleries) proofState(gallery *models.Gallery) (*ProofState, error) {
+	link := gallery.ShareLink()
+	if !gallery.Proofing || link == nil {
+		return nil, nil
+	}
+	selections, err := g.ps.ByShareLink(link.ID)
+	if err != nil {
+		return nil, err
+	}
+	state := &ProofState{
+		Limit:     gallery.SelectLimit,
+		Submitted: link.SubmittedAt != nil,
+		Selected:  make(map[uint]bool),
+		Notes:     make(map[uint]string),
+	}
+	for _, s := range selections {
+		state.Selected[s.ImageID] = s.Selected
+		state.Notes[s.ImageID] = s.Note
+	}
+	// Only images still in the gallery count
+	for _, image := range gallery.Images {
+		if state.Selected[image.ID] {
+			state.Count++
+		}
+	}
+	return state, nil
+}
+
+// proofSets is what clients said through each of links, leaving out
+// links nothing was said through
+func (g *Galleries) proofSets(gallery *models.Gallery, links []models.ShareLink) ([]ProofSet, error) {
+	var sets []ProofSet
+	for _, link := range links {
+		set, err := g.proofSet(gallery, link)
+		if err != nil {
+			return sets, err
+		}
+		if len(set.Images) > 0 {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// proofSet is what a client said through link, in the gallery's
+// order
+func (g *Galleries) proofSet(gallery *models.Gallery, link models.ShareLink) (ProofSet, error) {
+	set := ProofSet{Link: link}
+	selections, err := g.ps.ByShareLink(link.ID)
+	if err != nil {
+		return set, err
+	}
+	byImage := make(map[uint]models.ProofSelection, len(selections))
+	for _, s := range selections {
+		byImage[s.ImageID] = s
+	}
+	for i := range gallery.Images {
+		s, ok := byImage[gallery.Images[i].ID]
+		if !ok || (!s.Selected && s.Note == "") {
+			continue
+		}
+		set.Images = append(set.Images, ProofImage{Image: &gallery.Images[i], Selected: s.Selected, Note: s.Note})
+		if s.Selected {
+			set.Count++
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,22 @@
+package controllers
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	cases := map[string]string{
+		"":                   "",
+		"DSC_0001.jpg":       "DSC_0001.jpg",
+		"=HYPERLINK(\"x\")":  "'=HYPERLINK(\"x\")",
+		"+1":                 "'+1",
+		"-1+2":               "'-1+2",
+		"@SUM(A1)":           "'@SUM(A1)",
+		"\t=1":               "'\t=1",
+		"\r=1":               "'\r=1",
+		"crop a bit = nicer": "crop a bit = nicer",
+	}
+	for in, want := range cases {
+		if got := csvCell(in); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/config"
+	"lenslocked.com/context"
+	"lenslocked.com/middleware"
+	"lenslocked.com/models"
+	"lenslocked.com/views"
+)
+
+func NewSessions(ss models.SessionService, tc config.TemplateConfig) *Sessions {
+	return &Sessions{
+		IndexView: views.NewView(tc, "bootstrap", "sessions/index"),
+		ss:        ss,
+	}
+}
+
+// Sessions lets a user see every device they are signed in on
+// and sign any of them out
+type Sessions struct {
+	IndexView *views.View
+	ss        models.SessionService
+}
+
+// SessionList is what the sessions index view expects to receive
+type SessionList struct {
+	Sessions  []models.Session
+	CurrentID uint
+}
+
+// GET /sessions
+func (s *Sessions) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	s.render(w, r, vd)
+}
+
+// POST /sessions/:id/revoke
+func (s *Sessions) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	// verify the session actually belongs to this user
+	user := context.User(r.Context())
+	session, err := s.ss.ByID(uint(id))
+	if err != nil || session.UserID != user.ID {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	var vd views.Data
+	if err := s.ss.Delete(session.ID); err != nil {
+		vd.SetAlert(err)
+		s.render(w, r, vd)
+		return
+	}
+	if current := context.Session(r.Context()); current != nil && current.ID == session.ID {
+		middleware.ClearSessionCookie(w, r)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/sessions", http.StatusFound)
+}
+
+// POST /sessions/revoke-others
+func (s *Sessions) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	current := context.Session(r.Context())
+	var vd views.Data
+	sessions, err := s.ss.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		s.render(w, r, vd)
+		return
+	}
+	for _, session := range sessions {
+		if current != nil && session.ID == current.ID {
+			continue
+		}
+		if err := s.ss.Delete(session.ID); err != nil {
+			vd.SetAlert(err)
+			s.render(w, r, vd)
+			return
+		}
+	}
+	http.Redirect(w, r, "/sessions", http.StatusFound)
+}
+
+// render looks up the user's sessions and renders them along with
+// any alert already set on vd
+func (s *Sessions) render(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	sessions, err := s.ss.ByUserID(user.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	list := SessionList{Sessions: sessions}
+	if current := context.Session(r.Context()); current != nil {
+		list.CurrentID = current.ID
+	}
+	vd.Yield = list
+	s.IndexView.Render(w, r, vd)
+}
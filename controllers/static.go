@@ -1,13 +1,14 @@
 package controllers
 
 import (
+	"lenslocked.com/config"
 	"lenslocked.com/views"
 )
 
-func NewStatic() *Static {
+func NewStatic(tc config.TemplateConfig) *Static {
 	return &Static{
-		Home:    views.NewView("bootstrap", "static/home"),
-		Contact: views.NewView("bootstrap", "static/contact"),
+		Home:    views.NewView(tc, "bootstrap", "static/home"),
+		Contact: views.NewView(tc, "bootstrap", "static/contact"),
 	}
 }
 
@@ -1,9 +1,15 @@
 package controllers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 
+	"lenslocked.com/config"
+	"lenslocked.com/context"
+	"lenslocked.com/mail"
+	"lenslocked.com/middleware"
 	"lenslocked.com/models"
 	"lenslocked.com/rand"
 
@@ -13,18 +19,47 @@ import (
 // NewUsers is used to create a new users controller.NewUsers
 // This funtion will panic if the templates are not parsed correctly
 // and shoudl be used only during initial setup
-func NewUsers(us models.UserService) *Users {
+//
+// baseURL is used to build the absolute links we email to users.
+// is is used to redo the public copies of the user's images when
+// they change their metadata policy.
+func NewUsers(us models.UserService, ss models.SessionService, is models.ImageService, mailer mail.Mailer, baseURL string, tc config.TemplateConfig) *Users {
 	return &Users{
-		NewView:   views.NewView("bootstrap", "users/new"),
-		LoginView: views.NewView("bootstrap", "users/login"),
-		us:        us,
+		NewView:     views.NewView(tc, "bootstrap", "users/new"),
+		LoginView:   views.NewView(tc, "bootstrap", "users/login"),
+		ForgotView:  views.NewView(tc, "bootstrap", "users/forgot_pw"),
+		ResetView:   views.NewView(tc, "bootstrap", "users/reset_pw"),
+		AccountView: views.NewView(tc, "bootstrap", "users/account"),
+		resetEmail:  mail.NewTemplate(tc, "reset_pw"),
+		verifyEmail: mail.NewTemplate(tc, "verify_email"),
+		us:          us,
+		ss:          ss,
+		is:          is,
+		mailer:      mailer,
+		baseURL:     baseURL,
 	}
 }
 
 type Users struct {
-	NewView   *views.View
-	LoginView *views.View
-	us        models.UserService
+	NewView     *views.View
+	LoginView   *views.View
+	ForgotView  *views.View
+	ResetView   *views.View
+	AccountView *views.View
+	us          models.UserService
+	ss          models.SessionService
+	is          models.ImageService
+	mailer      mail.Mailer
+	baseURL     string
+	resetEmail  *mail.Template
+	verifyEmail *mail.Template
+}
+
+// EmailData is passed to every email template
+type EmailData struct {
+	Name  string
+	Email string
+	Link  string
 }
 
 // New is used to render the form where they can create a new user account
@@ -40,7 +75,7 @@ type SignupForm struct {
 	Password string `schema:"password"`
 }
 
-//Create is used to process the signup form to create a new account
+// Create is used to process the signup form to create a new account
 // POST /signup
 func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
@@ -61,7 +96,11 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		u.NewView.Render(w, r, vd)
 		return
 	}
-	err := u.signIn(w, &user)
+	if err := u.sendVerification(&user, user.Email); err != nil {
+		// They can resend it from their account page
+		log.Println(err)
+	}
+	err := u.signIn(w, r, &user)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -98,7 +137,7 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		u.LoginView.Render(w, r, vd)
 		return
 	}
-	err = u.signIn(w, user)
+	err = u.signIn(w, r, user)
 	if err != nil {
 		vd.SetAlert(err)
 		u.LoginView.Render(w, r, vd)
@@ -107,24 +146,304 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
-// signIn is used to sign the given user in via cookies
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
+// ResetPwForm is used by both the forgot password and the reset
+// password forms
+type ResetPwForm struct {
+	Email    string `schema:"email"`
+	Token    string `schema:"token"`
+	Password string `schema:"password"`
+}
+
+// InitiateReset emails the user a link to reset their password.
+// The same message is shown whether or not the email address belongs
+// to an account so this can't be used to find out who has signed up.
+// POST /forgot
+func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.ForgotView.Render(w, r, vd)
+		return
+	}
+
+	token, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		link := fmt.Sprintf("%s/reset?token=%s", u.baseURL, url.QueryEscape(token))
+		err = u.resetEmail.Send(u.mailer, form.Email, EmailData{Email: form.Email, Link: link})
 		if err != nil {
-			return err
+			vd.SetAlert(err)
+			u.ForgotView.Render(w, r, vd)
+			return
 		}
-		user.Remember = token
-		err = u.us.Update(user)
-		if err != nil {
-			return err
+	case models.ErrNotFound:
+	default:
+		vd.SetAlert(err)
+		u.ForgotView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "If that address has an account, instructions for resetting your password are on their way.",
+	}
+	u.ForgotView.Render(w, r, vd)
+}
+
+// ResetPw renders the reset password form with the token from the
+// emailed link filled in
+// GET /reset
+func (u *Users) ResetPw(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	form.Token = r.URL.Query().Get("token")
+	u.ResetView.Render(w, r, vd)
+}
+
+// CompleteReset sets the user's new password and signs them in
+// POST /reset
+func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.ResetView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetView.Render(w, r, vd)
+		return
+	}
+	if err := u.signIn(w, r, user); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+// EmailForm is used to change the user's email address
+type EmailForm struct {
+	Email string `schema:"email"`
+}
+
+// Account shows the user's email address and whether it has been
+// verified yet
+// GET /account
+func (u *Users) Account(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	u.renderAccount(w, r, vd)
+}
+
+// ResendVerification emails the user a new verification link
+// POST /account/verify
+func (u *Users) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	email := user.PendingEmail
+	if email == "" {
+		email = user.Email
+	}
+	if err := u.sendVerification(user, email); err != nil {
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "We've sent a new verification link to " + email + ".",
+	}
+	u.renderAccount(w, r, vd)
+}
+
+// ChangeEmail stores the new address as pending and emails it a
+// verification link. The old address stays in use until then.
+// POST /account/email
+func (u *Users) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form EmailForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	if err := u.us.RequestEmailChange(user, form.Email); err != nil {
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	if user.PendingEmail == "" {
+		// They asked to change to the address they already have
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+	if err := u.sendVerification(user, user.PendingEmail); err != nil {
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Check " + user.PendingEmail + " for a link to confirm your new address.",
+	}
+	u.renderAccount(w, r, vd)
+}
+
+// MetadataPolicyForm is used to pick what metadata is left in the
+// public copies of images
+type MetadataPolicyForm struct {
+	MetadataPolicy string `schema:"metadata_policy"`
+}
+
+// MetadataPolicy changes the user's default metadata policy and
+// remakes the public copies of images in galleries that use it
+// POST /account/metadata
+func (u *Users) MetadataPolicy(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form MetadataPolicyForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	if form.MetadataPolicy == user.MetadataPolicy {
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+	previous := user.MetadataPolicy
+	user.MetadataPolicy = form.MetadataPolicy
+	if err := u.us.Update(user); err != nil {
+		user.MetadataPolicy = previous
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	if err := u.is.ReprocessUser(user.ID); err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your metadata setting was saved. Public copies of your images are being updated.",
+	}
+	u.renderAccount(w, r, vd)
+}
+
+// Verify handles the link emailed by sendVerification
+// GET /verify
+func (u *Users) Verify(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user, err := u.us.Verify(r.URL.Query().Get("token"))
+	if err != nil {
+		vd.SetAlert(err)
+		u.renderAccount(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: user.Email + " has been verified. Thanks!",
+	}
+	u.renderAccount(w, r, vd)
+}
+
+// renderAccount shows the account page for the signed in user, or
+// sends them to the login page when nobody is signed in
+func (u *Users) renderAccount(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	user := context.User(r.Context())
+	if user == nil {
+		if vd.Alert != nil && vd.Alert.Level == views.AlertLvlSuccess {
+			// Verified from a browser that isn't signed in
+			u.LoginView.Render(w, r, vd)
+			return
 		}
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
 	}
-	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
-		HttpOnly: true,
+	vd.Yield = user
+	u.AccountView.Render(w, r, vd)
+}
+
+// sendVerification emails a verification link for email, which is
+// either the user's current address or the one they are changing to
+func (u *Users) sendVerification(user *models.User, email string) error {
+	token := u.us.VerificationToken(user, email)
+	link := fmt.Sprintf("%s/verify?token=%s", u.baseURL, url.QueryEscape(token))
+	return u.verifyEmail.Send(u.mailer, email, EmailData{
+		Name:  user.Name,
+		Email: email,
+		Link:  link,
+	})
+}
+
+// signIn is used to sign the given user in via cookies. Every sign in
+// starts a new session so each device can be signed out on its own.
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session, err := u.ss.Start(user.ID, r.UserAgent(), middleware.ClientIP(r))
+	if err != nil {
+		return err
 	}
-	http.SetCookie(w, &cookie)
+	middleware.SetSessionCookie(w, r, session)
 	return nil
 }
+
+// Logout signs the current device out by deleting its session and
+// expiring the cookie. The remember token is rotated as well so that
+// any cookie from before we had sessions stops working too.
+// POST /logout
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	if session := context.Session(r.Context()); session != nil {
+		if err := u.ss.Delete(session.ID); err != nil {
+			log.Println(err)
+		}
+	}
+	if err := u.rotateRemember(context.User(r.Context())); err != nil {
+		log.Println(err)
+	}
+	middleware.ClearSessionCookie(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// LogoutAll signs the user out of every device they are signed in on
+// POST /logout/all
+func (u *Users) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := u.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if err := u.rotateRemember(user); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	middleware.ClearSessionCookie(w, r)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// rotateRemember gives the user a new remember token, which
+// invalidates the stored RememberHash
+func (u *Users) rotateRemember(user *models.User) error {
+	if user == nil {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	user.Remember = token
+	return u.us.Update(user)
+}
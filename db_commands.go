@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"lenslocked.com/migrations"
+)
+
+func migrateCommand() *command {
+	return &command{
+		name:  "migrate",
+		short: "manage the database schema",
+		subs: []*command{
+			{name: "up", args: "[-dry-run]", short: "apply pending migrations", run: migrateUp},
+			{name: "down", args: "[-steps n] [-dry-run]", short: "roll back applied migrations", run: migrateDown},
+			{name: "status", short: "show which migrations have been applied", run: migrateStatus},
+		},
+	}
+}
+
+func dbCommand() *command {
+	return &command{
+		name:  "db",
+		short: "database maintenance",
+		subs: []*command{
+			{
+				name:  "reset",
+				args:  "-confirm <database name>",
+				short: "roll back every migration and apply them again",
+				run:   dbReset,
+			},
+		},
+	}
+}
+
+// migrator parses the -dry-run flag shared by the migrate commands
+// and returns a migrator set up to match
+func migrator(a *app, fs *flag.FlagSet, args []string) (*migrations.Migrator, error) {
+	dryRun := fs.Bool("dry-run", false, "print the SQL instead of running it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return nil, err
+	}
+	m, err := services.Migrator()
+	if err != nil {
+		return nil, err
+	}
+	m.DryRun = *dryRun
+	m.Out = a.out
+	return m, nil
+}
+
+func migrateUp(a *app, args []string) error {
+	m, err := migrator(a, newFlagSet(a, "migrate up"), args)
+	if err != nil {
+		return err
+	}
+	n, err := m.Up()
+	if err != nil {
+		return err
+	}
+	if !m.DryRun {
+		fmt.Fprintf(a.out, "Applied %d migration(s)\n", n)
+	}
+	return nil
+}
+
+func migrateDown(a *app, args []string) error {
+	fs := newFlagSet(a, "migrate down")
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	m, err := migrator(a, fs, args)
+	if err != nil {
+		return err
+	}
+	n, err := m.Down(*steps)
+	if err != nil {
+		return err
+	}
+	if !m.DryRun {
+		fmt.Fprintf(a.out, "Rolled back %d migration(s)\n", n)
+	}
+	return nil
+}
+
+func migrateStatus(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	m, err := services.Migrator()
+	if err != nil {
+		return err
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return tw.Flush()
+}
+
+// dbReset wipes the database. The name of the database has to be
+// passed to -confirm so it can't be run against the wrong one by accident.
+func dbReset(a *app, args []string) error {
+	fs := newFlagSet(a, "db reset")
+	confirm := fs.String("confirm", "", "name of the database being reset")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	if *confirm != a.cfg.Database.Name {
+		return fmt.Errorf("refusing to reset: pass -confirm %s to wipe that database", a.cfg.Database.Name)
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	if err := services.DestructiveReset(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Reset database %s\n", a.cfg.Database.Name)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"lenslocked.com/models"
+)
+
+func galleryCommand() *command {
+	return &command{
+		name:  "gallery",
+		short: "manage galleries",
+		subs: []*command{
+			{name: "list", args: "[-user <email>]", short: "list galleries", run: galleryList},
+			{name: "delete", args: "<id>", short: "delete a gallery", run: galleryDelete},
+			{name: "import-images", args: "[<id>]", short: "record images already in storage in the database", run: galleryImportImages},
+		},
+	}
+}
+
+func galleryList(a *app, args []string) error {
+	fs := newFlagSet(a, "gallery list")
+	email := fs.String("user", "", "only list galleries owned by this email address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	var galleries []models.Gallery
+	if *email == "" {
+		galleries, err = services.Gallery.All()
+	} else {
+		var user *models.User
+		user, err = services.User.ByEmail(*email)
+		if err != nil {
+			return err
+		}
+		galleries, err = services.Gallery.ByUserID(user.ID)
+	}
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tTITLE\tVISIBILITY\tCREATED")
+	for _, g := range galleries {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n",
+			g.ID, g.UserID, g.Title, g.Visibility, g.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+func galleryDelete(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	gallery, err := services.Gallery.ByID(uint(id))
+	if err != nil {
+		return err
+	}
+	if err := services.Gallery.Delete(gallery.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Deleted gallery %d %q\n", gallery.ID, gallery.Title)
+	return nil
+}
+
+// galleryImportImages adds database records for image files that were
+// uploaded before images were stored in the database. Without an id
+// every gallery is imported.
+func galleryImportImages(a *app, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	var galleries []models.Gallery
+	if len(args) == 1 {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errUsage
+		}
+		gallery, err := services.Gallery.ByID(uint(id))
+		if err != nil {
+			return err
+		}
+		galleries = append(galleries, *gallery)
+	} else {
+		galleries, err = services.Gallery.All()
+		if err != nil {
+			return err
+		}
+	}
+	for _, g := range galleries {
+		n, err := services.Image.Import(g.ID)
+		if err != nil {
+			return fmt.Errorf("gallery %d: %v", g.ID, err)
+		}
+		if n > 0 {
+			fmt.Fprintf(a.out, "Imported %d images into gallery %d\n", n, g.ID)
+		}
+	}
+	return nil
+}
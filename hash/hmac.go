@@ -4,29 +4,35 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
 )
 
 // NewHMAC creates and returns a new HMAC object
 func NewHMAC(secretKey string) HMAC {
-	h := hmac.New(sha256.New, []byte(secretKey))
 	return HMAC{
-		HMAC: h,
+		key: []byte(secretKey),
 	}
 }
 
 // HMAC is a wrapper around the crypto/hmac package making it easier to use
-// in our code
+// in our code. It is safe to use from multiple goroutines at once.
 type HMAC struct {
-	HMAC hash.Hash
+	key []byte
 }
 
 // Hash will hash the provided input string using HMAC with
 // the secret ey provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
-	h.HMAC.Reset()
-	h.HMAC.Write([]byte(input))
-	b := h.HMAC.Sum(nil)
+	// A fresh hash.Hash per call, they can't be shared between requests
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
 	// base64 makes sure it is a valid utf8 string which is url safe
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// Equal reports whether hashed is the hash of input. The comparison
+// takes the same time no matter where the two differ so it is safe
+// for checking signatures sent to us by users.
+func (h HMAC) Equal(input, hashed string) bool {
+	return hmac.Equal([]byte(h.Hash(input)), []byte(hashed))
+}
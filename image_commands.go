@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"lenslocked.com/models"
+)
+
+func imageCommand() *command {
+	return &command{
+		name:  "image",
+		short: "manage images",
+		subs: []*command{
+			{name: "regenerate", args: "[-gallery <id>] [<image id>...]", short: "remake resized image variants", run: imageRegenerate},
+			{name: "metadata", args: "[-gallery <id>] [<image id>...]", short: "read metadata from stored images", run: imageMetadata},
+			{name: "url", args: "[-ttl <duration>] [-width <px>] [-webp] <image id>", short: "print a signed link to an image", run: imageURL},
+		},
+	}
+}
+
+// imageRegenerate remakes the variants of the given images, of every
+// image in a gallery, or of every image there is
+func imageRegenerate(a *app, args []string) error {
+	services, images, err := selectImages(a, "image regenerate", args)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i := range images {
+		image := &images[i]
+		if err := services.Image.Process(image); err != nil {
+			fmt.Fprintf(a.out, "Image %d: %v\n", image.ID, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(a.out, "Image %d: %s\n", image.ID, variantSummary(image))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(images))
+	}
+	return nil
+}
+
+// imageMetadata reads the metadata of images uploaded before it was
+// read at upload. Fields an image already has are left alone.
+func imageMetadata(a *app, args []string) error {
+	services, images, err := selectImages(a, "image metadata", args)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i := range images {
+		image := &images[i]
+		if err := services.Image.ReadMetadata(image); err != nil {
+			fmt.Fprintf(a.out, "Image %d: %v\n", image.ID, err)
+			failed++
+			continue
+		}
+		summary := "no capture time"
+		if image.CapturedAt != nil {
+			summary = "taken " + image.CapturedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(a.out, "Image %d: %s\n", image.ID, summary)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(images))
+	}
+	return nil
+}
+
+// imageURL prints a signed link that shows the image to anyone until
+// it expires, even if its gallery isn't public
+func imageURL(a *app, args []string) error {
+	fs := newFlagSet(a, "image url")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the link works for")
+	width := fs.Int("width", 0, "link to the variant closest to this width")
+	webp := fs.Bool("webp", false, "link to the WebP variant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *ttl <= 0 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	image, err := services.Image.ByID(uint(id))
+	if err != nil {
+		return fmt.Errorf("image %d: %v", id, err)
+	}
+	key := image.PublicKey
+	if key == "" {
+		return fmt.Errorf("image %d hasn't been processed yet", id)
+	}
+	params := url.Values{}
+	if *width > 0 {
+		params.Set(models.ImageWidthParam, strconv.Itoa(*width))
+	}
+	if *webp {
+		params.Set(models.ImageFormatParam, "webp")
+	}
+	fmt.Fprintln(a.out, a.cfg.BaseURL+services.ImageURLs.Sign(key, params, *ttl))
+	return nil
+}
+
+// selectImages parses the arguments shared by the image commands and
+// returns the images they pick: the ones listed by id, every image in
+// the -gallery, or every image there is
+func selectImages(a *app, name string, args []string) (*models.Services, []models.Image, error) {
+	fs := newFlagSet(a, name)
+	galleryID := fs.Uint("gallery", 0, "only use images in this gallery")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	services, err := a.Services()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var images []models.Image
+	switch {
+	case fs.NArg() > 0:
+		for _, arg := range fs.Args() {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, nil, errUsage
+			}
+			image, err := services.Image.ByID(uint(id))
+			if err != nil {
+				return nil, nil, fmt.Errorf("image %d: %v", id, err)
+			}
+			images = append(images, *image)
+		}
+	case *galleryID > 0:
+		images, err = services.Image.ByGalleryID(*galleryID)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		galleries, err := services.Gallery.All()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, g := range galleries {
+			gi, err := services.Image.ByGalleryID(g.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			images = append(images, gi...)
+		}
+	}
+	return services, images, nil
+}
+
+func variantSummary(image *models.Image) string {
+	if image.VariantWidths == "" {
+		return "too small for variants"
+	}
+	formats := "jpeg"
+	if image.VariantWebP {
+		formats += " and webp"
+	}
+	return fmt.Sprintf("widths %s in %s", image.VariantWidths, formats)
+}
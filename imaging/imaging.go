@@ -0,0 +1,116 @@
+// Package imaging resizes and encodes the smaller versions of images
+// that are shown on gallery pages
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"golang.org/x/image/draw"
+)
+
+// Encoder writes images in a single format
+type Encoder interface {
+	Encode(w io.Writer, img image.Image) error
+	// Ext is the extension files in this format get, like ".jpg"
+	Ext() string
+	ContentType() string
+}
+
+// Resize scales img down to width pixels wide, keeping its aspect
+// ratio. Transparent areas are filled in white so the result can be
+// saved as a JPEG.
+func Resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || width > b.Dx() {
+		width = b.Dx()
+	}
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// NewJPEG encodes JPEGs at the given quality, from 1 to 100
+func NewJPEG(quality int) Encoder {
+	return jpegEncoder{quality}
+}
+
+type jpegEncoder struct {
+	quality int
+}
+
+func (je jpegEncoder) Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: je.quality})
+}
+
+func (jpegEncoder) Ext() string         { return ".jpg" }
+func (jpegEncoder) ContentType() string { return "image/jpeg" }
+
+// NewCWebP encodes WebP images by running the cwebp tool from
+// libwebp, since there is no WebP encoder in the standard library.
+// An error is returned if cwebp can't be found.
+func NewCWebP(path string, quality int) (Encoder, error) {
+	bin, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("imaging: webp variants need cwebp: %v", err)
+	}
+	return cwebpEncoder{bin: bin, quality: quality}, nil
+}
+
+type cwebpEncoder struct {
+	bin     string
+	quality int
+}
+
+// Encode hands cwebp a PNG in a temp file and copies the WebP it
+// writes back out to w
+func (ce cwebpEncoder) Encode(w io.Writer, img image.Image) error {
+	dir, err := ioutil.TempDir("", "lenslocked-webp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	in, out := dir+"/in.png", dir+"/out.webp"
+	f, err := os.Create(in)
+	if err != nil {
+		return err
+	}
+	// Speed matters more than size for a file that is read once
+	enc := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := enc.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(ce.bin, "-quiet", "-q", fmt.Sprint(ce.quality), in, "-o", out)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("imaging: cwebp failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	webp, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer webp.Close()
+	_, err = io.Copy(w, webp)
+	return err
+}
+
+func (cwebpEncoder) Ext() string         { return ".webp" }
+func (cwebpEncoder) ContentType() string { return "image/webp" }
@@ -0,0 +1,67 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 300))
+	got := Resize(src, 100).Bounds()
+	if got.Dx() != 100 || got.Dy() != 75 {
+		t.Errorf("Expected 100x75, received %dx%d", got.Dx(), got.Dy())
+	}
+	// Images are never made bigger
+	got = Resize(src, 1600).Bounds()
+	if got.Dx() != 400 || got.Dy() != 300 {
+		t.Errorf("Expected 400x300, received %dx%d", got.Dx(), got.Dy())
+	}
+}
+
+func TestResizeFillsTransparency(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	r, g, b, _ := Resize(src, 5).At(2, 2).RGBA()
+	if r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("Expected transparent pixels to become white, received %v %v %v", r, g, b)
+	}
+}
+
+func TestJPEG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	src.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	var buf bytes.Buffer
+	enc := NewJPEG(80)
+	if err := enc.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 10 {
+		t.Errorf("Unexpected size %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestCWebP(t *testing.T) {
+	enc, err := NewCWebP("cwebp", 80)
+	if err != nil {
+		t.Skip(err)
+	}
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := webp.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 10 {
+		t.Errorf("Unexpected size %dx%d", cfg.Width, cfg.Height)
+	}
+}
@@ -0,0 +1,349 @@
+// Package jobs is a durable background job queue kept in Postgres.
+// Jobs survive restarts, are retried with exponential backoff when
+// they fail, and end up dead once they run out of attempts so that
+// they can be looked at and retried by hand.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	// StatusDead jobs failed every attempt and won't run again
+	// unless they are retried
+	StatusDead = "dead"
+)
+
+var (
+	// ErrNotFound is returned when there is no job with the given id
+	ErrNotFound = errors.New("jobs: job not found")
+	// ErrNoHandler is recorded against jobs of a kind nothing handles
+	ErrNoHandler = errors.New("jobs: no handler for job kind")
+)
+
+// Job is a single unit of background work
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	LastError   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Decode unmarshals the job's payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler does the work for one kind of job. Returning an error
+// schedules a retry.
+type Handler func(ctx context.Context, job *Job) error
+
+// Config controls how jobs are run
+type Config struct {
+	// Workers is how many jobs run at once
+	Workers int
+	// PollInterval is how long idle workers wait before looking for
+	// new jobs
+	PollInterval time.Duration
+	// Attempts is how many times a job is tried before it is dead
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles after
+	// every failed attempt
+	Backoff time.Duration
+	// LockTimeout is how long a job can be running before we assume
+	// its worker died and queue it again
+	LockTimeout time.Duration
+}
+
+// NewQueue returns a queue that stores its jobs in db. Nothing runs
+// until Run is called.
+func NewQueue(db *sql.DB, cfg Config) *Queue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = 1
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 10 * time.Second
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 30 * time.Minute
+	}
+	return &Queue{
+		db:       db,
+		cfg:      cfg,
+		handlers: make(map[string]Handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Queue hands jobs out to a pool of workers
+type Queue struct {
+	db  *sql.DB
+	cfg Config
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	// wake lets Enqueue start an idle worker without waiting for the
+	// next poll
+	wake chan struct{}
+}
+
+// Handle registers the handler for jobs of the given kind
+func (q *Queue) Handle(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+// Enqueue adds a job that runs as soon as a worker is free. payload
+// is stored as JSON.
+func (q *Queue) Enqueue(kind string, payload interface{}) (int64, error) {
+	return q.EnqueueAt(kind, payload, time.Now())
+}
+
+// EnqueueAt adds a job that won't run before runAt
+func (q *Queue) EnqueueAt(kind string, payload interface{}, runAt time.Time) (int64, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = q.db.QueryRow(`INSERT INTO jobs (kind, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4) RETURNING id`,
+		kind, string(b), q.cfg.Attempts, runAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return id, nil
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts,
+	run_at, last_error, created_at, updated_at`
+
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
+	var job Job
+	var payload string
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.Status, &job.Attempts,
+		&job.MaxAttempts, &job.RunAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = json.RawMessage(payload)
+	return &job, nil
+}
+
+// ByID looks up a job so its status can be checked
+func (q *Queue) ByID(id int64) (*Job, error) {
+	return scanJob(q.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+}
+
+// ByStatus lists the jobs with the given status, oldest first
+func (q *Queue) ByStatus(status string) ([]Job, error) {
+	rows, err := q.db.Query(`SELECT `+jobColumns+` FROM jobs
+		WHERE status = $1 ORDER BY id`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// Retry queues a dead job to run again with a fresh set of attempts
+func (q *Queue) Retry(id int64) error {
+	res, err := q.db.Exec(`UPDATE jobs SET status = $2, attempts = 0,
+		run_at = now(), updated_at = now() WHERE id = $1 AND status = $3`,
+		id, StatusQueued, StatusDead)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteDone removes finished jobs last updated before the given time
+func (q *Queue) DeleteDone(before time.Time) (int64, error) {
+	res, err := q.db.Exec(`DELETE FROM jobs WHERE status = $1 AND updated_at < $2`,
+		StatusDone, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Run starts the worker pool and blocks until ctx is cancelled and
+// every running job has returned. Jobs are handed the same ctx so
+// that long running ones can stop early on shutdown, in which case
+// they are retried later.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	// Requeue jobs left running by workers that died
+	ticker := time.NewTicker(q.cfg.LockTimeout / 2)
+	defer ticker.Stop()
+	for {
+		if err := q.requeueStale(); err != nil {
+			log.Println("jobs: requeueing stale jobs:", err)
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		job, err := q.claim()
+		if err != nil {
+			log.Println("jobs: claiming job:", err)
+		}
+		if job != nil {
+			q.run(ctx, job)
+			continue
+		}
+		// Nothing to do, wait for a poll or a new job
+		t := time.NewTimer(q.cfg.PollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-q.wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// claim takes the next due job, if there is one. SKIP LOCKED lets any
+// number of workers, in any number of processes, claim jobs at once
+// without handing the same job out twice.
+func (q *Queue) claim() (*Job, error) {
+	job, err := scanJob(q.db.QueryRow(`UPDATE jobs
+		SET status = $1, attempts = attempts + 1, locked_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $2 AND run_at <= now()
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, StatusRunning, StatusQueued))
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return job, err
+}
+
+// run calls the job's handler and records how it went
+func (q *Queue) run(ctx context.Context, job *Job) {
+	q.mu.RLock()
+	h, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = ErrNoHandler
+	} else {
+		err = safeRun(ctx, h, job)
+	}
+	if err == nil {
+		_, err = q.db.Exec(`UPDATE jobs SET status = $2, last_error = '',
+			locked_at = NULL, updated_at = now() WHERE id = $1`, job.ID, StatusDone)
+		if err != nil {
+			log.Printf("jobs: marking job %d done: %v", job.ID, err)
+		}
+		return
+	}
+
+	status, runAt := StatusQueued, time.Now().Add(Backoff(q.cfg.Backoff, job.Attempts))
+	if job.Attempts >= job.MaxAttempts || err == ErrNoHandler {
+		status = StatusDead
+		log.Printf("jobs: %s job %d is dead after %d attempts: %v", job.Kind, job.ID, job.Attempts, err)
+	} else {
+		log.Printf("jobs: %s job %d failed, retrying at %s: %v", job.Kind, job.ID, runAt.Format(time.RFC3339), err)
+	}
+	_, dbErr := q.db.Exec(`UPDATE jobs SET status = $2, run_at = $3, last_error = $4,
+		locked_at = NULL, updated_at = now() WHERE id = $1`,
+		job.ID, status, runAt, err.Error())
+	if dbErr != nil {
+		log.Printf("jobs: recording failure of job %d: %v", job.ID, dbErr)
+	}
+}
+
+// safeRun turns a panicking handler into a failed attempt
+func safeRun(ctx context.Context, h Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: handler panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (q *Queue) requeueStale() error {
+	_, err := q.db.Exec(`UPDATE jobs SET status = $1, locked_at = NULL, updated_at = now()
+		WHERE status = $2 AND locked_at < $3`,
+		StatusQueued, StatusRunning, time.Now().Add(-q.cfg.LockTimeout))
+	return err
+}
+
+// Backoff is how long to wait before retrying a job that has failed
+// attempts times: base, then twice that, and so on, capped at a day
+func Backoff(base time.Duration, attempts int) time.Duration {
+	const max = 24 * time.Hour
+	d := base
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
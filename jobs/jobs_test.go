@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lenslocked.com/config"
+	"lenslocked.com/migrations"
+
+	_ "github.com/lib/pq"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{30, 24 * time.Hour},
+	}
+	for _, c := range cases {
+		if got := Backoff(10*time.Second, c.attempts); got != c.want {
+			t.Errorf("Backoff after %d attempts = %s, want %s", c.attempts, got, c.want)
+		}
+	}
+}
+
+// testingQueue connects to the test database, skipping the test if
+// there isn't one
+func testingQueue(t *testing.T, cfg Config) *Queue {
+	dbCfg := config.Default(config.EnvTest).Database
+	db, err := sql.Open(dbCfg.Dialect(), dbCfg.ConnectionInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Skip("no test database:", err)
+	}
+	m, err := migrations.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM jobs"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewQueue(db, cfg)
+}
+
+// waitFor polls the job until it has the given status
+func waitFor(t *testing.T, q *Queue, id int64, status string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := q.ByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %d is %s, expected %s", id, job.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueRetriesThenSucceeds(t *testing.T) {
+	q := testingQueue(t, Config{Workers: 2, PollInterval: 10 * time.Millisecond, Attempts: 3, Backoff: time.Millisecond})
+	var calls int32
+	q.Handle("flaky", func(ctx context.Context, job *Job) error {
+		var payload struct{ Name string }
+		if err := job.Decode(&payload); err != nil || payload.Name != "dwight" {
+			t.Errorf("Unexpected payload %s", job.Payload)
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	id, err := q.Enqueue("flaky", struct{ Name string }{"dwight"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitFor(t, q, id, StatusDone)
+	if job.Attempts != 3 {
+		t.Errorf("Expected 3 attempts, received %d", job.Attempts)
+	}
+}
+
+func TestQueueDeadLetter(t *testing.T) {
+	q := testingQueue(t, Config{PollInterval: 10 * time.Millisecond, Attempts: 2, Backoff: time.Millisecond})
+	q.Handle("broken", func(ctx context.Context, job *Job) error {
+		panic("oh no")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	id, _ := q.Enqueue("broken", nil)
+	job := waitFor(t, q, id, StatusDead)
+	if job.Attempts != 2 || job.LastError == "" {
+		t.Errorf("Unexpected dead job %+v", job)
+	}
+	dead, err := q.ByStatus(StatusDead)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("Expected 1 dead job, received %d (%v)", len(dead), err)
+	}
+
+	q.Handle("broken", func(ctx context.Context, job *Job) error { return nil })
+	if err := q.Retry(id); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, q, id, StatusDone)
+}
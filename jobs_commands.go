@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"lenslocked.com/jobs"
+)
+
+func jobsCommand() *command {
+	return &command{
+		name:  "jobs",
+		short: "inspect and retry background jobs",
+		subs: []*command{
+			{name: "list", args: "[-status <status>]", short: "list background jobs", run: jobsList},
+			{name: "retry", args: "<id>", short: "run a dead job again", run: jobsRetry},
+		},
+	}
+}
+
+func jobsList(a *app, args []string) error {
+	fs := newFlagSet(a, "jobs list")
+	status := fs.String("status", jobs.StatusDead, "only list jobs with this status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	list, err := services.Jobs.ByStatus(*status)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Fprintf(a.out, "No %s jobs\n", *status)
+		return nil
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKIND\tATTEMPTS\tUPDATED\tLAST ERROR")
+	for _, job := range list {
+		fmt.Fprintf(tw, "%d\t%s\t%d/%d\t%s\t%s\n", job.ID, job.Kind, job.Attempts,
+			job.MaxAttempts, job.UpdatedAt.Format(time.RFC3339), job.LastError)
+	}
+	return tw.Flush()
+}
+
+func jobsRetry(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	if err := services.Jobs.Retry(id); err != nil {
+		if err == jobs.ErrNotFound {
+			return fmt.Errorf("job %d: no such dead job", id)
+		}
+		return err
+	}
+	fmt.Fprintf(a.out, "Job %d queued\n", id)
+	return nil
+}
@@ -0,0 +1,150 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"lenslocked.com/rand"
+)
+
+// Message is a single email. Text is always sent, HTML is
+// optional and sent as an alternative when present.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Bytes renders the message in RFC 5322 format, ready to be handed
+// to an SMTP server or saved as a .eml file
+func (msg Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	id, err := rand.String(16)
+	if err != nil {
+		return nil, err
+	}
+	headers := []string{
+		"From: " + msg.From,
+		"To: " + msg.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + strings.TrimRight(id, "=") + "@lenslocked.com>",
+		"MIME-Version: 1.0",
+	}
+	for _, h := range headers {
+		buf.WriteString(h + "\r\n")
+	}
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, p.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// WithFrom wraps m so that messages without a From address are
+// sent from the given address
+func WithFrom(m Mailer, from string) Mailer {
+	return &fromMailer{Mailer: m, from: from}
+}
+
+type fromMailer struct {
+	Mailer
+	from string
+}
+
+func (fm *fromMailer) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = fm.from
+	}
+	return fm.Mailer.Send(msg)
+}
+
+// NewLogMailer returns a Mailer that writes every message to w instead
+// of delivering it. It is meant for running the app locally, where
+// w is usually stdout or a log file.
+func NewLogMailer(w io.Writer) Mailer {
+	return &logMailer{w: w}
+}
+
+type logMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lm *logMailer) Send(msg Message) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	_, err := fmt.Fprintf(lm.w, "----- mail -----\nFrom: %s\nTo: %s\nSubject: %s\n\n%s\n----------------\n",
+		msg.From, msg.To, msg.Subject, msg.Text)
+	return err
+}
+
+// MemoryMailer keeps every message it is sent. It is meant for tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (mm *MemoryMailer) Send(msg Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.messages = append(mm.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far
+func (mm *MemoryMailer) Messages() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Message(nil), mm.messages...)
+}
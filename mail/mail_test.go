@@ -0,0 +1,140 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lenslocked.com/config"
+)
+
+// flakyMailer fails the first n sends and then hands messages on
+type flakyMailer struct {
+	MemoryMailer
+	mu    sync.Mutex
+	fails int
+	calls int
+}
+
+func (fm *flakyMailer) Send(msg Message) error {
+	fm.mu.Lock()
+	fm.calls++
+	fail := fm.calls <= fm.fails
+	fm.mu.Unlock()
+	if fail {
+		return errors.New("smtp is having a bad day")
+	}
+	return fm.MemoryMailer.Send(msg)
+}
+
+func TestQueueRetries(t *testing.T) {
+	fm := &flakyMailer{fails: 2}
+	q := NewQueue(fm, QueueConfig{Size: 10, Attempts: 3, Backoff: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	if err := q.Send(Message{To: "mscott@dundermifflin.com", Subject: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(fm.Messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(fm.Messages()) != 1 {
+		t.Fatalf("Expected 1 delivered message, received %d", len(fm.Messages()))
+	}
+	if fm.calls != 3 {
+		t.Errorf("Expected 3 attempts, received %d", fm.calls)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := NewQueue(&MemoryMailer{}, QueueConfig{Size: 1})
+	if err := q.Send(Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Send(Message{}); err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, received %v", err)
+	}
+}
+
+func TestQueueFlushesOnShutdown(t *testing.T) {
+	mm := &MemoryMailer{}
+	q := NewQueue(mm, QueueConfig{Size: 10})
+	q.Send(Message{To: "a@example.com"})
+	q.Send(Message{To: "b@example.com"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Run(ctx)
+	if len(mm.Messages()) != 2 {
+		t.Errorf("Expected queued messages to be sent on shutdown, received %d", len(mm.Messages()))
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		From:    "support@lenslocked.com",
+		To:      "mscott@dundermifflin.com",
+		Subject: "Welcome",
+		Text:    "Hello there",
+		HTML:    "<p>Hello there</p>",
+	}
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Subject") != "Welcome" {
+		t.Errorf("Expected subject Welcome, received %q", parsed.Header.Get("Subject"))
+	}
+	if !strings.HasPrefix(parsed.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("Expected a multipart message, received %q", parsed.Header.Get("Content-Type"))
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenslocked-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "emails", "layouts"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "emails", "layouts", "email.gohtml"),
+		[]byte(`{{define "email"}}<body>{{template "html" .}}</body>{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "emails", "hello.gohtml"), []byte(`
+{{define "subject"}} Hi {{.}} {{end}}
+{{define "text"}}Hello {{.}}{{end}}
+{{define "html"}}<p>Hello {{.}}</p>{{end}}`), 0644)
+
+	tmpl := NewTemplate(config.TemplateConfig{Dir: dir + "/", Ext: ".gohtml"}, "hello")
+	msg, err := tmpl.Render("mscott@dundermifflin.com", "<Michael>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Hi <Michael>" {
+		t.Errorf("Unexpected subject %q", msg.Subject)
+	}
+	if msg.Text != "Hello <Michael>\n" {
+		t.Errorf("Expected text to be unescaped, received %q", msg.Text)
+	}
+	if msg.HTML != "<body><p>Hello &lt;Michael&gt;</p></body>" {
+		t.Errorf("Expected html to be escaped and in the layout, received %q", msg.HTML)
+	}
+}
@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrQueueFull is returned by Queue.Send when the queue can't take
+// any more messages. The message is not sent.
+var ErrQueueFull = errors.New("mail: send queue is full")
+
+// QueueConfig controls how many messages a Queue holds and how hard
+// it tries to deliver each of them
+type QueueConfig struct {
+	Size     int
+	Workers  int
+	Attempts int
+	Backoff  time.Duration
+}
+
+// NewQueue returns a Mailer that hands messages to m in the
+// background so a slow mail server never holds up a request.
+// Nothing is sent until Run is called.
+func NewQueue(m Mailer, cfg QueueConfig) *Queue {
+	if cfg.Size <= 0 {
+		cfg.Size = 100
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = 1
+	}
+	return &Queue{
+		mailer: m,
+		cfg:    cfg,
+		queue:  make(chan Message, cfg.Size),
+		sleep:  sleepCtx,
+	}
+}
+
+// Queue delivers messages in the background, retrying failed sends
+// with exponential backoff
+type Queue struct {
+	mailer Mailer
+	cfg    QueueConfig
+	queue  chan Message
+	sleep  func(ctx context.Context, d time.Duration) bool
+}
+
+// Send queues the message and returns straight away
+func (q *Queue) Send(msg Message) error {
+	select {
+	case q.queue <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run delivers queued messages until ctx is cancelled. Whatever is
+// still queued at that point gets a single delivery attempt before
+// Run returns.
+func (q *Queue) Run(ctx context.Context) {
+	done := make(chan struct{}, q.cfg.Workers)
+	for i := 0; i < q.cfg.Workers; i++ {
+		go func() {
+			q.work(ctx)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < q.cfg.Workers; i++ {
+		<-done
+	}
+	for {
+		select {
+		case msg := <-q.queue:
+			if err := q.mailer.Send(msg); err != nil {
+				log.Printf("mail: dropping message to %s on shutdown: %v", msg.To, err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-q.queue:
+			q.deliver(ctx, msg)
+		}
+	}
+}
+
+// deliver tries to send msg up to cfg.Attempts times, doubling the
+// wait between attempts
+func (q *Queue) deliver(ctx context.Context, msg Message) {
+	backoff := q.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		err := q.mailer.Send(msg)
+		if err == nil {
+			return
+		}
+		if attempt >= q.cfg.Attempts {
+			log.Printf("mail: giving up on message to %s after %d attempts: %v", msg.To, attempt, err)
+			return
+		}
+		log.Printf("mail: attempt %d to %s failed, retrying in %s: %v", attempt, msg.To, backoff, err)
+		if !q.sleep(ctx, backoff) {
+			// Shutting down, put it back for Run to make a last attempt
+			select {
+			case q.queue <- msg:
+			default:
+				log.Printf("mail: dropping message to %s on shutdown: %v", msg.To, err)
+			}
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// sleepCtx waits for d, returning false if ctx is cancelled first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"lenslocked.com/rand"
+)
+
+// SMTPConfig is everything needed to deliver mail through an SMTP server
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// NewSMTPMailer delivers mail through the configured SMTP server.
+// STARTTLS is used whenever the server offers it.
+func NewSMTPMailer(cfg SMTPConfig) Mailer {
+	return &smtpMailer{cfg: cfg}
+}
+
+type smtpMailer struct {
+	cfg SMTPConfig
+}
+
+func (sm *smtpMailer) Send(msg Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("mail: bad from address: %v", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mail: bad to address: %v", err)
+	}
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if sm.cfg.Username != "" {
+		auth = smtp.PlainAuth("", sm.cfg.Username, sm.cfg.Password, sm.cfg.Host)
+	}
+	addr := net.JoinHostPort(sm.cfg.Host, strconv.Itoa(sm.cfg.Port))
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, b)
+}
+
+// NewSpoolMailer writes every message to dir as a .eml file that can
+// be opened with any mail client. Nothing is actually delivered.
+func NewSpoolMailer(dir string) Mailer {
+	return &spoolMailer{dir: dir}
+}
+
+type spoolMailer struct {
+	dir string
+}
+
+func (sm *spoolMailer) Send(msg Message) error {
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sm.dir, 0755); err != nil {
+		return err
+	}
+	suffix, err := rand.Bytes(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%x.eml", time.Now().Format("20060102-150405"), suffix)
+	return ioutil.WriteFile(filepath.Join(sm.dir, name), b, 0644)
+}
@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"lenslocked.com/config"
+)
+
+// emailDir is where email templates live inside of the template dir
+const emailDir = "emails/"
+
+// NewTemplate parses the email template with the given name, for
+// example "reset_pw" is read from views/emails/reset_pw.gohtml.
+//
+// Each template file defines a "subject" and a "text" template and
+// may define an "html" one. The html is rendered inside of the
+// "email" layout found in views/emails/layouts/. Like views.NewView
+// this will panic if the templates can't be parsed and should only
+// be used during setup.
+func NewTemplate(tc config.TemplateConfig, name string) *Template {
+	file := tc.Dir + emailDir + name + tc.Ext
+	text := texttemplate.Must(texttemplate.ParseFiles(file))
+
+	t := &Template{name: name, text: text}
+	if text.Lookup("html") == nil {
+		return t
+	}
+	layouts, err := filepath.Glob(tc.Dir + emailDir + "layouts/*" + tc.Ext)
+	if err != nil {
+		panic(err)
+	}
+	t.html = htmltemplate.Must(htmltemplate.ParseFiles(append([]string{file}, layouts...)...))
+	return t
+}
+
+// Template renders a single kind of email
+type Template struct {
+	name string
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Render builds the message sent to the given address. data is
+// available to every part of the template.
+func (t *Template) Render(to string, data interface{}) (Message, error) {
+	msg := Message{To: to}
+	var buf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return Message{}, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := t.text.ExecuteTemplate(&buf, "text", data); err != nil {
+		return Message{}, err
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.ExecuteTemplate(&buf, "email", data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = buf.String()
+	}
+	return msg, nil
+}
+
+// Send renders the template and sends the result with m
+func (t *Template) Send(m Mailer, to string, data interface{}) error {
+	msg, err := t.Render(to, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(msg)
+}
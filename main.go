@@ -4,43 +4,81 @@ package main
 // requires a main function
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"net"
 	"net/http" // used for web server or making web requests
+	"os"
+	"time"
 
 	"lenslocked.com/controllers"
+	"lenslocked.com/mail"
 	"lenslocked.com/middleware"
-	"lenslocked.com/models"
 
 	"github.com/gorilla/mux"
 )
 
-const (
-	host     = "localhost"
-	port     = 5432
-	password = ""
-	user     = "fenderjazzplayer"
-	dbname   = "lenslocked_dev"
-)
-
 func main() {
-	connString := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
-		host, port, user, dbname)
-	services, err := models.NewServices(connString)
-	must(err)
-	defer services.Close()
+	os.Exit(exitCode(runCLI(os.Args[1:], os.Stdout)))
+}
+
+func serveCommand() *command {
+	return &command{
+		name:  "serve",
+		args:  "[-migrate]",
+		short: "start the web server",
+		run:   serve,
+	}
+}
+
+// serve starts the web server. In dev and test the schema is migrated
+// first unless -migrate=false is passed.
+func serve(a *app, args []string) error {
+	fs := newFlagSet(a, "serve")
+	migrate := fs.Bool("migrate", !a.cfg.IsProd(), "migrate the database before serving")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	if *migrate {
+		if err := services.MigrateUp(); err != nil {
+			return err
+		}
+	}
 
-	services.AutoMigrate()
-	// us.DestructiveReset()
 	r := mux.NewRouter()
-	staticController := controllers.NewStatic()
-	usersController := controllers.NewUsers(services.User)
-	galleriesController := controllers.NewGalleries(services.Gallery, services.Image, r)
+	staticController := controllers.NewStatic(a.cfg.Templates)
+	mailer, err := a.Mailer()
+	if err != nil {
+		return err
+	}
+	// Requests only queue email, it is delivered in the background
+	mailQueue := mail.NewQueue(mailer, mail.QueueConfig{
+		Size:     a.cfg.Mail.QueueSize,
+		Workers:  2,
+		Attempts: a.cfg.Mail.Attempts,
+		Backoff:  time.Duration(a.cfg.Mail.Backoff),
+	})
+	a.goBackground(mailQueue.Run)
+	usersController := controllers.NewUsers(services.User, services.Session, services.Image, mailQueue, a.cfg.BaseURL, a.cfg.Templates)
+	sessionsController := controllers.NewSessions(services.Session, a.cfg.Templates)
+	// Uploaded images are processed by the job queue's workers
+	a.goBackground(services.Jobs.Run)
+	galleriesController := controllers.NewGalleries(services.Gallery, services.ShareLink, services.Proof, services.Image, services.User, services.ImageURLs, r, a.cfg.BaseURL, a.cfg.Templates, time.Duration(a.cfg.Galleries.UnlockTTL))
 	userMw := middleware.User{
-		UserService: services.User,
+		UserService:    services.User,
+		SessionService: services.Session,
 	}
 	requireUserMw := middleware.RequireUser{
 		User: userMw,
 	}
+	requireVerifiedMw := middleware.RequireVerified{
+		RequireUser: requireUserMw,
+	}
 
 	r.Handle("/", staticController.Home).Methods("GET")
 	r.Handle("/contact", staticController.Contact).Methods("GET")
@@ -48,27 +86,130 @@ func main() {
 	r.HandleFunc("/signup", usersController.Create).Methods("POST")
 	r.Handle("/login", usersController.LoginView).Methods("GET")
 	r.HandleFunc("/login", usersController.Login).Methods("POST")
+	r.Handle("/forgot", usersController.ForgotView).Methods("GET")
+	r.HandleFunc("/forgot", usersController.InitiateReset).Methods("POST")
+	r.HandleFunc("/reset", usersController.ResetPw).Methods("GET")
+	r.HandleFunc("/reset", usersController.CompleteReset).Methods("POST")
+	r.HandleFunc("/verify", usersController.Verify).Methods("GET")
+	r.HandleFunc("/account", requireUserMw.ApplyFn(usersController.Account)).Methods("GET")
+	r.HandleFunc("/account/verify", requireUserMw.ApplyFn(usersController.ResendVerification)).Methods("POST")
+	r.HandleFunc("/account/email", requireUserMw.ApplyFn(usersController.ChangeEmail)).Methods("POST")
+	r.HandleFunc("/account/metadata", requireUserMw.ApplyFn(usersController.MetadataPolicy)).Methods("POST")
+	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersController.Logout)).Methods("POST")
+	r.HandleFunc("/logout/all", requireUserMw.ApplyFn(usersController.LogoutAll)).Methods("POST")
+
+	// Session routes
+	r.HandleFunc("/sessions", requireUserMw.ApplyFn(sessionsController.Index)).Methods("GET")
+	r.HandleFunc("/sessions/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(sessionsController.Revoke)).Methods("POST")
+	r.HandleFunc("/sessions/revoke-others", requireUserMw.ApplyFn(sessionsController.RevokeOthers)).Methods("POST")
 
 	// image routes /images/
-	imageHandler := http.FileServer(http.Dir("./images"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
+	store, err := a.Storage()
+	if err != nil {
+		return err
+	}
+	imagesController := controllers.NewImages(services.Gallery, services.Image, services.ImageURLs, store)
+	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imagesController))
 
 	// Gallery routes
-	r.Handle("/galleries/new", requireUserMw.Apply(galleriesController.New)).Methods("GET")
+	r.Handle("/galleries/new", requireVerifiedMw.Apply(galleriesController.New)).Methods("GET")
 	r.Handle("/galleries", requireUserMw.ApplyFn(galleriesController.Index)).Methods("GET")
-	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesController.Create)).Methods("POST")
+	r.HandleFunc("/galleries", requireVerifiedMw.ApplyFn(galleriesController.Create)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesController.Edit)).Methods("GET").Name(controllers.EditGallery)
 	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesController.Update)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesController.Delete)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesController.Show).Methods("GET").Name(controllers.ShowGallery)
-	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesController.ImageUpload)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/slug", requireUserMw.ApplyFn(galleriesController.RotateSlug)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/collaborators", requireUserMw.ApplyFn(galleriesController.CollaboratorAdd)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/collaborators/{userID:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesController.CollaboratorRemove)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/unlock", galleriesController.Unlock).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/unlock", galleriesController.UnlockSubmit).Methods("POST")
+	r.HandleFunc("/g/{slug}", galleriesController.Show).Methods("GET")
+	r.HandleFunc("/g/{slug}/unlock", galleriesController.Unlock).Methods("GET")
+	r.HandleFunc("/g/{slug}/unlock", galleriesController.UnlockSubmit).Methods("POST")
+	r.HandleFunc("/g/{slug}/images/{imageID:[0-9]+}", galleriesController.ImageShow).Methods("GET")
+	r.HandleFunc("/s/{token}", galleriesController.Show).Methods("GET")
+	r.HandleFunc("/s/{token}/unlock", galleriesController.Unlock).Methods("GET")
+	r.HandleFunc("/s/{token}/unlock", galleriesController.UnlockSubmit).Methods("POST")
+	r.HandleFunc("/s/{token}/images/{imageID:[0-9]+}", galleriesController.ImageShow).Methods("GET")
+	r.HandleFunc("/s/{token}/images/{imageID:[0-9]+}/download", galleriesController.ImageDownload).Methods("GET")
+	r.HandleFunc("/s/{token}/images/{imageID:[0-9]+}/select", galleriesController.ProofSelect).Methods("POST")
+	r.HandleFunc("/s/{token}/images/{imageID:[0-9]+}/note", galleriesController.ProofNote).Methods("POST")
+	r.HandleFunc("/s/{token}/submit", galleriesController.ProofSubmit).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links", requireUserMw.ApplyFn(galleriesController.ShareLinkCreate)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links/{linkID:[0-9]+}/revoke", requireUserMw.ApplyFn(galleriesController.ShareLinkRevoke)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links/{linkID:[0-9]+}/reopen", requireUserMw.ApplyFn(galleriesController.ProofReopen)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links/{linkID:[0-9]+}/selects.{format:csv|txt}", requireUserMw.ApplyFn(galleriesController.ProofExport)).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}", galleriesController.Profile).Methods("GET")
+	r.HandleFunc("/discover", galleriesController.Discover).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireVerifiedMw.ApplyFn(galleriesController.ImageUpload)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/status", requireUserMw.ApplyFn(galleriesController.ImageStatus)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/sort", requireUserMw.ApplyFn(galleriesController.ImageSort)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", galleriesController.ImageShow).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order", requireUserMw.ApplyFn(galleriesController.ImageOrder)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/original", requireUserMw.ApplyFn(galleriesController.ImageOriginal)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesController.ImageDelete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/move", requireUserMw.ApplyFn(galleriesController.ImageMove)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/copy", requireVerifiedMw.ApplyFn(galleriesController.ImageCopy)).Methods("POST")
 
-	fmt.Println("Starting the server on :3000.....")
-	http.ListenAndServe(":3000", userMw.Apply(r))
-}
+	// Expired sessions and finished jobs are cleaned out of the db
+	// every hour
+	a.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			if err := services.Session.DeleteExpired(); err != nil {
+				log.Println("deleting expired sessions:", err)
+			}
+			if _, err := services.Jobs.DeleteDone(time.Now().Add(-7 * 24 * time.Hour)); err != nil {
+				log.Println("deleting finished jobs:", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
 
-func must(err error) {
+	var handler http.Handler = userMw.Apply(r)
+	if a.cfg.TLS.Enabled() {
+		hstsMw := middleware.HSTS{MaxAge: time.Duration(a.cfg.TLS.HSTSMaxAge)}
+		handler = hstsMw.Apply(handler)
+	}
+	srv := &http.Server{
+		Addr:              a.cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(a.cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(a.cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(a.cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(a.cfg.Server.IdleTimeout),
+	}
+	if !a.cfg.TLS.Enabled() {
+		fmt.Fprintf(a.out, "Starting the server on %s.....\n", a.cfg.Addr)
+		return runServer(a, srv)
+	}
+
+	srv.TLSConfig, err = tlsConfig(a.cfg.TLS)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Starting the HTTPS server on %s.....\n", a.cfg.Addr)
+	if a.cfg.TLS.RedirectAddr == "" {
+		return runServer(a, srv)
+	}
+	_, httpsPort, err := net.SplitHostPort(a.cfg.Addr)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	redirect := &http.Server{
+		Addr:              a.cfg.TLS.RedirectAddr,
+		Handler:           middleware.RedirectHTTPS(httpsPort),
+		ReadHeaderTimeout: time.Duration(a.cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(a.cfg.Server.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(a.cfg.Server.ReadHeaderTimeout),
+		IdleTimeout:       time.Duration(a.cfg.Server.IdleTimeout),
 	}
+	fmt.Fprintf(a.out, "Redirecting HTTP on %s to HTTPS.....\n", a.cfg.TLS.RedirectAddr)
+	return runServer(a, srv, redirect)
 }
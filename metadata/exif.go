@@ -0,0 +1,207 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+// EXIF tags we read. IFD0 holds the camera and the pointers to the
+// EXIF and GPS IFDs, the rest are in those.
+const (
+	tagImageDescription = 0x010e
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagArtist           = 0x013b
+	tagCopyright        = 0x8298
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+
+	tagExposureTime      = 0x829a
+	tagFNumber           = 0x829d
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagFocalLength       = 0x920a
+	tagLensModel         = 0xa434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// exifTypeSizes is how many bytes each value of a TIFF field type
+// takes up
+var exifTypeSizes = map[uint16]uint64{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+// exifField is a single tag's value, still in its raw form
+type exifField struct {
+	typ   uint16
+	count uint32
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseEXIF reads the TIFF structure EXIF data is stored in
+func parseEXIF(b []byte) (*Metadata, error) {
+	order := tiffOrder(b)
+	if order == nil {
+		return nil, ErrMalformed
+	}
+	ifd0, err := readIFD(b, order, order.Uint32(b[4:8]))
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{
+		CameraMake:  ifd0[tagMake].string(),
+		CameraModel: ifd0[tagModel].string(),
+		Caption:     ifd0[tagImageDescription].string(),
+		Creator:     ifd0[tagArtist].string(),
+		Copyright:   ifd0[tagCopyright].string(),
+	}
+	capturedAt := ifd0[tagDateTime].string()
+
+	if off, ok := ifd0[tagExifIFD].uint(); ok {
+		// A broken sub-IFD shouldn't lose us what IFD0 had
+		if exif, err := readIFD(b, order, off); err == nil {
+			m.ExposureTime = exif[tagExposureTime].rational(0)
+			m.Aperture = exif[tagFNumber].rational(0)
+			m.FocalLength = exif[tagFocalLength].rational(0)
+			if iso, ok := exif[tagISO].uint(); ok {
+				m.ISO = int(iso)
+			}
+			m.Lens = exif[tagLensModel].string()
+			for _, tag := range []uint16{tagDateTimeOriginal, tagDateTimeDigitized} {
+				if s := exif[tag].string(); s != "" {
+					capturedAt = s
+					break
+				}
+			}
+		}
+	}
+	if t, err := time.Parse("2006:01:02 15:04:05", capturedAt); err == nil {
+		m.CapturedAt = &t
+	}
+
+	if off, ok := ifd0[tagGPSIFD].uint(); ok {
+		if gps, err := readIFD(b, order, off); err == nil {
+			lat := gpsCoordinate(gps[tagGPSLatitude], gps[tagGPSLatitudeRef].string(), "S", 90)
+			lon := gpsCoordinate(gps[tagGPSLongitude], gps[tagGPSLongitudeRef].string(), "W", 180)
+			if lat != nil && lon != nil {
+				m.Latitude, m.Longitude = lat, lon
+			}
+		}
+	}
+	return m, nil
+}
+
+// readIFD reads the directory of fields at offset. The offsets of
+// values that don't fit in a field are checked against b so that a
+// corrupt file can't make us read out of bounds.
+func readIFD(b []byte, order binary.ByteOrder, offset uint32) (map[uint16]*exifField, error) {
+	if uint64(offset)+2 > uint64(len(b)) {
+		return nil, ErrMalformed
+	}
+	n := int(order.Uint16(b[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(b) {
+		return nil, ErrMalformed
+	}
+	fields := make(map[uint16]*exifField, n)
+	for i := 0; i < n; i++ {
+		e := b[start+i*12 : start+(i+1)*12]
+		tag, typ, count := order.Uint16(e), order.Uint16(e[2:]), order.Uint32(e[4:])
+		size, ok := exifTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		total := size * uint64(count)
+		var data []byte
+		if total <= 4 {
+			data = e[8 : 8+total]
+		} else {
+			off := uint64(order.Uint32(e[8:]))
+			if off+total > uint64(len(b)) {
+				continue
+			}
+			data = b[off : off+total]
+		}
+		fields[tag] = &exifField{typ: typ, count: count, data: data, order: order}
+	}
+	return fields, nil
+}
+
+// string returns an ASCII field without its trailing NULs or padding
+func (f *exifField) string() string {
+	if f == nil || (f.typ != 2 && f.typ != 7) {
+		return ""
+	}
+	s := string(f.data)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// uint returns the first value of an integer field
+func (f *exifField) uint() (uint32, bool) {
+	if f == nil || f.count == 0 {
+		return 0, false
+	}
+	switch f.typ {
+	case 1:
+		return uint32(f.data[0]), true
+	case 3:
+		return uint32(f.order.Uint16(f.data)), true
+	case 4:
+		return f.order.Uint32(f.data), true
+	}
+	return 0, false
+}
+
+// rational returns the i'th value of a rational field, or 0
+func (f *exifField) rational(i int) float64 {
+	if f == nil || (f.typ != 5 && f.typ != 10) || i >= int(f.count) {
+		return 0
+	}
+	v := f.data[i*8:]
+	num, den := f.order.Uint32(v), f.order.Uint32(v[4:])
+	if den == 0 {
+		return 0
+	}
+	if f.typ == 10 {
+		return float64(int32(num)) / float64(int32(den))
+	}
+	return float64(num) / float64(den)
+}
+
+// gpsCoordinate turns degrees, minutes and seconds into decimal
+// degrees, negative when ref is neg. Values beyond max are rejected.
+func gpsCoordinate(f *exifField, ref, neg string, max float64) *float64 {
+	if f == nil || f.count < 3 {
+		return nil
+	}
+	v := f.rational(0) + f.rational(1)/60 + f.rational(2)/3600
+	if ref == neg {
+		v = -v
+	}
+	if math.IsNaN(v) || math.Abs(v) > max {
+		return nil
+	}
+	return &v
+}
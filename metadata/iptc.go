@@ -0,0 +1,94 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// IPTC datasets we read, all from the application record (2)
+const (
+	iptcObjectName  = 5
+	iptcKeywords    = 25
+	iptcDateCreated = 55
+	iptcTimeCreated = 60
+	iptcByline      = 80
+	iptcCopyright   = 116
+	iptcCaption     = 120
+)
+
+// parseIPTC reads IPTC-IIM datasets. It stops at the first thing it
+// doesn't understand and keeps what it read up to there.
+func parseIPTC(b []byte) *Metadata {
+	m := &Metadata{}
+	var date, clock string
+	for len(b) >= 5 && b[0] == 0x1c {
+		record, dataset := b[1], b[2]
+		size := int(binary.BigEndian.Uint16(b[3:5]))
+		b = b[5:]
+		if size&0x8000 != 0 {
+			// Extended dataset, the length is in the next n bytes
+			n := size & 0x7fff
+			if n > 4 || n > len(b) {
+				break
+			}
+			size = 0
+			for _, c := range b[:n] {
+				size = size<<8 | int(c)
+			}
+			b = b[n:]
+		}
+		if size < 0 || size > len(b) {
+			break
+		}
+		value := iptcString(b[:size])
+		b = b[size:]
+		if record != 2 {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			m.Title = value
+		case iptcKeywords:
+			if value != "" {
+				m.Keywords = append(m.Keywords, value)
+			}
+		case iptcDateCreated:
+			date = value
+		case iptcTimeCreated:
+			clock = value
+		case iptcByline:
+			m.Creator = value
+		case iptcCopyright:
+			m.Copyright = value
+		case iptcCaption:
+			m.Caption = value
+		}
+	}
+	if date != "" {
+		// Times look like 150405+0100, we keep the wall clock time
+		if len(clock) >= 6 {
+			date += clock[:6]
+		} else {
+			date += "000000"
+		}
+		if t, err := time.Parse("20060102150405", date); err == nil {
+			m.CapturedAt = &t
+		}
+	}
+	return m
+}
+
+// iptcString decodes a dataset's text. Older files are usually in
+// Latin-1 rather than UTF-8.
+func iptcString(b []byte) string {
+	if utf8.Valid(b) {
+		return strings.TrimSpace(string(b))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return strings.TrimSpace(string(runes))
+}
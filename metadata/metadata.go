@@ -0,0 +1,336 @@
+// Package metadata reads the camera settings, capture time, location
+// and captions that photos carry with them. EXIF, IPTC and XMP blocks
+// are found in JPEG, PNG and WebP files, and XMP can also be read
+// from the sidecar files photo editors write next to raw images.
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ErrMalformed is returned when a block of metadata can't be parsed.
+// Whatever could be read before the problem is still returned.
+var ErrMalformed = errors.New("metadata: malformed metadata")
+
+// maxBlock is the biggest metadata block we will read into memory,
+// anything bigger is skipped
+const maxBlock = 4 << 20
+
+// Metadata is what we know about how and where a photo was taken.
+// Fields that aren't in the file are left at their zero value.
+type Metadata struct {
+	CameraMake  string
+	CameraModel string
+	Lens        string
+	// FocalLength is in millimetres
+	FocalLength float64
+	// Aperture is the f-number, like 2.8
+	Aperture float64
+	// ExposureTime is in seconds
+	ExposureTime float64
+	ISO          int
+	// CapturedAt is when the photo was taken. Cameras record their
+	// local time, often without saying which zone it was in, so the
+	// wall clock time is kept and the zone is always UTC.
+	CapturedAt *time.Time
+	// Latitude and Longitude are in decimal degrees
+	Latitude  *float64
+	Longitude *float64
+
+	Title     string
+	Caption   string
+	Creator   string
+	Copyright string
+	Keywords  []string
+}
+
+// Merge fills in any fields of m that are empty from o
+func (m *Metadata) Merge(o *Metadata) {
+	if o == nil {
+		return
+	}
+	mergeString(&m.CameraMake, o.CameraMake)
+	mergeString(&m.CameraModel, o.CameraModel)
+	mergeString(&m.Lens, o.Lens)
+	mergeFloat(&m.FocalLength, o.FocalLength)
+	mergeFloat(&m.Aperture, o.Aperture)
+	mergeFloat(&m.ExposureTime, o.ExposureTime)
+	if m.ISO == 0 {
+		m.ISO = o.ISO
+	}
+	if m.CapturedAt == nil {
+		m.CapturedAt = o.CapturedAt
+	}
+	if m.Latitude == nil || m.Longitude == nil {
+		m.Latitude, m.Longitude = o.Latitude, o.Longitude
+	}
+	mergeString(&m.Title, o.Title)
+	mergeString(&m.Caption, o.Caption)
+	mergeString(&m.Creator, o.Creator)
+	mergeString(&m.Copyright, o.Copyright)
+	if len(m.Keywords) == 0 {
+		m.Keywords = o.Keywords
+	}
+}
+
+func mergeString(dst *string, src string) {
+	if *dst == "" {
+		*dst = src
+	}
+}
+
+func mergeFloat(dst *float64, src float64) {
+	if *dst == 0 {
+		*dst = src
+	}
+}
+
+// Read finds the metadata in a JPEG, PNG or WebP file. Other formats
+// have no metadata we can read and an empty Metadata is returned.
+// When a field is in more than one block, EXIF is preferred over IPTC
+// and IPTC over XMP.
+func Read(r io.Reader) (*Metadata, error) {
+	br := bufio.NewReader(r)
+	// Files too short to peek at just don't match any format
+	head, _ := br.Peek(12)
+	var blocks blocks
+	var err error
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}):
+		err = readJPEG(br, &blocks)
+	case bytes.HasPrefix(head, pngSignature):
+		err = readPNG(br, &blocks)
+	case len(head) == 12 && string(head[:4]) == "RIFF" && string(head[8:]) == "WEBP":
+		err = readWebP(br, &blocks)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The file ended before the image data did
+		err = ErrMalformed
+	}
+	return blocks.decode(), err
+}
+
+// blocks are the raw metadata blocks found in a file
+type blocks struct {
+	exif []byte
+	iptc []byte
+	xmp  []byte
+}
+
+// decode parses each block that was found, skipping ones that are
+// malformed
+func (b *blocks) decode() *Metadata {
+	m := &Metadata{}
+	if b.exif != nil {
+		if e, err := parseEXIF(b.exif); err == nil {
+			m.Merge(e)
+		}
+	}
+	if b.iptc != nil {
+		m.Merge(parseIPTC(b.iptc))
+	}
+	if b.xmp != nil {
+		if x, err := parseXMP(b.xmp); err == nil {
+			m.Merge(x)
+		}
+	}
+	return m
+}
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+// readJPEG collects metadata from the APP1 and APP13 segments that
+// come before the image data
+func readJPEG(r *bufio.Reader, b *blocks) error {
+	if _, err := r.Discard(2); err != nil {
+		return err
+	}
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != 0xff {
+			return ErrMalformed
+		}
+		marker, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case marker == 0xff:
+			// Fill byte, the marker follows
+			r.UnreadByte()
+			continue
+		case marker == 0xd8, marker == 0x01, marker >= 0xd0 && marker <= 0xd7:
+			// No length or contents
+			continue
+		case marker == 0xda, marker == 0xd9:
+			// Start of the image data, metadata comes before this
+			return nil
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return ErrMalformed
+		}
+		n := int(length) - 2
+		if marker != 0xe1 && marker != 0xed {
+			if _, err := r.Discard(n); err != nil {
+				return err
+			}
+			continue
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(seg, exifHeader) && b.exif == nil:
+			b.exif = seg[len(exifHeader):]
+		case bytes.HasPrefix(seg, xmpHeader) && b.xmp == nil:
+			b.xmp = seg[len(xmpHeader):]
+		case bytes.HasPrefix(seg, photoshopHeader) && b.iptc == nil:
+			b.iptc = photoshopIPTC(seg[len(photoshopHeader):])
+		}
+	}
+}
+
+// photoshopIPTC finds the IPTC block among the Photoshop image
+// resources stored in a JPEG's APP13 segment
+func photoshopIPTC(b []byte) []byte {
+	for len(b) >= 12 && string(b[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(b[4:6])
+		// The resource name is a Pascal string padded to an even length
+		nameLen := 1 + int(b[6])
+		if nameLen%2 == 1 {
+			nameLen++
+		}
+		if len(b) < 6+nameLen+4 {
+			return nil
+		}
+		b = b[6+nameLen:]
+		size := int(binary.BigEndian.Uint32(b[:4]))
+		b = b[4:]
+		if size > len(b) {
+			return nil
+		}
+		if id == 0x0404 {
+			return b[:size]
+		}
+		if size%2 == 1 {
+			size++
+		}
+		if size > len(b) {
+			return nil
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// readPNG collects metadata from eXIf and iTXt chunks
+func readPNG(r *bufio.Reader, b *blocks) error {
+	if _, err := r.Discard(len(pngSignature)); err != nil {
+		return err
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		length := binary.BigEndian.Uint32(hdr[:4])
+		kind := string(hdr[4:])
+		if kind == "IEND" {
+			return nil
+		}
+		if (kind != "eXIf" && kind != "iTXt") || length > maxBlock {
+			// Skip the chunk and its CRC
+			if _, err := r.Discard(int(length) + 4); err != nil {
+				return err
+			}
+			continue
+		}
+		chunk := make([]byte, length+4)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+		chunk = chunk[:length]
+		switch kind {
+		case "eXIf":
+			b.exif = chunk
+		case "iTXt":
+			if xmp := pngXMP(chunk); xmp != nil {
+				b.xmp = xmp
+			}
+		}
+	}
+}
+
+// readWebP collects metadata from the EXIF and XMP chunks of a RIFF
+// container
+func readWebP(r *bufio.Reader, b *blocks) error {
+	if _, err := r.Discard(12); err != nil {
+		return err
+	}
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		kind := string(hdr[:4])
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		// Chunks are padded to an even length
+		padded := size + size%2
+		if (kind != "EXIF" && kind != "XMP ") || size > maxBlock {
+			if _, err := r.Discard(int(padded)); err != nil {
+				return err
+			}
+			continue
+		}
+		chunk := make([]byte, padded)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+		chunk = chunk[:size]
+		switch kind {
+		case "EXIF":
+			// Some encoders keep the JPEG style header
+			b.exif = bytes.TrimPrefix(chunk, exifHeader)
+		case "XMP ":
+			b.xmp = chunk
+		}
+	}
+}
+
+// ReadXMP reads an XMP sidecar file
+func ReadXMP(r io.Reader) (*Metadata, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxBlock+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxBlock {
+		return nil, ErrMalformed
+	}
+	return parseXMP(b)
+}
@@ -0,0 +1,259 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tiffBuilder writes little endian TIFF structures for the tests
+type tiffBuilder struct {
+	entries map[uint32][]tiffEntry
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func ascii(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func short(tag uint16, v uint16) tiffEntry {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return tiffEntry{tag, 3, 1, b}
+}
+
+func rationals(tag uint16, vs ...[2]uint32) tiffEntry {
+	var b []byte
+	for _, v := range vs {
+		b = binary.LittleEndian.AppendUint32(b, v[0])
+		b = binary.LittleEndian.AppendUint32(b, v[1])
+	}
+	return tiffEntry{tag, 5, uint32(len(vs)), b}
+}
+
+// buildTIFF lays out IFD0 with pointers to an EXIF and a GPS IFD
+func buildTIFF(ifd0, exif, gps []tiffEntry) []byte {
+	var out []byte
+	out = append(out, "II*\x00"...)
+	out = binary.LittleEndian.AppendUint32(out, 8)
+
+	// Each IFD is written with its values straight after it. The
+	// sub-IFD pointers are patched in once we know where they are.
+	write := func(entries []tiffEntry) (int, map[uint16]int) {
+		start := len(out)
+		valuesAt := start + 2 + len(entries)*12 + 4
+		var values []byte
+		pointers := make(map[uint16]int)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(entries)))
+		for _, e := range entries {
+			out = binary.LittleEndian.AppendUint16(out, e.tag)
+			out = binary.LittleEndian.AppendUint16(out, e.typ)
+			out = binary.LittleEndian.AppendUint32(out, e.count)
+			if len(e.data) <= 4 {
+				pointers[e.tag] = len(out)
+				out = append(out, e.data...)
+				out = append(out, make([]byte, 4-len(e.data))...)
+				continue
+			}
+			out = binary.LittleEndian.AppendUint32(out, uint32(valuesAt+len(values)))
+			values = append(values, e.data...)
+		}
+		out = binary.LittleEndian.AppendUint32(out, 0)
+		out = append(out, values...)
+		return start, pointers
+	}
+	ifd0 = append(ifd0, tiffEntry{tagExifIFD, 4, 1, make([]byte, 4)}, tiffEntry{tagGPSIFD, 4, 1, make([]byte, 4)})
+	_, pointers := write(ifd0)
+	exifAt, _ := write(exif)
+	gpsAt, _ := write(gps)
+	binary.LittleEndian.PutUint32(out[pointers[tagExifIFD]:], uint32(exifAt))
+	binary.LittleEndian.PutUint32(out[pointers[tagGPSIFD]:], uint32(gpsAt))
+	return out
+}
+
+func testTIFF() []byte {
+	return buildTIFF(
+		[]tiffEntry{
+			ascii(tagMake, "Canon"),
+			ascii(tagModel, "Canon EOS R5"),
+			ascii(tagDateTime, "2021:01:01 00:00:00"),
+		},
+		[]tiffEntry{
+			rationals(tagExposureTime, [2]uint32{1, 250}),
+			rationals(tagFNumber, [2]uint32{28, 10}),
+			short(tagISO, 400),
+			ascii(tagDateTimeOriginal, "2020:06:15 14:30:05"),
+			rationals(tagFocalLength, [2]uint32{50, 1}),
+			ascii(tagLensModel, "RF50mm F1.2 L USM"),
+		},
+		[]tiffEntry{
+			ascii(tagGPSLatitudeRef, "N"),
+			rationals(tagGPSLatitude, [2]uint32{51, 1}, [2]uint32{30, 1}, [2]uint32{0, 1}),
+			ascii(tagGPSLongitudeRef, "W"),
+			rationals(tagGPSLongitude, [2]uint32{0, 1}, [2]uint32{7, 1}, [2]uint32{30, 1}),
+		},
+	)
+}
+
+func iptcDataset(dataset byte, value string) []byte {
+	b := []byte{0x1c, 2, dataset, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(value)))
+	return append(b, value...)
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:aux="http://ns.adobe.com/exif/1.0/aux/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    aux:Lens="EF24-70mm f/2.8L II USM"
+    exif:FNumber="40/10"
+    exif:GPSLatitude="40,26.767N"
+    exif:GPSLongitude="79,58.933W"
+    photoshop:DateCreated="2019-07-04T21:15:00.00-04:00">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Fireworks</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Over the river</rdf:li></rdf:Alt></dc:description>
+   <dc:subject><rdf:Bag><rdf:li>night</rdf:li><rdf:li>fireworks</rdf:li></rdf:Bag></dc:subject>
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+   <exif:ISOSpeedRatings><rdf:Seq><rdf:li>800</rdf:li></rdf:Seq></exif:ISOSpeedRatings>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func jpegSegment(marker byte, data []byte) []byte {
+	b := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)+2))
+	return append(b, data...)
+}
+
+func testJPEG() []byte {
+	iptc := append(iptcDataset(iptcCaption, "Big Ben"), iptcDataset(iptcKeywords, "london")...)
+	irb := []byte("8BIM\x04\x04\x00\x00")
+	irb = binary.BigEndian.AppendUint32(irb, uint32(len(iptc)))
+	irb = append(irb, iptc...)
+
+	var b []byte
+	b = append(b, 0xff, 0xd8)
+	b = append(b, jpegSegment(0xe0, []byte("JFIF\x00\x01\x01"))...)
+	b = append(b, jpegSegment(0xe1, append(exifHeader, testTIFF()...))...)
+	b = append(b, jpegSegment(0xed, append(photoshopHeader, irb...))...)
+	b = append(b, jpegSegment(0xe1, append(xmpHeader, testXMP...))...)
+	b = append(b, jpegSegment(0xda, []byte{0})...)
+	return append(b, 0xff, 0xd9)
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestReadJPEG(t *testing.T) {
+	m, err := Read(bytes.NewReader(testJPEG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CameraMake != "Canon" || m.CameraModel != "Canon EOS R5" || m.Lens != "RF50mm F1.2 L USM" {
+		t.Errorf("Unexpected camera %q %q %q", m.CameraMake, m.CameraModel, m.Lens)
+	}
+	if !near(m.ExposureTime, 0.004) || !near(m.Aperture, 2.8) || !near(m.FocalLength, 50) || m.ISO != 400 {
+		t.Errorf("Unexpected exposure %v f/%v %vmm ISO %d", m.ExposureTime, m.Aperture, m.FocalLength, m.ISO)
+	}
+	want := time.Date(2020, 6, 15, 14, 30, 5, 0, time.UTC)
+	if m.CapturedAt == nil || !m.CapturedAt.Equal(want) {
+		t.Errorf("Expected capture time %v, received %v", want, m.CapturedAt)
+	}
+	if m.Latitude == nil || !near(*m.Latitude, 51.5) || !near(*m.Longitude, -0.125) {
+		t.Errorf("Unexpected location %v, %v", m.Latitude, m.Longitude)
+	}
+	// EXIF has no caption, so IPTC's is used, and XMP fills in the rest
+	if m.Caption != "Big Ben" || m.Title != "Fireworks" || m.Creator != "Jane Doe" {
+		t.Errorf("Unexpected text %q %q %q", m.Caption, m.Title, m.Creator)
+	}
+	if strings.Join(m.Keywords, ",") != "london" {
+		t.Errorf("Unexpected keywords %q", m.Keywords)
+	}
+}
+
+func TestReadXMP(t *testing.T) {
+	m, err := ReadXMP(strings.NewReader(testXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Lens != "EF24-70mm f/2.8L II USM" || !near(m.Aperture, 4) || m.ISO != 800 {
+		t.Errorf("Unexpected settings %q f/%v ISO %d", m.Lens, m.Aperture, m.ISO)
+	}
+	if m.Title != "Fireworks" || m.Caption != "Over the river" {
+		t.Errorf("Unexpected title %q and caption %q", m.Title, m.Caption)
+	}
+	if strings.Join(m.Keywords, ",") != "night,fireworks" {
+		t.Errorf("Unexpected keywords %q", m.Keywords)
+	}
+	want := time.Date(2019, 7, 4, 21, 15, 0, 0, time.UTC)
+	if m.CapturedAt == nil || !m.CapturedAt.Equal(want) {
+		t.Errorf("Expected capture time %v, received %v", want, m.CapturedAt)
+	}
+	if m.Latitude == nil || !near(*m.Latitude, 40.44611667) || !near(*m.Longitude, -79.98221667) {
+		t.Errorf("Unexpected location %v, %v", m.Latitude, m.Longitude)
+	}
+}
+
+func TestParseIPTCLatin1(t *testing.T) {
+	b := append(iptcDataset(iptcByline, "Ren\xe9"), iptcDataset(iptcDateCreated, "20180203")...)
+	b = append(b, iptcDataset(iptcTimeCreated, "101500+0100")...)
+	m := parseIPTC(b)
+	if m.Creator != "René" {
+		t.Errorf("Expected René, received %q", m.Creator)
+	}
+	want := time.Date(2018, 2, 3, 10, 15, 0, 0, time.UTC)
+	if m.CapturedAt == nil || !m.CapturedAt.Equal(want) {
+		t.Errorf("Expected capture time %v, received %v", want, m.CapturedAt)
+	}
+}
+
+func TestReadNoMetadata(t *testing.T) {
+	m, err := Read(strings.NewReader("GIF89a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CameraMake != "" || m.CapturedAt != nil {
+		t.Errorf("Expected no metadata, received %+v", m)
+	}
+}
+
+// Truncated or corrupt files must never panic
+func TestReadMalformed(t *testing.T) {
+	file := testJPEG()
+	for n := 0; n < len(file); n++ {
+		Read(bytes.NewReader(file[:n]))
+	}
+	// A Photoshop resource whose name runs past the end of the segment
+	irb := append([]byte("8BIM\x04\x04\xff"), make([]byte, 5)...)
+	seg := jpegSegment(0xed, append(photoshopHeader, irb...))
+	Read(bytes.NewReader(append([]byte{0xff, 0xd8}, seg...)))
+	tiff := testTIFF()
+	for i := 8; i < len(tiff); i++ {
+		corrupt := append([]byte(nil), tiff...)
+		corrupt[i] = 0xff
+		parseEXIF(corrupt)
+		parseEXIF(tiff[:i])
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	f.Add(testJPEG())
+	f.Add([]byte(pngSignature))
+	f.Add(append([]byte{0xff, 0xd8}, jpegSegment(0xed, append(photoshopHeader, "8BIM\x04\x04\xff\x00\x00\x00\x00\x00"...))...))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		Read(bytes.NewReader(b))
+	})
+}
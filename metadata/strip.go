@@ -0,0 +1,400 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// Policy says which metadata is left in the copies of images that
+// visitors can see and download
+type Policy string
+
+const (
+	// KeepAll leaves the metadata as it is
+	KeepAll Policy = "keep"
+	// StripGPS removes where the photo was taken and keeps the rest
+	StripGPS Policy = "strip-gps"
+	// StripAll removes everything except the EXIF orientation, which
+	// is needed to show the photo the right way up
+	StripAll Policy = "strip-all"
+)
+
+// Policies lists every policy, least private first
+var Policies = []Policy{KeepAll, StripGPS, StripAll}
+
+// ErrPolicyInvalid is returned by Strip for a policy it doesn't know
+var ErrPolicyInvalid = errors.New("metadata: unknown metadata policy")
+
+// Valid reports whether p is one of Policies
+func (p Policy) Valid() bool {
+	for _, policy := range Policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Strip copies the image in r to w, leaving out the metadata that p
+// doesn't keep. Only the metadata blocks are touched, the image data
+// is copied as it is. JPEG, PNG and WebP files are understood, other
+// formats are copied unchanged.
+//
+// Metadata we can't parse is removed along with everything else
+// rather than risk leaking what is in it.
+func Strip(w io.Writer, r io.Reader, p Policy) error {
+	if !p.Valid() {
+		return ErrPolicyInvalid
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if p != KeepAll {
+		switch {
+		case bytes.HasPrefix(b, []byte{0xff, 0xd8}):
+			b, err = stripJPEG(b, p)
+		case bytes.HasPrefix(b, pngSignature):
+			b, err = stripPNG(b, p)
+		case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+			b, err = stripWebP(b, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+var xmpExtensionHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+
+// stripJPEG rewrites the segments before the image data
+func stripJPEG(b []byte, p Policy) ([]byte, error) {
+	out := []byte{0xff, 0xd8}
+	i := 2
+	for i+2 <= len(b) {
+		if b[i] != 0xff {
+			return nil, ErrMalformed
+		}
+		marker := b[i+1]
+		switch {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == 0xd8, marker == 0x01, marker >= 0xd0 && marker <= 0xd7:
+			out = append(out, b[i:i+2]...)
+			i += 2
+			continue
+		case marker == 0xda, marker == 0xd9:
+			// The image data and everything after it is kept as is
+			return append(out, b[i:]...), nil
+		}
+		if i+4 > len(b) {
+			return nil, ErrMalformed
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			return nil, ErrMalformed
+		}
+		seg := b[i+4 : i+2+n]
+		i += 2 + n
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(seg, exifHeader):
+			var tiff []byte
+			if p == StripAll {
+				tiff = orientationEXIF(seg[len(exifHeader):])
+			} else {
+				tiff = removeGPS(seg[len(exifHeader):])
+			}
+			if tiff == nil {
+				continue
+			}
+			seg = append(append([]byte(nil), exifHeader...), tiff...)
+		case marker == 0xe1 && bytes.HasPrefix(seg, xmpHeader) && p == StripGPS:
+			xmp := removeXMPGPS(seg[len(xmpHeader):])
+			seg = append(append([]byte(nil), xmpHeader...), xmp...)
+		case marker == 0xe1 && bytes.HasPrefix(seg, xmpExtensionHeader):
+			// The rest of a packet too big for one segment. There is no
+			// telling what is in a piece of it, so it always goes.
+			continue
+		case (marker == 0xe1 || marker == 0xed || marker == 0xfe) && p == StripAll:
+			// APP1 and APP13 hold EXIF, XMP and IPTC, 0xfe is a comment
+			continue
+		}
+		out = append(out, 0xff, marker, 0, 0)
+		binary.BigEndian.PutUint16(out[len(out)-2:], uint16(len(seg)+2))
+		out = append(out, seg...)
+	}
+	return nil, ErrMalformed
+}
+
+// stripPNG rewrites the eXIf chunk and text chunks
+func stripPNG(b []byte, p Policy) ([]byte, error) {
+	out := append([]byte(nil), pngSignature...)
+	i := len(pngSignature)
+	for i+12 <= len(b) {
+		length := int(binary.BigEndian.Uint32(b[i:]))
+		if length < 0 || i+12+length > len(b) {
+			return nil, ErrMalformed
+		}
+		kind := string(b[i+4 : i+8])
+		data := b[i+8 : i+8+length]
+		chunk := b[i : i+12+length]
+		i += 12 + length
+
+		switch kind {
+		case "eXIf":
+			var tiff []byte
+			if p == StripAll {
+				tiff = orientationEXIF(data)
+			} else {
+				tiff = removeGPS(data)
+			}
+			if tiff != nil {
+				out = appendPNGChunk(out, kind, tiff)
+			}
+			continue
+		case "iTXt":
+			if p == StripAll {
+				continue
+			}
+			if xmp := pngXMP(data); xmp != nil {
+				out = appendPNGChunk(out, kind, pngXMPChunk(removeXMPGPS(xmp)))
+				continue
+			}
+		case "tEXt", "zTXt", "tIME":
+			if p == StripAll {
+				continue
+			}
+		}
+		out = append(out, chunk...)
+		if kind == "IEND" {
+			return out, nil
+		}
+	}
+	return nil, ErrMalformed
+}
+
+func appendPNGChunk(out []byte, kind string, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	start := len(out)
+	out = append(out, kind...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+// pngXMPChunk is the contents of an uncompressed iTXt chunk holding
+// an XMP packet
+func pngXMPChunk(xmp []byte) []byte {
+	// Keyword, compression flag and method, empty language tag and
+	// translated keyword
+	data := []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00")
+	return append(data, xmp...)
+}
+
+// VP8X flags saying the file has EXIF or XMP chunks
+const (
+	vp8xEXIF = 0x08
+	vp8xXMP  = 0x04
+)
+
+// stripWebP rewrites the EXIF and XMP chunks of a RIFF container
+func stripWebP(b []byte, p Policy) ([]byte, error) {
+	out := append([]byte(nil), b[:12]...)
+	var hasEXIF, hasXMP bool
+	i := 12
+	for i+8 <= len(b) {
+		kind := string(b[i : i+4])
+		size := int(binary.LittleEndian.Uint32(b[i+4:]))
+		if size < 0 || i+8+size > len(b) {
+			return nil, ErrMalformed
+		}
+		end := i + 8 + size + size%2
+		if end > len(b) {
+			end = len(b)
+		}
+		data := b[i+8 : i+8+size]
+		chunk := b[i:end]
+		i = end
+
+		switch kind {
+		case "EXIF":
+			tiff := bytes.TrimPrefix(data, exifHeader)
+			if p == StripAll {
+				tiff = orientationEXIF(tiff)
+			} else {
+				tiff = removeGPS(tiff)
+			}
+			if tiff != nil {
+				out = appendRIFFChunk(out, kind, tiff)
+				hasEXIF = true
+			}
+		case "XMP ":
+			if p != StripAll {
+				out = appendRIFFChunk(out, kind, removeXMPGPS(data))
+				hasXMP = true
+			}
+		default:
+			out = append(out, chunk...)
+		}
+	}
+	// The extended format header has to agree with the chunks
+	if len(out) >= 21 && string(out[12:16]) == "VP8X" {
+		out[20] &^= vp8xEXIF | vp8xXMP
+		if hasEXIF {
+			out[20] |= vp8xEXIF
+		}
+		if hasXMP {
+			out[20] |= vp8xXMP
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+func appendRIFFChunk(out []byte, kind string, data []byte) []byte {
+	out = append(out, kind...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// tiffOrder returns the byte order of a TIFF structure, or nil if b
+// isn't one
+func tiffOrder(b []byte) binary.ByteOrder {
+	if len(b) < 8 {
+		return nil
+	}
+	switch string(b[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// removeGPS returns a copy of the EXIF data in tiff without its GPS
+// IFD. The IFD and its values are zeroed so no trace of them is left,
+// and the pointer to it is taken out of IFD0. nil is returned if the
+// data can't be parsed.
+func removeGPS(tiff []byte) []byte {
+	order := tiffOrder(tiff)
+	if order == nil {
+		return nil
+	}
+	b := append([]byte(nil), tiff...)
+	offset := order.Uint32(b[4:8])
+	if uint64(offset)+2 > uint64(len(b)) {
+		return nil
+	}
+	n := int(order.Uint16(b[offset:]))
+	start := int(offset) + 2
+	// The entries are followed by the offset of the next IFD
+	end := start + n*12 + 4
+	if end > len(b) {
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		e := b[start+i*12:]
+		if order.Uint16(e) != tagGPSIFD {
+			continue
+		}
+		if !zeroIFD(b, order, order.Uint32(e[8:])) {
+			return nil
+		}
+		copy(b[start+i*12:], b[start+(i+1)*12:end])
+		for j := end - 12; j < end; j++ {
+			b[j] = 0
+		}
+		order.PutUint16(b[offset:], uint16(n-1))
+		break
+	}
+	return b
+}
+
+// zeroIFD blanks the directory at offset and the values it points to
+func zeroIFD(b []byte, order binary.ByteOrder, offset uint32) bool {
+	if uint64(offset)+2 > uint64(len(b)) {
+		return false
+	}
+	n := int(order.Uint16(b[offset:]))
+	start := int(offset) + 2
+	end := start + n*12
+	if end > len(b) {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		e := b[start+i*12:]
+		size, ok := exifTypeSizes[order.Uint16(e[2:])]
+		if !ok {
+			continue
+		}
+		total := size * uint64(order.Uint32(e[4:]))
+		off := uint64(order.Uint32(e[8:]))
+		if total > 4 && off+total <= uint64(len(b)) {
+			zero(b[off : off+total])
+		}
+	}
+	if end+4 <= len(b) {
+		end += 4
+	}
+	zero(b[offset:end])
+	return true
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// orientationEXIF returns EXIF data holding nothing but the
+// orientation from tiff, or nil if it has none worth keeping
+func orientationEXIF(tiff []byte) []byte {
+	order := tiffOrder(tiff)
+	if order == nil {
+		return nil
+	}
+	ifd0, err := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil
+	}
+	orientation, ok := ifd0[tagOrientation].uint()
+	if !ok || orientation <= 1 || orientation > 8 {
+		return nil
+	}
+	// A header, then IFD0 with a single SHORT entry and no next IFD
+	out := make([]byte, 26)
+	copy(out, tiff[:4])
+	order.PutUint32(out[4:], 8)
+	order.PutUint16(out[8:], 1)
+	order.PutUint16(out[10:], tagOrientation)
+	order.PutUint16(out[12:], 3)
+	order.PutUint32(out[14:], 1)
+	order.PutUint16(out[18:], uint16(orientation))
+	return out
+}
+
+var (
+	// xmpGPSAttr and xmpGPSElem match GPS properties written as
+	// attributes or as elements, whatever prefix they use
+	xmpGPSAttr = regexp.MustCompile(`\s[\w.-]+:GPS\w*\s*=\s*("[^"]*"|'[^']*')`)
+	xmpGPSElem = regexp.MustCompile(`(?s)<[\w.-]+:GPS\w*\b[^>]*?(/>|>.*?</[\w.-]+:GPS\w*\s*>)`)
+)
+
+// removeXMPGPS takes the GPS properties out of an XMP packet
+func removeXMPGPS(xmp []byte) []byte {
+	xmp = xmpGPSAttr.ReplaceAll(xmp, nil)
+	return xmpGPSElem.ReplaceAll(xmp, nil)
+}
@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// realJPEG is a decodable JPEG carrying the same metadata as testJPEG
+func realJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	meta := testJPEG()
+	// Everything between SOI and SOS in testJPEG
+	segments := meta[2:bytes.Index(meta, []byte{0xff, 0xda})]
+	return append(append([]byte{0xff, 0xd8}, segments...), buf.Bytes()[2:]...)
+}
+
+func strip(t *testing.T, b []byte, p Policy) []byte {
+	var out bytes.Buffer
+	if err := Strip(&out, bytes.NewReader(b), p); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestStripJPEG(t *testing.T) {
+	file := realJPEG(t)
+	if kept := strip(t, file, KeepAll); !bytes.Equal(kept, file) {
+		t.Error("Expected KeepAll to leave the file alone")
+	}
+
+	stripped := strip(t, file, StripGPS)
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Read(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Latitude != nil || m.Longitude != nil {
+		t.Errorf("Expected no location, received %v, %v", *m.Latitude, *m.Longitude)
+	}
+	if m.CameraModel != "Canon EOS R5" || m.Caption != "Big Ben" || m.Title != "Fireworks" || m.ISO != 400 {
+		t.Errorf("Expected everything but the location to be kept, received %+v", m)
+	}
+	// The coordinates themselves must be gone, not just unreferenced
+	var lat []byte
+	for _, v := range []uint32{51, 1, 30, 1} {
+		lat = binary.LittleEndian.AppendUint32(lat, v)
+	}
+	if bytes.Contains(stripped, lat) || bytes.Contains(stripped, []byte("GPSLatitude")) {
+		t.Error("Expected the GPS data to be removed from the file")
+	}
+
+	stripped = strip(t, file, StripAll)
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Read(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CameraModel != "" || m.Caption != "" || m.Title != "" || m.CapturedAt != nil {
+		t.Errorf("Expected no metadata, received %+v", m)
+	}
+}
+
+func TestStripKeepsOrientation(t *testing.T) {
+	tiff := buildTIFF([]tiffEntry{ascii(tagMake, "Canon"), short(tagOrientation, 6)}, nil, nil)
+	got := orientationEXIF(tiff)
+	ifd0, err := readIFD(got, binary.LittleEndian, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := ifd0[tagOrientation].uint(); o != 6 || len(ifd0) != 1 {
+		t.Errorf("Expected only orientation 6, received %d fields and %d", len(ifd0), o)
+	}
+	// Upright photos don't need any EXIF at all
+	if orientationEXIF(testTIFF()) != nil {
+		t.Error("Expected no EXIF for a photo without an orientation")
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	// Put an eXIf chunk and XMP after IHDR
+	encoded := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+	file := append([]byte(nil), encoded[:ihdrEnd]...)
+	file = appendPNGChunk(file, "eXIf", testTIFF())
+	file = appendPNGChunk(file, "iTXt", pngXMPChunk([]byte(testXMP)))
+	file = append(file, encoded[ihdrEnd:]...)
+
+	m, _ := Read(bytes.NewReader(file))
+	if m.Latitude == nil || m.Title != "Fireworks" {
+		t.Fatalf("Test file is missing its metadata: %+v", m)
+	}
+	stripped := strip(t, file, StripGPS)
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = Read(bytes.NewReader(stripped))
+	if m.Latitude != nil || m.CameraMake != "Canon" || m.Title != "Fireworks" {
+		t.Errorf("Expected only the location to be removed, received %+v", m)
+	}
+	stripped = strip(t, file, StripAll)
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = Read(bytes.NewReader(stripped))
+	if m.CameraMake != "" || m.Title != "" {
+		t.Errorf("Expected no metadata, received %+v", m)
+	}
+}
+
+func TestStripUnknownPolicy(t *testing.T) {
+	var out bytes.Buffer
+	if err := Strip(&out, bytes.NewReader(realJPEG(t)), "some"); err != ErrPolicyInvalid {
+		t.Errorf("Expected ErrPolicyInvalid, received %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMP namespaces we read properties from
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsTIFF      = "http://ns.adobe.com/tiff/1.0/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsEXIFEX    = "http://cipa.jp/exif/1.0/"
+	nsAux       = "http://ns.adobe.com/exif/1.0/aux/"
+)
+
+// xmpProps are the values of XMP properties, keyed by namespace and
+// name like "http://purl.org/dc/elements/1.1/ subject". Arrays have
+// a value per item.
+type xmpProps map[string][]string
+
+func (p xmpProps) first(ns, name string) string {
+	if v := p[ns+" "+name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// parseXMP reads the properties we care about from an XMP packet
+func parseXMP(b []byte) (*Metadata, error) {
+	props, err := readXMPProps(b)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{
+		CameraMake:   props.first(nsTIFF, "Make"),
+		CameraModel:  props.first(nsTIFF, "Model"),
+		Lens:         props.first(nsAux, "Lens"),
+		FocalLength:  xmpRational(props.first(nsEXIF, "FocalLength")),
+		Aperture:     xmpRational(props.first(nsEXIF, "FNumber")),
+		ExposureTime: xmpRational(props.first(nsEXIF, "ExposureTime")),
+		Title:        props.first(nsDC, "title"),
+		Caption:      props.first(nsDC, "description"),
+		Creator:      props.first(nsDC, "creator"),
+		Copyright:    props.first(nsDC, "rights"),
+		Keywords:     props[nsDC+" subject"],
+	}
+	if m.Lens == "" {
+		m.Lens = props.first(nsEXIFEX, "LensModel")
+	}
+	for _, iso := range []string{props.first(nsEXIF, "ISOSpeedRatings"), props.first(nsEXIFEX, "PhotographicSensitivity")} {
+		if n, err := strconv.Atoi(iso); err == nil && n > 0 {
+			m.ISO = n
+			break
+		}
+	}
+	for _, date := range []string{
+		props.first(nsEXIF, "DateTimeOriginal"),
+		props.first(nsPhotoshop, "DateCreated"),
+		props.first(nsXMP, "CreateDate"),
+	} {
+		if t, ok := xmpDate(date); ok {
+			m.CapturedAt = &t
+			break
+		}
+	}
+	lat, latOK := xmpGPS(props.first(nsEXIF, "GPSLatitude"), 'S', 90)
+	lon, lonOK := xmpGPS(props.first(nsEXIF, "GPSLongitude"), 'W', 180)
+	if latOK && lonOK {
+		m.Latitude, m.Longitude = &lat, &lon
+	}
+	return m, nil
+}
+
+// readXMPProps collects the properties of every rdf:Description in
+// the packet. Simple properties can be attributes of the description
+// or elements in it, arrays are elements holding rdf:li items. The
+// insides of structured properties are skipped.
+func readXMPProps(b []byte) (xmpProps, error) {
+	props := make(xmpProps)
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	var stack []xml.Name
+	var prop string
+	var text strings.Builder
+	var items []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return props, nil
+		}
+		if err != nil {
+			return props, ErrMalformed
+		}
+		var parent xml.Name
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == nsRDF && t.Name.Local == "Description" && prop == "":
+				for _, attr := range t.Attr {
+					if attr.Name.Space != nsRDF && attr.Name.Space != "xmlns" &&
+						attr.Name.Space != "" && attr.Name.Local != "xmlns" {
+						key := attr.Name.Space + " " + attr.Name.Local
+						props[key] = []string{strings.TrimSpace(attr.Value)}
+					}
+				}
+			case parent.Space == nsRDF && parent.Local == "Description" && prop == "":
+				prop = t.Name.Space + " " + t.Name.Local
+				text.Reset()
+				items = nil
+			case t.Name.Space == nsRDF && t.Name.Local == "li" && prop != "":
+				text.Reset()
+			}
+			stack = append(stack, t.Name)
+		case xml.CharData:
+			if prop != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if prop == "" {
+				continue
+			}
+			if t.Name.Space == nsRDF && t.Name.Local == "li" {
+				if s := strings.TrimSpace(text.String()); s != "" {
+					items = append(items, s)
+				}
+				text.Reset()
+				continue
+			}
+			if t.Name.Space+" "+t.Name.Local != prop || len(stack) == 0 ||
+				stack[len(stack)-1].Local != "Description" {
+				continue
+			}
+			if len(items) > 0 {
+				props[prop] = items
+			} else if s := strings.TrimSpace(text.String()); s != "" {
+				props[prop] = []string{s}
+			}
+			prop = ""
+		}
+	}
+}
+
+// xmpRational parses numbers written like "28/10" or "2.8"
+func xmpRational(s string) float64 {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		num, err1 := strconv.ParseFloat(s[:i], 64)
+		den, err2 := strconv.ParseFloat(s[i+1:], 64)
+		if err1 != nil || err2 != nil || den == 0 {
+			return 0
+		}
+		return num / den
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// xmpDateLayouts are the forms XMP dates come in, most precise first
+var xmpDateLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// xmpDate parses an XMP date, keeping its wall clock time like we do
+// for EXIF dates
+func xmpDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	// Drop any zone after the time, either Z or +hh:mm
+	if i := strings.IndexByte(s, 'T'); i > 0 {
+		if j := strings.LastIndexAny(s, "Z+-"); j > i {
+			s = s[:j]
+		}
+	}
+	for _, layout := range xmpDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// xmpGPS parses coordinates written like "51,30.4416N" or
+// "51,30,26.5N" into decimal degrees
+func xmpGPS(s string, neg byte, max float64) (float64, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	ref := s[len(s)-1]
+	parts := strings.Split(s[:len(s)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var v float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		v += f / [3]float64{1, 60, 3600}[i]
+	}
+	if ref == neg {
+		v = -v
+	}
+	if v > max || v < -max {
+		return 0, false
+	}
+	return v, true
+}
+
+// pngXMP returns the XMP packet in an iTXt chunk, if that is what the
+// chunk holds
+func pngXMP(chunk []byte) []byte {
+	parts := bytes.SplitN(chunk, []byte{0}, 2)
+	if len(parts) != 2 || string(parts[0]) != "XML:com.adobe.xmp" || len(parts[1]) < 2 {
+		return nil
+	}
+	compressed := parts[1][0] == 1
+	// Skip the compression flag and method, then the language tag and
+	// translated keyword
+	rest := bytes.SplitN(parts[1][2:], []byte{0}, 3)
+	if len(rest) != 3 {
+		return nil
+	}
+	text := rest[2]
+	if !compressed {
+		return text
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	xmp, err := ioutil.ReadAll(io.LimitReader(zr, maxBlock))
+	if err != nil {
+		return nil
+	}
+	return xmp
+}
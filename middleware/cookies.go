@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"lenslocked.com/models"
+)
+
+// SessionCookie is the name of the cookie holding the session token.
+// It kept the old remember_token name so existing cookies still work.
+const SessionCookie = "remember_token"
+
+// SetSessionCookie stores the session's raw token in the user's browser
+// until the session expires. The cookie is only sent back over HTTPS
+// when the request came in over TLS.
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, session *models.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookie removes the session cookie from the user's browser
+func ClearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+}
+
+// GalleryUnlockCookie holds the token that lets a visitor see a
+// gallery they have entered the password of
+const GalleryUnlockCookie = "gallery_unlock"
+
+// SetGalleryUnlockCookie stores an unlock token until it expires. It
+// is only sent back for pages under path, the gallery's own URL, so
+// every gallery gets a cookie of its own.
+func SetGalleryUnlockCookie(w http.ResponseWriter, r *http.Request, path, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     GalleryUnlockCookie,
+		Value:    token,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
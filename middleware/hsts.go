@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HSTS sets the Strict-Transport-Security header on every response
+// served over TLS so browsers stop trying plain HTTP for our domain
+type HSTS struct {
+	MaxAge time.Duration
+}
+
+func (mw *HSTS) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *HSTS) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", int(mw.MaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers ignore the header over plain HTTP anyway
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next(w, r)
+	})
+}
+
+// RedirectHTTPS answers every request with a permanent redirect to the
+// same URL over HTTPS. httpsPort is left off of the URL when it is 443.
+func RedirectHTTPS(httpsPort string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = host + ":" + httpsPort
+		}
+		url := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, url, http.StatusMovedPermanently)
+	})
+}
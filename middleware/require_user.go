@@ -1,14 +1,20 @@
 package middleware
 
 import (
+	"net"
 	"net/http"
+	"time"
 
 	"lenslocked.com/context"
 	"lenslocked.com/models"
+	"lenslocked.com/rand"
 )
 
+// User looks up the session in the remember_token cookie and, if it
+// is still valid, attaches the session and its user to the request.
 type User struct {
 	models.UserService
+	models.SessionService
 }
 
 func (mw *User) Apply(next http.Handler) http.HandlerFunc {
@@ -19,23 +25,72 @@ func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Lookup the user by their remember token / cookie
 		// do the cookie test
-		cookie, err := r.Cookie("remember_token")
+		cookie, err := r.Cookie(SessionCookie)
 		if err != nil {
 			next(w, r)
 			return
 		}
-		user, err := mw.UserService.ByRemember(cookie.Value)
+		session, err := mw.SessionService.ByToken(cookie.Value)
+		if err == models.ErrNotFound {
+			session, err = mw.upgradeRemember(w, r, cookie.Value)
+		}
+		if err != nil {
+			next(w, r)
+			return
+		}
+		user, err := mw.UserService.ByID(session.UserID)
+		if err != nil || user.Disabled {
+			next(w, r)
+			return
+		}
+		rotated, err := mw.SessionService.Touch(session, ClientIP(r))
 		if err != nil {
-			next.ServeHTTP(w, r)
+			next(w, r)
 			return
 		}
+		if rotated {
+			SetSessionCookie(w, r, session)
+		}
 		ctx := r.Context()
 		ctx = context.WithUser(ctx, user)
+		ctx = context.WithSession(ctx, session)
 		r = r.WithContext(ctx)
 		next(w, r)
 	})
 }
 
+// rememberCutoff is when cookies from before we had sessions stop
+// being upgraded. They only lasted as long as the browser was open,
+// so any still around by then haven't been used in months.
+var rememberCutoff = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+// upgradeRemember handles cookies set before we had sessions, which
+// hold the user's remember token. They are swapped for a new session,
+// and the remember token is replaced so the cookie only works once.
+func (mw *User) upgradeRemember(w http.ResponseWriter, r *http.Request, token string) (*models.Session, error) {
+	if !time.Now().Before(rememberCutoff) {
+		return nil, models.ErrNotFound
+	}
+	user, err := mw.UserService.ByRemember(token)
+	if err != nil {
+		return nil, err
+	}
+	remember, err := rand.RememberToken()
+	if err != nil {
+		return nil, err
+	}
+	user.Remember = remember
+	if err := mw.UserService.Update(user); err != nil {
+		return nil, err
+	}
+	session, err := mw.SessionService.Start(user.ID, r.UserAgent(), ClientIP(r))
+	if err != nil {
+		return nil, err
+	}
+	SetSessionCookie(w, r, session)
+	return session, nil
+}
+
 // RequireUser assuems that User has already been run
 // otherwise it will not work correctly
 type RequireUser struct {
@@ -60,3 +115,34 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	})
 }
+
+// ClientIP is the address the request came from, without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequireVerified only lets users through once they have verified
+// their email address. Unverified users are sent to their account
+// page where they can resend the verification email.
+type RequireVerified struct {
+	RequireUser
+}
+
+func (mw *RequireVerified) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireVerified) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.RequireUser.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if !user.Verified {
+			http.Redirect(w, r, "/account", http.StatusFound)
+			return
+		}
+		next(w, r)
+	})
+}
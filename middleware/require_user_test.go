@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+// fakeUsers has one user whose remember token is legacy-remember
+// until it is changed
+type fakeUsers struct {
+	models.UserService
+	user     *models.User
+	remember string
+}
+
+func (us *fakeUsers) ByRemember(token string) (*models.User, error) {
+	if token != us.remember {
+		return nil, models.ErrNotFound
+	}
+	return us.user, nil
+}
+
+func (us *fakeUsers) Update(user *models.User) error {
+	us.remember = user.Remember
+	return nil
+}
+
+func (us *fakeUsers) ByID(id uint) (*models.User, error) {
+	if id != us.user.ID {
+		return nil, models.ErrNotFound
+	}
+	return us.user, nil
+}
+
+type fakeSessions struct {
+	models.SessionService
+	started []uint
+}
+
+func (ss *fakeSessions) ByToken(token string) (*models.Session, error) {
+	return nil, models.ErrNotFound
+}
+
+func (ss *fakeSessions) Start(userID uint, userAgent, ip string) (*models.Session, error) {
+	ss.started = append(ss.started, userID)
+	return &models.Session{UserID: userID, Token: "new-session"}, nil
+}
+
+func (ss *fakeSessions) Touch(session *models.Session, ip string) (bool, error) {
+	return false, nil
+}
+
+func TestUserUpgradeRemember(t *testing.T) {
+	sessions := &fakeSessions{}
+	mw := &User{
+		UserService:    &fakeUsers{user: &models.User{Model: gorm.Model{ID: 5}}, remember: "legacy-remember"},
+		SessionService: sessions,
+	}
+	var seen *models.User
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		seen = context.User(r.Context())
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/galleries", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: "legacy-remember"})
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if seen == nil || seen.ID != 5 {
+		t.Fatalf("Expected the user with the remember token to be signed in, received %v", seen)
+	}
+	if len(sessions.started) != 1 || sessions.started[0] != 5 {
+		t.Errorf("Expected a session to be started for the user, received %v", sessions.started)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie || cookies[0].Value != "new-session" {
+		t.Errorf("Expected the cookie to be swapped for the new session, received %v", cookies)
+	}
+
+	for _, token := range []string{"legacy-remember", "unknown"} {
+		seen = nil
+		r = httptest.NewRequest(http.MethodGet, "/galleries", nil)
+		r.AddCookie(&http.Cookie{Name: SessionCookie, Value: token})
+		w = httptest.NewRecorder()
+		handler(w, r)
+		if seen != nil || len(sessions.started) != 1 || len(w.Result().Cookies()) != 0 {
+			t.Errorf("Expected %s to be ignored, received %v", token, seen)
+		}
+	}
+}
+
+func TestUserUpgradeRememberCutoff(t *testing.T) {
+	defer func(cutoff time.Time) { rememberCutoff = cutoff }(rememberCutoff)
+	rememberCutoff = time.Now().Add(-time.Hour)
+	sessions := &fakeSessions{}
+	mw := &User{
+		UserService:    &fakeUsers{user: &models.User{Model: gorm.Model{ID: 5}}, remember: "legacy-remember"},
+		SessionService: sessions,
+	}
+	var seen *models.User
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		seen = context.User(r.Context())
+	})
+	r := httptest.NewRequest(http.MethodGet, "/galleries", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: "legacy-remember"})
+	handler(httptest.NewRecorder(), r)
+	if seen != nil || len(sessions.started) != 0 {
+		t.Errorf("Expected remember cookies not to be upgraded after the cutoff, received %v", seen)
+	}
+}
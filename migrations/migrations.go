@@ -0,0 +1,285 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lockKey is the postgres advisory lock held while migrating so that
+// two instances starting at once don't both try to run migrations
+const lockKey int64 = 0x6c656e736c6f636b // "lenslock"
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var (
+	// ErrNoDown is returned when a migration is missing its .down.sql file
+	ErrNoDown = errors.New("migrations: migration has no down file")
+	// ErrDuplicate is returned when two migrations share a version
+	ErrDuplicate = errors.New("migrations: duplicate migration version")
+
+	fileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+)
+
+// Migration is a single numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes whether a migration has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// All returns every migration embedded in the binary ordered by version
+func All() ([]Migration, error) {
+	return parse(files, "sql")
+}
+
+// parse reads NNNN_name.up.sql and NNNN_name.down.sql pairs from dir
+func parse(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: bad file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		b, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: %d", ErrDuplicate, version)
+		}
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Down == "" {
+			return nil, fmt.Errorf("%w: %04d_%s", ErrNoDown, m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// NewMigrator returns a Migrator for every embedded migration
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrator applies and rolls back migrations, recording each one
+// in the schema_migrations table.
+//
+// When DryRun is set the SQL that would run is written to Out
+// and nothing is changed.
+type Migrator struct {
+	DryRun bool
+	Out    io.Writer
+
+	db         *sql.DB
+	migrations []Migration
+}
+
+// Up applies every pending migration in order and returns how many ran
+func (m *Migrator) Up() (int, error) {
+	n := 0
+	err := m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			err := m.exec(conn, mig, mig.Up,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now())",
+				mig.Version, mig.Name)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down rolls back the most recently applied steps migrations
+func (m *Migrator) Down(steps int) (int, error) {
+	n := 0
+	err := m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			err := m.exec(conn, mig, mig.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", mig.Version)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Reset rolls back every applied migration and then applies them all again
+func (m *Migrator) Reset() error {
+	if _, err := m.Down(len(m.migrations)); err != nil {
+		return err
+	}
+	_, err := m.Up()
+	return err
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.withConn(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			at, ok := applied[mig.Version]
+			statuses = append(statuses, Status{
+				Migration: mig,
+				Applied:   ok,
+				AppliedAt: at,
+			})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// exec runs the migration's SQL and the bookkeeping query in a single
+// transaction so a failed migration is never recorded as applied
+func (m *Migrator) exec(conn *sql.Conn, mig Migration, query, record string, args ...interface{}) error {
+	if m.DryRun {
+		fmt.Fprintf(m.Out, "-- %04d_%s\n%s\n", mig.Version, mig.Name, query)
+		return nil
+	}
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrations: %04d_%s: %v", mig.Version, mig.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// applied returns the time each applied migration ran, keyed by version.
+// Outside of a dry run the schema_migrations table is created if needed.
+func (m *Migrator) applied(conn *sql.Conn) (map[int]time.Time, error) {
+	ctx := context.Background()
+	applied := make(map[int]time.Time)
+	if m.DryRun {
+		var exists bool
+		row := conn.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL")
+		if err := row.Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return applied, nil
+		}
+	} else {
+		_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamp with time zone NOT NULL
+		)`)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// locked runs fn while holding the migration advisory lock. Advisory
+// locks belong to a connection so everything has to run on the same one.
+func (m *Migrator) locked(fn func(conn *sql.Conn) error) error {
+	return m.withConn(func(conn *sql.Conn) error {
+		ctx := context.Background()
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+		return fn(conn)
+	})
+}
+
+func (m *Migrator) withConn(fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
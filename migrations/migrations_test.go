@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAllEmbedded(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations, received none")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, received %d", i, i+1, m.Version)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("Expected %04d_%s to have up and down sql", m.Version, m.Name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_second.up.sql":   {Data: []byte("up 2")},
+		"sql/0002_second.down.sql": {Data: []byte("down 2")},
+		"sql/0001_first.up.sql":    {Data: []byte("up 1")},
+		"sql/0001_first.down.sql":  {Data: []byte("down 1")},
+	}
+	migrations, err := parse(fsys, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, received %d", len(migrations))
+	}
+	if migrations[0].Name != "first" || migrations[0].Up != "up 1" || migrations[0].Down != "down 1" {
+		t.Errorf("Unexpected first migration %+v", migrations[0])
+	}
+	if migrations[1].Version != 2 {
+		t.Errorf("Expected migrations sorted by version, received %+v", migrations)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"sql/0001_first.up.sql": {Data: []byte("up")},
+		},
+		"duplicate version": {
+			"sql/0001_first.up.sql":    {Data: []byte("up")},
+			"sql/0001_first.down.sql":  {Data: []byte("down")},
+			"sql/0001_second.up.sql":   {Data: []byte("up")},
+			"sql/0001_second.down.sql": {Data: []byte("down")},
+		},
+		"bad name": {
+			"sql/first.sql": {Data: []byte("up")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := parse(fsys, "sql"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	_, err := parse(cases["missing down"], "sql")
+	if !errors.Is(err, ErrNoDown) {
+		t.Errorf("Expected ErrNoDown, received %v", err)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS galleries;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,28 @@
+-- The users and galleries tables as gorm's AutoMigrate created them.
+-- IF NOT EXISTS lets databases that were set up by AutoMigrate adopt
+-- this migration without losing any data.
+CREATE TABLE IF NOT EXISTS users (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	name text,
+	email text NOT NULL,
+	password_hash text NOT NULL,
+	remember_hash text NOT NULL
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled boolean NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_remember_hash ON users (remember_hash);
+
+CREATE TABLE IF NOT EXISTS galleries (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	user_id integer,
+	title text
+);
+CREATE INDEX IF NOT EXISTS idx_galleries_deleted_at ON galleries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_galleries_user_id ON galleries (user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE sessions (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash text NOT NULL,
+	user_agent text NOT NULL DEFAULT '',
+	ip text NOT NULL DEFAULT '',
+	last_seen_at timestamp with time zone NOT NULL,
+	rotated_at timestamp with time zone NOT NULL,
+	expires_at timestamp with time zone NOT NULL
+);
+CREATE INDEX idx_sessions_deleted_at ON sessions (deleted_at);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions (expires_at);
+CREATE UNIQUE INDEX uix_sessions_token_hash ON sessions (token_hash);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+	DROP COLUMN IF EXISTS verified,
+	DROP COLUMN IF EXISTS verified_at,
+	DROP COLUMN IF EXISTS pending_email;
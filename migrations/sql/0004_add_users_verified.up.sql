@@ -0,0 +1,7 @@
+ALTER TABLE users
+	ADD COLUMN verified boolean NOT NULL DEFAULT false,
+	ADD COLUMN verified_at timestamp with time zone,
+	ADD COLUMN pending_email text NOT NULL DEFAULT '';
+
+-- Accounts from before we verified email addresses keep full access
+UPDATE users SET verified = true, verified_at = now();
@@ -0,0 +1,18 @@
+CREATE TABLE images (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	gallery_id integer NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+	key text NOT NULL,
+	filename text NOT NULL,
+	size bigint NOT NULL DEFAULT 0,
+	content_type text NOT NULL DEFAULT '',
+	width integer NOT NULL DEFAULT 0,
+	height integer NOT NULL DEFAULT 0,
+	checksum text NOT NULL DEFAULT '',
+	position integer NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_images_deleted_at ON images (deleted_at);
+CREATE INDEX idx_images_gallery_id ON images (gallery_id, position);
+CREATE UNIQUE INDEX uix_images_key ON images (key) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE images
+	DROP COLUMN IF EXISTS variant_widths,
+	DROP COLUMN IF EXISTS variant_webp;
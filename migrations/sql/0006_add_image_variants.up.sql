@@ -0,0 +1,3 @@
+ALTER TABLE images
+	ADD COLUMN variant_widths text NOT NULL DEFAULT '',
+	ADD COLUMN variant_webp boolean NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+ALTER TABLE images
+	DROP COLUMN IF EXISTS job_id,
+	DROP COLUMN IF EXISTS processed_at;
+DROP TABLE IF EXISTS jobs;
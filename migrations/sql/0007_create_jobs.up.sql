@@ -0,0 +1,22 @@
+CREATE TABLE jobs (
+	id bigserial PRIMARY KEY,
+	kind text NOT NULL,
+	payload text NOT NULL DEFAULT '{}',
+	status text NOT NULL DEFAULT 'queued',
+	attempts integer NOT NULL DEFAULT 0,
+	max_attempts integer NOT NULL,
+	run_at timestamp with time zone NOT NULL DEFAULT now(),
+	locked_at timestamp with time zone,
+	last_error text NOT NULL DEFAULT '',
+	created_at timestamp with time zone NOT NULL DEFAULT now(),
+	updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+-- Workers only ever look for queued jobs that are due
+CREATE INDEX idx_jobs_queued ON jobs (run_at, id) WHERE status = 'queued';
+CREATE INDEX idx_jobs_status ON jobs (status);
+
+ALTER TABLE images
+	ADD COLUMN job_id bigint NOT NULL DEFAULT 0,
+	ADD COLUMN processed_at timestamp with time zone;
+-- Images uploaded so far were processed during the upload
+UPDATE images SET processed_at = updated_at;
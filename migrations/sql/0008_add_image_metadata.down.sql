@@ -0,0 +1,16 @@
+ALTER TABLE images
+	DROP COLUMN IF EXISTS camera_make,
+	DROP COLUMN IF EXISTS camera_model,
+	DROP COLUMN IF EXISTS lens,
+	DROP COLUMN IF EXISTS focal_length,
+	DROP COLUMN IF EXISTS aperture,
+	DROP COLUMN IF EXISTS exposure_time,
+	DROP COLUMN IF EXISTS iso,
+	DROP COLUMN IF EXISTS captured_at,
+	DROP COLUMN IF EXISTS latitude,
+	DROP COLUMN IF EXISTS longitude,
+	DROP COLUMN IF EXISTS title,
+	DROP COLUMN IF EXISTS caption,
+	DROP COLUMN IF EXISTS creator,
+	DROP COLUMN IF EXISTS copyright,
+	DROP COLUMN IF EXISTS keywords;
@@ -0,0 +1,16 @@
+ALTER TABLE images
+	ADD COLUMN camera_make text NOT NULL DEFAULT '',
+	ADD COLUMN camera_model text NOT NULL DEFAULT '',
+	ADD COLUMN lens text NOT NULL DEFAULT '',
+	ADD COLUMN focal_length double precision NOT NULL DEFAULT 0,
+	ADD COLUMN aperture double precision NOT NULL DEFAULT 0,
+	ADD COLUMN exposure_time double precision NOT NULL DEFAULT 0,
+	ADD COLUMN iso integer NOT NULL DEFAULT 0,
+	ADD COLUMN captured_at timestamp with time zone,
+	ADD COLUMN latitude double precision,
+	ADD COLUMN longitude double precision,
+	ADD COLUMN title text NOT NULL DEFAULT '',
+	ADD COLUMN caption text NOT NULL DEFAULT '',
+	ADD COLUMN creator text NOT NULL DEFAULT '',
+	ADD COLUMN copyright text NOT NULL DEFAULT '',
+	ADD COLUMN keywords text[];
@@ -0,0 +1,8 @@
+ALTER TABLE images
+	DROP COLUMN IF EXISTS public_key;
+
+ALTER TABLE galleries
+	DROP COLUMN IF EXISTS metadata_policy;
+
+ALTER TABLE users
+	DROP COLUMN IF EXISTS metadata_policy;
@@ -0,0 +1,8 @@
+ALTER TABLE users
+	ADD COLUMN metadata_policy text NOT NULL DEFAULT 'strip-gps';
+
+ALTER TABLE galleries
+	ADD COLUMN metadata_policy text NOT NULL DEFAULT '';
+
+ALTER TABLE images
+	ADD COLUMN public_key text NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS gallery_collaborators;
+
+DROP INDEX IF EXISTS idx_galleries_visibility;
+DROP INDEX IF EXISTS uix_galleries_slug;
+ALTER TABLE galleries
+	DROP COLUMN IF EXISTS slug,
+	DROP COLUMN IF EXISTS visibility;
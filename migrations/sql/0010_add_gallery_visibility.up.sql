@@ -0,0 +1,17 @@
+-- Galleries that were readable by anyone who knew their id become
+-- private until their owners pick otherwise. Slugs are made the next
+-- time a gallery is saved.
+ALTER TABLE galleries
+	ADD COLUMN visibility text NOT NULL DEFAULT 'private'
+		CHECK (visibility IN ('private', 'unlisted', 'public')),
+	ADD COLUMN slug text NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX uix_galleries_slug ON galleries (slug) WHERE slug <> '';
+CREATE INDEX idx_galleries_visibility ON galleries (visibility, created_at);
+
+CREATE TABLE gallery_collaborators (
+	gallery_id integer NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at timestamp with time zone,
+	PRIMARY KEY (gallery_id, user_id)
+);
+CREATE INDEX idx_gallery_collaborators_user_id ON gallery_collaborators (user_id);
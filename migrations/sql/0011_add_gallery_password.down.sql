@@ -0,0 +1,2 @@
+ALTER TABLE galleries
+	DROP COLUMN IF EXISTS password_hash;
@@ -0,0 +1,3 @@
+-- Empty means the gallery has no password
+ALTER TABLE galleries
+	ADD COLUMN password_hash text NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+CREATE TABLE share_links (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	gallery_id integer NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+	token_hash text NOT NULL,
+	expires_at timestamp with time zone,
+	max_views integer NOT NULL DEFAULT 0 CHECK (max_views >= 0),
+	allow_downloads boolean NOT NULL DEFAULT false,
+	views integer NOT NULL DEFAULT 0,
+	last_viewed_at timestamp with time zone,
+	revoked_at timestamp with time zone
+);
+CREATE INDEX idx_share_links_deleted_at ON share_links (deleted_at);
+CREATE INDEX idx_share_links_gallery_id ON share_links (gallery_id);
+CREATE UNIQUE INDEX uix_share_links_token_hash ON share_links (token_hash);
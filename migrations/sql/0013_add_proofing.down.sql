@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS proof_selections;
+
+ALTER TABLE share_links
+	DROP COLUMN IF EXISTS submitted_at;
+ALTER TABLE galleries
+	DROP COLUMN IF EXISTS select_limit,
+	DROP COLUMN IF EXISTS proofing;
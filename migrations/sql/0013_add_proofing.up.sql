@@ -0,0 +1,15 @@
+ALTER TABLE galleries
+	ADD COLUMN proofing boolean NOT NULL DEFAULT false,
+	ADD COLUMN select_limit integer NOT NULL DEFAULT 0 CHECK (select_limit >= 0);
+ALTER TABLE share_links
+	ADD COLUMN submitted_at timestamp with time zone;
+
+CREATE TABLE proof_selections (
+	share_link_id integer NOT NULL REFERENCES share_links (id) ON DELETE CASCADE,
+	image_id integer NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+	selected boolean NOT NULL DEFAULT false,
+	note text NOT NULL DEFAULT '',
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	PRIMARY KEY (share_link_id, image_id)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_sessions_prev_token_hash;
+ALTER TABLE sessions
+	DROP COLUMN IF EXISTS prev_expires_at,
+	DROP COLUMN IF EXISTS prev_token_hash;
@@ -0,0 +1,6 @@
+-- The token a session had before it was last rotated, which keeps
+-- working for a short while after
+ALTER TABLE sessions
+	ADD COLUMN prev_token_hash text NOT NULL DEFAULT '',
+	ADD COLUMN prev_expires_at timestamp with time zone;
+CREATE INDEX idx_sessions_prev_token_hash ON sessions (prev_token_hash);
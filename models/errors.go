@@ -21,9 +21,86 @@ const (
 	ErrPasswordRequired modelError = "models: password is required"
 	// ErrTitleRequired is returned when a create or get on a gallery is attempted without a title
 	ErrTitleRequired modelError = "models: the title of the gallery is required"
+	// ErrFilenameRequired is returned when an image is created without a filename
+	ErrFilenameRequired modelError = "models: a filename is required"
+	// ErrImageType is returned when an upload isn't an image type we accept
+	ErrImageType modelError = "models: only JPEG, PNG, GIF and WebP images can be uploaded"
+	// ErrImageTooBig is returned when an uploaded file is over MaxImageBytes
+	ErrImageTooBig modelError = "models: images must be 25 MB or smaller"
+	// ErrImageDimensions is returned when an image is wider or taller
+	// than MaxImageSide, or has more than MaxImagePixels pixels
+	ErrImageDimensions modelError = "models: images can be at most 20000 pixels on a side and 100 megapixels in total"
+	// ErrImageCorrupt is returned when an upload claims to be an image
+	// but we can't read its header
+	ErrImageCorrupt modelError = "models: the image could not be read, it may be damaged"
+	// ErrImageOrderInvalid is returned when a new image order leaves out
+	// or repeats an image, or includes one from another gallery
+	ErrImageOrderInvalid modelError = "models: the new order must include every image in the gallery once"
+	// ErrImageSortInvalid is returned when images are sorted by
+	// something other than capture time or filename
+	ErrImageSortInvalid modelError = "models: images can only be sorted by capture time or filename"
+	// ErrSidecarUnmatched is returned when an XMP sidecar doesn't share
+	// a name with any image in the gallery
+	ErrSidecarUnmatched modelError = "models: no image in the gallery has the same name as this sidecar"
+	// ErrSidecarInvalid is returned when an XMP sidecar can't be parsed
+	ErrSidecarInvalid modelError = "models: the XMP sidecar could not be read"
+	// ErrMetadataPolicyInvalid is returned when a user or gallery is
+	// given a metadata policy that isn't one of metadata.Policies
+	ErrMetadataPolicyInvalid modelError = "models: that metadata policy isn't one we know"
+	// ErrImageURLInvalid is returned for image URLs with a signature
+	// we didn't make
+	ErrImageURLInvalid modelError = "models: this image link isn't valid"
+	// ErrImageURLExpired is returned for signed image URLs that have
+	// expired
+	ErrImageURLExpired modelError = "models: this image link has expired"
+	// ErrVisibilityInvalid is returned when a gallery's visibility
+	// isn't private, unlisted or public
+	ErrVisibilityInvalid modelError = "models: a gallery can only be private, unlisted or public"
+	// ErrCollaboratorIsOwner is returned when the owner of a gallery
+	// is added to it as a collaborator
+	ErrCollaboratorIsOwner modelError = "models: you already own this gallery"
+	// ErrShareLinkExpired is returned when a gallery is opened with a
+	// share link whose time is up
+	ErrShareLinkExpired modelError = "models: this link has expired"
+	// ErrShareLinkUsedUp is returned when a gallery is opened with a
+	// share link more times than it allows
+	ErrShareLinkUsedUp modelError = "models: this link has been used as many times as it allows"
+	// ErrShareLinkMaxViewsInvalid is returned when a share link is
+	// made with a negative number of views
+	ErrShareLinkMaxViewsInvalid modelError = "models: the number of views can't be negative"
+	// ErrShareLinkExpiresInPast is returned when a share link is made
+	// that has already expired
+	ErrShareLinkExpiresInPast modelError = "models: a link can't expire in the past"
+	// ErrSelectLimitInvalid is returned when a gallery's limit on
+	// proofing selects is negative
+	ErrSelectLimitInvalid modelError = "models: the number of selects can't be negative"
+	// ErrProofingOff is returned when a selection is made in a
+	// gallery that isn't in proofing mode
+	ErrProofingOff modelError = "models: this gallery isn't taking selections"
+	// ErrProofSubmitted is returned when a selection is changed after
+	// it was submitted
+	ErrProofSubmitted modelError = "models: your selection has already been sent"
+	// ErrProofSelectLimit is returned when more images are selected
+	// than the gallery allows
+	ErrProofSelectLimit modelError = "models: you have selected as many images as you can, unselect one first"
+	// ErrProofNothingSelected is returned when a selection without
+	// any images is submitted
+	ErrProofNothingSelected modelError = "models: select at least one image before sending your selection"
+	// ErrProofNoteTooLong is returned when a note on an image is
+	// longer than MaxProofNoteLength
+	ErrProofNoteTooLong modelError = "models: notes can be at most 2000 characters long"
+	// ErrTokenInvalid is returned when a password reset or verification
+	// token is unknown, already used or expired
+	ErrTokenInvalid modelError = "models: token provided is not valid or has expired"
+	// ErrUserDisabled is returned when a disabled user attempts to log in
+	ErrUserDisabled modelError = "models: this account has been disabled"
 
 	//ErrUserIDRequired is returned when a create or get is attempted without a UserID
 	ErrUserIDRequired privateError = "models: the userID is required"
+	// ErrGalleryIDRequired is returned when an image is created without a gallery
+	ErrGalleryIDRequired privateError = "models: the galleryID is required"
+	// ErrKeyRequired is returned when an image is created without a storage key
+	ErrKeyRequired privateError = "models: the image key is required"
 	// ErrIDInvalid is returned when an invalid ID is provided to a method like delete
 	ErrIDInvalid privateError = "models: ID provided was invalid"
 	// ErrRememberTooShort when a rememebr token is not at least 32 bytes
@@ -1,22 +1,117 @@
 package models
 
 import (
+	"strconv"
+	"strings"
+	"time"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/metadata"
+	"lenslocked.com/rand"
+
 	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Who can see a gallery
+const (
+	// VisibilityPrivate galleries can only be seen by their owner and
+	// collaborators
+	VisibilityPrivate = "private"
+	// VisibilityUnlisted galleries can also be seen by anyone with
+	// their share link, /g/<slug>
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPublic galleries can be seen by anyone and are listed
+	// on their owner's profile and the discover page
+	VisibilityPublic = "public"
 )
 
+// GallerySlugBytes is how many random bytes make up a gallery's slug
+const GallerySlugBytes = 18
+
 // Gallery is our image container resource that visitors
 // view
 type Gallery struct {
 	gorm.Model
-	UserID uint     `gorm:"not_null;index"`
-	Title  string   `gorm:"not_null"`
-	Images []string `gorm:"-"`
+	UserID uint    `gorm:"not_null;index"`
+	Title  string  `gorm:"not_null"`
+	Images []Image `gorm:"-"`
+	// MetadataPolicy overrides the owner's User.MetadataPolicy for
+	// images in this gallery when it isn't empty
+	MetadataPolicy string `gorm:"not null;default:''"`
+	// Visibility is one of VisibilityPrivate, VisibilityUnlisted or
+	// VisibilityPublic
+	Visibility string `gorm:"not null;default:'private'"`
+	// Slug is the unguessable part of the gallery's share link
+	Slug string `gorm:"not null;default:''"`
+	// Password, when set, is hashed into PasswordHash on save.
+	// Visitors have to enter it before they can see the gallery.
+	Password     string `gorm:"-"`
+	PasswordHash string `gorm:"not null;default:''"`
+	// Proofing lets clients who open the gallery with a ShareLink
+	// select images and leave notes on them, see ProofService.
+	// SelectLimit is how many they can select, 0 for no limit.
+	Proofing    bool `gorm:"not null;default:false"`
+	SelectLimit int  `gorm:"not null;default:0"`
+
+	// viaSlug is set when the gallery was found by its slug, so the
+	// pages it links to stay under the share link
+	viaSlug bool
+	// shareLink is set when the gallery was found with ByShareLink
+	shareLink *ShareLink
+}
+
+// Path is the URL of the gallery's page. Galleries opened from their
+// share link, or one of their ShareLinks, keep using it.
+func (g Gallery) Path() string {
+	if g.shareLink != nil {
+		return g.shareLink.Path()
+	}
+	if g.viaSlug {
+		return g.SharePath()
+	}
+	return "/galleries/" + strconv.FormatUint(uint64(g.ID), 10)
+}
+
+// SharePath is the gallery's share link
+func (g Gallery) SharePath() string {
+	return "/g/" + g.Slug
+}
+
+// ShareLink is the link the gallery was opened with, or nil
+func (g Gallery) ShareLink() *ShareLink {
+	return g.shareLink
 }
 
-func (g *Gallery) ImagesSplitN(n int) [][]string {
-	ret := make([][]string, n)
+// IsPublic reports whether anyone can see the gallery
+func (g Gallery) IsPublic() bool {
+	return g.Visibility == VisibilityPublic
+}
+
+// Protected reports whether the gallery has a password
+func (g Gallery) Protected() bool {
+	return g.PasswordHash != ""
+}
+
+// Unlockable reports whether a visitor could see the gallery once
+// they have entered its password. Private galleries stay private.
+func (g Gallery) Unlockable() bool {
+	return g.Protected() && (g.IsPublic() || g.shareLink != nil ||
+		(g.Visibility == VisibilityUnlisted && g.viaSlug))
+}
+
+// GalleryCollaborator lets a user other than the owner see a gallery
+// that isn't public
+type GalleryCollaborator struct {
+	GalleryID uint `gorm:"primary_key;auto_increment:false"`
+	UserID    uint `gorm:"primary_key;auto_increment:false"`
+	CreatedAt time.Time
+}
+
+func (g *Gallery) ImagesSplitN(n int) [][]Image {
+	ret := make([][]Image, n)
 	for i := 0; i < n; i++ {
-		ret[i] = make([]string, 0)
+		ret[i] = make([]Image, 0)
 	}
 
 	for i, img := range g.Images {
@@ -28,34 +123,154 @@ func (g *Gallery) ImagesSplitN(n int) [][]string {
 
 type GalleryService interface {
 	GalleryDB
+	// CanView reports whether user, who may be nil, can see the
+	// gallery. Unlisted galleries can only be seen by anyone when
+	// they were found with BySlug, and others when they were found
+	// with ByShareLink and the link is still Open. Galleries with a
+	// password also need an unlock token from Unlock, except for
+	// their owner and collaborators.
+	CanView(gallery *Gallery, user *User, unlock string) (bool, error)
+	// Unlock checks the gallery's password and returns a token that
+	// lets whoever holds it see the gallery until it expires. The
+	// token stops working when the password is changed.
+	Unlock(gallery *Gallery, password string, ttl time.Duration) (token string, expires time.Time, err error)
+	// RotateSlug gives the gallery a new share link. The old one
+	// stops working.
+	RotateSlug(gallery *Gallery) error
 }
 
 type GalleryDB interface {
+	All() ([]Gallery, error)
 	ByUserID(id uint) ([]Gallery, error)
 	ByID(id uint) (*Gallery, error)
+	// BySlug finds a gallery by its share link
+	BySlug(slug string) (*Gallery, error)
+	// ByShareLink finds a gallery by the token of one of its
+	// ShareLinks that hasn't been revoked
+	ByShareLink(token string) (*Gallery, error)
+	// PublicByUserID returns the user's public galleries, newest first
+	PublicByUserID(userID uint) ([]Gallery, error)
+	// Public returns the newest public galleries, at most limit
+	Public(limit int) ([]Gallery, error)
+	// ByCollaborator returns the galleries the user collaborates on
+	ByCollaborator(userID uint) ([]Gallery, error)
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
 	Delete(id uint) error
+
+	// Collaborators returns the users who can see the gallery besides
+	// its owner, by email
+	Collaborators(galleryID uint) ([]User, error)
+	IsCollaborator(galleryID, userID uint) (bool, error)
+	// AddCollaborator does nothing if the user already is one
+	AddCollaborator(galleryID, userID uint) error
+	RemoveCollaborator(galleryID, userID uint) error
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
+// NewGalleryService hashes gallery passwords with pepper like user
+// passwords, and signs unlock tokens with hmacKey
+func NewGalleryService(db *gorm.DB, pepper, hmacKey string) GalleryService {
+	hmac := hash.NewHMAC(hmacKey)
 	return &galleryService{
-		GalleryDB: &galleryValidator{&galleryGorm{db}},
+		GalleryDB: &galleryValidator{GalleryDB: &galleryGorm{db}, pepper: pepper, hmac: hmac},
+		pepper:    pepper,
+		hmac:      hmac,
+		now:       time.Now,
 	}
 }
 
 type galleryService struct {
 	GalleryDB
+	pepper string
+	hmac   hash.HMAC
+	now    func() time.Time
+}
+
+func (gs *galleryService) CanView(gallery *Gallery, user *User, unlock string) (bool, error) {
+	visible := gallery.IsPublic() ||
+		(gallery.Visibility == VisibilityUnlisted && gallery.viaSlug) ||
+		(gallery.shareLink != nil && gallery.shareLink.Open(gs.now()))
+	switch {
+	case visible && !gallery.Protected():
+		return true, nil
+	case visible && unlock != "" && gs.unlocked(gallery, unlock):
+		return true, nil
+	case user == nil:
+		return false, nil
+	case user.ID == gallery.UserID:
+		return true, nil
+	}
+	return gs.IsCollaborator(gallery.ID, user.ID)
+}
+
+func (gs *galleryService) Unlock(gallery *Gallery, password string, ttl time.Duration) (string, time.Time, error) {
+	if !gallery.Protected() {
+		return "", time.Time{}, ErrPasswordIncorrect
+	}
+	err := bcrypt.CompareHashAndPassword(
+		[]byte(gallery.PasswordHash),
+		[]byte(password+gs.pepper))
+	switch err {
+	case nil:
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return "", time.Time{}, ErrPasswordIncorrect
+	default:
+		return "", time.Time{}, err
+	}
+	expires := gs.now().Add(ttl)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	token := exp + "." + gs.hmac.Hash(unlockMessage(gallery, exp))
+	return token, expires, nil
+}
+
+// unlocked checks an unlock token made by Unlock
+func (gs *galleryService) unlocked(gallery *Gallery, token string) bool {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 {
+		return false
+	}
+	exp, sig := token[:dot], token[dot+1:]
+	if !gs.hmac.Equal(unlockMessage(gallery, exp), sig) {
+		return false
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return gs.now().Before(time.Unix(expires, 0))
+}
+
+// unlockMessage is what gets signed for an unlock token. Signing the
+// password hash means a new password locks everyone out again. The
+// prefix keeps these from being mistaken for anything else hashed
+// with the same key.
+func unlockMessage(gallery *Gallery, expires string) string {
+	return "gallery-unlock:" + strconv.FormatUint(uint64(gallery.ID), 10) +
+		":" + expires + ":" + gallery.PasswordHash
+}
+
+func (gs *galleryService) RotateSlug(gallery *Gallery) error {
+	gallery.Slug = ""
+	return gs.Update(gallery)
 }
 
 type galleryValidator struct {
 	GalleryDB
+	pepper string
+	// hmac hashes share link tokens, see ShareLink
+	hmac hash.HMAC
 }
 
 func (gv *galleryValidator) Create(gallery *Gallery) error {
 	err := runGalleryValidationFuncs(gallery,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.metadataPolicyValid,
+		gv.visibilityValid,
+		gv.setSlugIfUnset,
+		gv.passwordMinLength,
+		gv.bcryptPassword,
+		gv.selectLimitValid)
 	if err != nil {
 		return err
 	}
@@ -65,7 +280,13 @@ func (gv *galleryValidator) Create(gallery *Gallery) error {
 func (gv *galleryValidator) Update(gallery *Gallery) error {
 	err := runGalleryValidationFuncs(gallery,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.metadataPolicyValid,
+		gv.visibilityValid,
+		gv.setSlugIfUnset,
+		gv.passwordMinLength,
+		gv.bcryptPassword,
+		gv.selectLimitValid)
 	if err != nil {
 		return err
 	}
@@ -98,6 +319,93 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+// metadataPolicyValid allows an empty policy, which means the
+// owner's policy is used
+func (gv *galleryValidator) metadataPolicyValid(g *Gallery) error {
+	if g.MetadataPolicy != "" && !metadata.Policy(g.MetadataPolicy).Valid() {
+		return ErrMetadataPolicyInvalid
+	}
+	return nil
+}
+
+// BySlug won't look up the empty slug, which galleries made before
+// slugs existed have until they are next saved
+func (gv *galleryValidator) BySlug(slug string) (*Gallery, error) {
+	if slug == "" {
+		return nil, ErrNotFound
+	}
+	return gv.GalleryDB.BySlug(slug)
+}
+
+// ByShareLink hashes the token before looking it up, and keeps the
+// raw token on the link so the gallery's pages can link back to it
+func (gv *galleryValidator) ByShareLink(token string) (*Gallery, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	gallery, err := gv.GalleryDB.ByShareLink(gv.hmac.Hash(token))
+	if err != nil {
+		return nil, err
+	}
+	gallery.shareLink.Token = token
+	return gallery, nil
+}
+
+// visibilityValid makes new galleries private unless they say
+// otherwise
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	switch g.Visibility {
+	case "":
+		g.Visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+	default:
+		return ErrVisibilityInvalid
+	}
+	return nil
+}
+
+func (gv *galleryValidator) passwordMinLength(g *Gallery) error {
+	if g.Password != "" && len(g.Password) < 8 {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
+
+// bcryptPassword hashes the gallery's password the same way as user
+// passwords. Clearing PasswordHash removes the password.
+func (gv *galleryValidator) bcryptPassword(g *Gallery) error {
+	if g.Password == "" {
+		return nil
+	}
+	pwBytes := []byte(g.Password + gv.pepper)
+	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	g.PasswordHash = string(hashedBytes)
+	g.Password = ""
+	return nil
+}
+
+func (gv *galleryValidator) selectLimitValid(g *Gallery) error {
+	if g.SelectLimit < 0 {
+		return ErrSelectLimitInvalid
+	}
+	return nil
+}
+
+func (gv *galleryValidator) setSlugIfUnset(g *Gallery) error {
+	if g.Slug != "" {
+		return nil
+	}
+	slug, err := rand.String(GallerySlugBytes)
+	if err != nil {
+		return err
+	}
+	g.Slug = slug
+	return nil
+}
+
 func (gv *galleryValidator) ensureIDGreaterThan(n uint) galleryValidatorFunc {
 	return galleryValidatorFunc(func(gallery *Gallery) error {
 		if gallery.ID <= n {
@@ -120,12 +428,89 @@ func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
 	return &gallery, err
 }
 
+func (gg *galleryGorm) BySlug(slug string) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Where("slug = ?", slug)
+	err := first(db, &gallery)
+	gallery.viaSlug = true
+	return &gallery, err
+}
+
+// ByShareLink expects the token to already be hashed
+func (gg *galleryGorm) ByShareLink(tokenHash string) (*Gallery, error) {
+	var link ShareLink
+	db := gg.db.Where("token_hash = ? AND revoked_at IS NULL", tokenHash)
+	if err := first(db, &link); err != nil {
+		return nil, err
+	}
+	gallery, err := gg.ByID(link.GalleryID)
+	if err != nil {
+		return nil, err
+	}
+	gallery.shareLink = &link
+	return gallery, nil
+}
+
+func (gg *galleryGorm) PublicByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Where("user_id = ? AND visibility = ?", userID, VisibilityPublic).
+		Order("created_at DESC").Find(&galleries).Error
+	return galleries, err
+}
+
+func (gg *galleryGorm) Public(limit int) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Where("visibility = ?", VisibilityPublic).
+		Order("created_at DESC").Limit(limit).Find(&galleries).Error
+	return galleries, err
+}
+
+func (gg *galleryGorm) ByCollaborator(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Joins("JOIN gallery_collaborators ON gallery_collaborators.gallery_id = galleries.id").
+		Where("gallery_collaborators.user_id = ?", userID).
+		Order("galleries.id").Find(&galleries).Error
+	return galleries, err
+}
+
+func (gg *galleryGorm) Collaborators(galleryID uint) ([]User, error) {
+	var users []User
+	err := gg.db.Joins("JOIN gallery_collaborators ON gallery_collaborators.user_id = users.id").
+		Where("gallery_collaborators.gallery_id = ?", galleryID).
+		Order("users.email").Find(&users).Error
+	return users, err
+}
+
+func (gg *galleryGorm) IsCollaborator(galleryID, userID uint) (bool, error) {
+	var count int
+	err := gg.db.Model(&GalleryCollaborator{}).
+		Where("gallery_id = ? AND user_id = ?", galleryID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (gg *galleryGorm) AddCollaborator(galleryID, userID uint) error {
+	c := GalleryCollaborator{GalleryID: galleryID, UserID: userID}
+	return gg.db.Where(c).FirstOrCreate(&c).Error
+}
+
+func (gg *galleryGorm) RemoveCollaborator(galleryID, userID uint) error {
+	return gg.db.Where("gallery_id = ? AND user_id = ?", galleryID, userID).
+		Delete(&GalleryCollaborator{}).Error
+}
+
 func (gg *galleryGorm) ByUserID(id uint) ([]Gallery, error) {
 	var galleries []Gallery
 	gg.db.Where("user_id = ?", id).Find(&galleries)
 	return galleries, nil
 }
 
+// All returns every gallery ordered by ID
+func (gg *galleryGorm) All() ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Order("id").Find(&galleries).Error
+	return galleries, err
+}
+
 // Create creates a gallery in the db via GORM
 func (gg *galleryGorm) Create(gallery *Gallery) error {
 	return gg.db.Create(gallery).Error
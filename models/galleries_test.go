@@ -0,0 +1,112 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"lenslocked.com/hash"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestGalleryCanView(t *testing.T) {
+	gs := &galleryService{}
+	owner := &User{Model: gorm.Model{ID: 1}}
+	gallery := func(visibility string, viaSlug bool) *Gallery {
+		return &Gallery{Model: gorm.Model{ID: 7}, UserID: owner.ID, Visibility: visibility, viaSlug: viaSlug}
+	}
+	cases := []struct {
+		gallery *Gallery
+		user    *User
+		want    bool
+	}{
+		{gallery(VisibilityPublic, false), nil, true},
+		{gallery(VisibilityUnlisted, true), nil, true},
+		{gallery(VisibilityUnlisted, false), nil, false},
+		{gallery(VisibilityPrivate, true), nil, false},
+		{gallery(VisibilityPrivate, false), owner, true},
+	}
+	for _, c := range cases {
+		got, err := gs.CanView(c.gallery, c.user, "")
+		if err != nil || got != c.want {
+			t.Errorf("CanView(%s, via slug %v, %v) = %v %v, want %v",
+				c.gallery.Visibility, c.gallery.viaSlug, c.user != nil, got, err, c.want)
+		}
+	}
+}
+
+func TestGalleryPath(t *testing.T) {
+	g := Gallery{Model: gorm.Model{ID: 7}, Slug: "abc"}
+	if g.Path() != "/galleries/7" || g.SharePath() != "/g/abc" {
+		t.Errorf("Unexpected paths %q %q", g.Path(), g.SharePath())
+	}
+	g.viaSlug = true
+	if g.Path() != "/g/abc" {
+		t.Errorf("Expected the share link to be kept, received %q", g.Path())
+	}
+}
+
+func TestGalleryVisibilityValid(t *testing.T) {
+	gv := &galleryValidator{}
+	g := Gallery{}
+	if err := gv.visibilityValid(&g); err != nil || g.Visibility != VisibilityPrivate {
+		t.Errorf("Expected galleries to default to private, received %q %v", g.Visibility, err)
+	}
+	g.Visibility = "secret"
+	if err := gv.visibilityValid(&g); err != ErrVisibilityInvalid {
+		t.Errorf("Expected ErrVisibilityInvalid, received %v", err)
+	}
+	if err := gv.setSlugIfUnset(&g); err != nil || len(g.Slug) != 24 {
+		t.Errorf("Expected a 24 character slug, received %q %v", g.Slug, err)
+	}
+}
+
+func TestGalleryUnlock(t *testing.T) {
+	now := time.Unix(1000, 0)
+	gs := &galleryService{pepper: "pepper", hmac: hash.NewHMAC("key"), now: func() time.Time { return now }}
+	gv := &galleryValidator{pepper: "pepper"}
+	gallery := &Gallery{Model: gorm.Model{ID: 7}, UserID: 1, Visibility: VisibilityPublic, Password: "short"}
+	if err := gv.passwordMinLength(gallery); err != ErrPasswordTooShort {
+		t.Errorf("Expected ErrPasswordTooShort, received %v", err)
+	}
+	gallery.Password = "correct horse"
+	if err := gv.bcryptPassword(gallery); err != nil || !gallery.Protected() || gallery.Password != "" {
+		t.Fatalf("Expected the password to be hashed, received %v", err)
+	}
+
+	if ok, _ := gs.CanView(gallery, nil, ""); ok {
+		t.Error("Expected a gallery with a password to be locked")
+	}
+	if _, _, err := gs.Unlock(gallery, "wrong horse", time.Hour); err != ErrPasswordIncorrect {
+		t.Errorf("Expected ErrPasswordIncorrect, received %v", err)
+	}
+	token, expires, err := gs.Unlock(gallery, "correct horse", time.Hour)
+	if err != nil || !expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Unlock = %v %v", expires, err)
+	}
+	if ok, _ := gs.CanView(gallery, nil, token); !ok {
+		t.Error("Expected the unlock token to let visitors in")
+	}
+
+	other := *gallery
+	other.ID = 8
+	if ok, _ := gs.CanView(&other, nil, token); ok {
+		t.Error("Expected the token to only unlock its own gallery")
+	}
+	private := *gallery
+	private.Visibility = VisibilityPrivate
+	if ok, _ := gs.CanView(&private, nil, token); ok || private.Unlockable() {
+		t.Error("Expected private galleries to stay private")
+	}
+
+	now = now.Add(time.Hour)
+	if ok, _ := gs.CanView(gallery, nil, token); ok {
+		t.Error("Expected the token to expire")
+	}
+	now = now.Add(-time.Minute)
+	gallery.Password = "another horse"
+	gv.bcryptPassword(gallery)
+	if ok, _ := gs.CanView(gallery, nil, token); ok {
+		t.Error("Expected a new password to revoke the token")
+	}
+}
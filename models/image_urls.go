@@ -0,0 +1,116 @@
+package models
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"lenslocked.com/hash"
+)
+
+// Query parameters of image URLs
+const (
+	// ImageWidthParam picks the variant of the image closest to this
+	// many pixels wide
+	ImageWidthParam = "w"
+	// ImageFormatParam picks the variant format, "jpg" or "webp"
+	ImageFormatParam = "fmt"
+
+	imageExpiresParam   = "expires"
+	imageSignatureParam = "sig"
+)
+
+// signedURLPeriod is what expiry times are rounded up to. The same
+// image signed in the same period gets the same URL, so browsers can
+// still cache it.
+const signedURLPeriod = 15 * time.Minute
+
+// ImageURLSigner signs the URLs of image files so that they can be
+// fetched by anyone holding the URL until it expires, even when the
+// gallery isn't public. The signature covers the key and every query
+// parameter, so none of them can be changed.
+type ImageURLSigner struct {
+	hmac hash.HMAC
+	now  func() time.Time
+}
+
+// NewImageURLSigner signs URLs with an HMAC keyed by hmacKey
+func NewImageURLSigner(hmacKey string) *ImageURLSigner {
+	return &ImageURLSigner{
+		hmac: hash.NewHMAC(hmacKey),
+		now:  time.Now,
+	}
+}
+
+// Sign returns the URL of the file at key, with params, that works
+// for at least ttl
+func (s *ImageURLSigner) Sign(key string, params url.Values, ttl time.Duration) string {
+	query := url.Values{}
+	for name, values := range params {
+		query[name] = values
+	}
+	expires := s.now().Add(ttl).Truncate(signedURLPeriod).Add(signedURLPeriod)
+	query.Set(imageExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Del(imageSignatureParam)
+	query.Set(imageSignatureParam, s.hmac.Hash(signedImageMessage(key, query)))
+	return "/images/" + key + "?" + query.Encode()
+}
+
+// Signed reports whether the query of a request for an image file
+// has a signature. Verify checks that it is valid.
+func (s *ImageURLSigner) Signed(query url.Values) bool {
+	return query.Get(imageSignatureParam) != ""
+}
+
+// Verify checks the signature and expiry of a signed URL for the file
+// at key. It returns ErrImageURLInvalid when the URL wasn't signed by
+// us or was changed, and ErrImageURLExpired when it is too late. The
+// time left before it expires is returned otherwise.
+func (s *ImageURLSigner) Verify(key string, query url.Values) (time.Duration, error) {
+	sig := query.Get(imageSignatureParam)
+	if sig == "" || !s.hmac.Equal(signedImageMessage(key, query), sig) {
+		return 0, ErrImageURLInvalid
+	}
+	expires, err := strconv.ParseInt(query.Get(imageExpiresParam), 10, 64)
+	if err != nil {
+		return 0, ErrImageURLInvalid
+	}
+	left := time.Unix(expires, 0).Sub(s.now())
+	if left <= 0 {
+		return 0, ErrImageURLExpired
+	}
+	return left, nil
+}
+
+// Images returns copies of images whose URLs are signed to last for
+// at least ttl
+func (s *ImageURLSigner) Images(images []Image, ttl time.Duration) []Image {
+	signed := make([]Image, len(images))
+	for i := range images {
+		signed[i] = s.Image(images[i], ttl)
+	}
+	return signed
+}
+
+// Image returns a copy of image whose URLs are signed to last for at
+// least ttl
+func (s *ImageURLSigner) Image(image Image, ttl time.Duration) Image {
+	image.sign = func(key string) string {
+		return s.Sign(key, nil, ttl)
+	}
+	return image
+}
+
+// signedImageMessage is what gets signed for a URL. Encode sorts the
+// parameters, so the order they are sent in doesn't matter. The
+// prefix keeps these from being mistaken for anything else hashed
+// with the same key.
+func signedImageMessage(key string, query url.Values) string {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != imageSignatureParam {
+			signed[name] = values
+		}
+	}
+	return "image:" + key + "?" + signed.Encode()
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageURLSigner(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewImageURLSigner("test-key")
+	s.now = func() time.Time { return now }
+
+	key := "galleries/1/9f/86/abc_public.jpg"
+	signed := s.Sign(key, url.Values{ImageWidthParam: {"800"}}, time.Hour)
+	u, err := url.Parse(signed)
+	if err != nil || u.Path != "/images/"+key {
+		t.Fatalf("Unexpected signed URL %q (%v)", signed, err)
+	}
+	query := u.Query()
+	if left, err := s.Verify(key, query); err != nil || left < time.Hour {
+		t.Errorf("Expected a valid URL for at least an hour, received %v %v", left, err)
+	}
+	// The same period gives the same URL so browsers can cache it
+	now = now.Add(time.Minute)
+	if again := s.Sign(key, url.Values{ImageWidthParam: {"800"}}, time.Hour); again != signed {
+		t.Errorf("Expected %q, received %q", signed, again)
+	}
+
+	changed := url.Values{}
+	for k, v := range query {
+		changed[k] = v
+	}
+	changed.Set(ImageWidthParam, "1600")
+	if _, err := s.Verify(key, changed); err != ErrImageURLInvalid {
+		t.Errorf("Expected a changed width to be invalid, received %v", err)
+	}
+	if _, err := s.Verify(strings.Replace(key, "_public", "", 1), query); err != ErrImageURLInvalid {
+		t.Errorf("Expected another key to be invalid, received %v", err)
+	}
+	other := NewImageURLSigner("other-key")
+	other.now = s.now
+	if _, err := other.Verify(key, query); err != ErrImageURLInvalid {
+		t.Errorf("Expected another HMAC key to be invalid, received %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := s.Verify(key, query); err != ErrImageURLExpired {
+		t.Errorf("Expected ErrImageURLExpired, received %v", err)
+	}
+}
+
+func TestSignedImagePaths(t *testing.T) {
+	s := NewImageURLSigner("test-key")
+	image := Image{Key: "galleries/1/9f/86/abc.jpg", VariantWidths: "320"}
+	signed := s.Image(image, time.Hour)
+	if !strings.HasPrefix(signed.Thumb(), "/images/galleries/1/9f/86/abc_320.jpg?expires=") {
+		t.Errorf("Expected a signed thumb, received %q", signed.Thumb())
+	}
+	if image.Thumb() != "/images/galleries/1/9f/86/abc_320.jpg" {
+		t.Errorf("Signing changed the original image, received %q", image.Thumb())
+	}
+	if image.SizedKey(1000, "webp") != "galleries/1/9f/86/abc_320.jpg" {
+		t.Errorf("Unexpected sized key %q", image.SizedKey(1000, "webp"))
+	}
+}
@@ -1,66 +1,1507 @@
 package models
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	goimage "image"
+	_ "image/gif"  // register the gif decoder for image.Decode
+	_ "image/jpeg" // register the jpeg decoder for image.Decode
+	_ "image/png"  // register the png decoder for image.Decode
 	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lenslocked.com/imaging"
+	"lenslocked.com/jobs"
+	"lenslocked.com/metadata"
+	"lenslocked.com/storage"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	_ "golang.org/x/image/webp" // register the webp decoder for image.Decode
+)
+
+const (
+	// MaxImageBytes is the largest file that can be uploaded as an image
+	MaxImageBytes = 25 << 20
+	// MaxImageSide is the most pixels an image can have in either direction
+	MaxImageSide = 20000
+	// MaxImagePixels caps width * height. A small, highly compressed
+	// file can still decode into gigabytes of pixels, so the limit is
+	// checked from the image header before anything decodes it.
+	MaxImagePixels = 100 * 1000 * 1000
+)
+
+// uploadTypes are the content types, as sniffed from the file
+// contents, that can be uploaded
+var uploadTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Image is a single photo uploaded to a gallery. The file itself is
+// kept in the blob store at Key, everything else we know about it is
+// stored in the images table.
+type Image struct {
+	gorm.Model
+	GalleryID   uint   `gorm:"not null;index"`
+	Key         string `gorm:"not null"`
+	Filename    string `gorm:"not null"`
+	Size        int64  `gorm:"not null"`
+	ContentType string `gorm:"not null"`
+	Width       int    `gorm:"not null"`
+	Height      int    `gorm:"not null"`
+	Checksum    string `gorm:"not null"`
+	Position    int    `gorm:"not null"`
+	// VariantWidths lists the widths of the resized copies made of
+	// the image, like "320,800". They are stored in JPEG next to the
+	// original, and in WebP too when VariantWebP is set.
+	VariantWidths string `gorm:"not null"`
+	VariantWebP   bool   `gorm:"column:variant_webp;not null"`
+	// JobID is the background job processing the image and
+	// ProcessedAt is set once it has finished
+	JobID       int64 `gorm:"not null"`
+	ProcessedAt *time.Time
+
+	// Metadata read from the file and any XMP sidecar uploaded with
+	// it. See metadata.Metadata for what each field holds.
+	CameraMake   string  `gorm:"not null"`
+	CameraModel  string  `gorm:"not null"`
+	Lens         string  `gorm:"not null"`
+	FocalLength  float64 `gorm:"not null"`
+	Aperture     float64 `gorm:"not null"`
+	ExposureTime float64 `gorm:"not null"`
+	ISO          int     `gorm:"column:iso;not null"`
+	CapturedAt   *time.Time
+	Latitude     *float64
+	Longitude    *float64
+	Title        string         `gorm:"not null"`
+	Caption      string         `gorm:"not null"`
+	Creator      string         `gorm:"not null"`
+	Copyright    string         `gorm:"not null"`
+	Keywords     pq.StringArray `gorm:"type:text[]"`
+
+	// PublicKey is where the copy of the original that visitors see
+	// is kept, with metadata removed as the gallery's
+	// metadata.Policy says. It is empty until the image is processed.
+	PublicKey string `gorm:"not null"`
+
+	// sign turns a key into a signed URL when the image is shown
+	// somewhere its gallery can't be seen, see ImageURLSigner
+	sign func(key string) string
+}
+
+// Image processing statuses
+const (
+	ImageProcessing = "processing"
+	ImageReady      = "ready"
+	ImageFailed     = "failed"
+)
+
+// ProcessImageJob is the kind of the job that makes an image's
+// variants after it is uploaded
+const ProcessImageJob = "image.process"
+
+// Path is the URL the full size image is served from. This is the
+// public copy once there is one, the original is only served to the
+// gallery's owner.
+func (i Image) Path() string {
+	if i.PublicKey != "" {
+		return i.url(i.PublicKey)
+	}
+	return i.url(i.Key)
+}
+
+// url is the URL the file at key is served from
+func (i Image) url(key string) string {
+	if i.sign != nil {
+		return i.sign(key)
+	}
+	return "/images/" + key
+}
+
+// Redacted returns a copy of the image without the metadata policy
+// says visitors shouldn't see
+func (i Image) Redacted(policy string) Image {
+	switch metadata.Policy(policy) {
+	case metadata.KeepAll:
+	case metadata.StripGPS:
+		i.Latitude, i.Longitude = nil, nil
+	default:
+		// Titles, captions and keywords are typed in by the owner to
+		// be shown, so they stay
+		i.setMetadata(&metadata.Metadata{
+			Title:    i.Title,
+			Caption:  i.Caption,
+			Keywords: i.Keywords,
+		})
+	}
+	return i
+}
+
+// Widths returns the widths of the image's variants, smallest first
+func (i Image) Widths() []int {
+	var widths []int
+	for _, w := range strings.Split(i.VariantWidths, ",") {
+		if n, err := strconv.Atoi(w); err == nil {
+			widths = append(widths, n)
+		}
+	}
+	return widths
+}
+
+// VariantPath is the URL of the variant with the given width and
+// extension. The original is returned for widths that don't exist.
+func (i Image) VariantPath(width int, ext string) string {
+	for _, w := range i.Widths() {
+		if w == width {
+			return i.url(variantKey(i.Key, width, ext))
+		}
+	}
+	return i.Path()
+}
+
+// Thumb is the URL of the smallest JPEG variant
+func (i Image) Thumb() string {
+	widths := i.Widths()
+	if len(widths) == 0 {
+		return i.Path()
+	}
+	return i.VariantPath(widths[0], ".jpg")
+}
+
+// Large is the URL of the biggest JPEG variant, used when the image
+// is viewed on its own
+func (i Image) Large() string {
+	widths := i.Widths()
+	if len(widths) == 0 {
+		return i.Path()
+	}
+	return i.VariantPath(widths[len(widths)-1], ".jpg")
+}
+
+// Srcset lists the variants with the given extension for use in an
+// img or source srcset attribute
+func (i Image) Srcset(ext string) string {
+	var parts []string
+	for _, w := range i.Widths() {
+		parts = append(parts, fmt.Sprintf("%s %dw", i.VariantPath(w, ext), w))
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("%s %dw", i.Path(), i.Width)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Metadata returns the image's metadata fields
+func (i Image) Metadata() *metadata.Metadata {
+	return &metadata.Metadata{
+		CameraMake:   i.CameraMake,
+		CameraModel:  i.CameraModel,
+		Lens:         i.Lens,
+		FocalLength:  i.FocalLength,
+		Aperture:     i.Aperture,
+		ExposureTime: i.ExposureTime,
+		ISO:          i.ISO,
+		CapturedAt:   i.CapturedAt,
+		Latitude:     i.Latitude,
+		Longitude:    i.Longitude,
+		Title:        i.Title,
+		Caption:      i.Caption,
+		Creator:      i.Creator,
+		Copyright:    i.Copyright,
+		Keywords:     i.Keywords,
+	}
+}
+
+// setMetadata replaces the image's metadata fields with m
+func (i *Image) setMetadata(m *metadata.Metadata) {
+	i.CameraMake = m.CameraMake
+	i.CameraModel = m.CameraModel
+	i.Lens = m.Lens
+	i.FocalLength = m.FocalLength
+	i.Aperture = m.Aperture
+	i.ExposureTime = m.ExposureTime
+	i.ISO = m.ISO
+	i.CapturedAt = m.CapturedAt
+	i.Latitude = m.Latitude
+	i.Longitude = m.Longitude
+	i.Title = m.Title
+	i.Caption = m.Caption
+	i.Creator = m.Creator
+	i.Copyright = m.Copyright
+	i.Keywords = pq.StringArray(m.Keywords)
+}
+
+// Camera is the make and model of the camera. Most models already
+// start with the make, like "Canon EOS R5", so it isn't repeated.
+func (i Image) Camera() string {
+	if i.CameraMake == "" || strings.HasPrefix(strings.ToLower(i.CameraModel), strings.ToLower(i.CameraMake)) {
+		return i.CameraModel
+	}
+	return strings.TrimSpace(i.CameraMake + " " + i.CameraModel)
+}
+
+// ShutterSpeed is the exposure time written the way cameras show
+// it, like "1/250 s" or "2.5 s"
+func (i Image) ShutterSpeed() string {
+	switch t := i.ExposureTime; {
+	case t <= 0:
+		return ""
+	case t < 1:
+		return fmt.Sprintf("1/%d s", int(math.Round(1/t)))
+	default:
+		return formatDecimal(t) + " s"
+	}
+}
+
+// FStop is the aperture, like "f/2.8"
+func (i Image) FStop() string {
+	if i.Aperture <= 0 {
+		return ""
+	}
+	return "f/" + formatDecimal(i.Aperture)
+}
+
+// Focal is the focal length, like "50 mm"
+func (i Image) Focal() string {
+	if i.FocalLength <= 0 {
+		return ""
+	}
+	return formatDecimal(i.FocalLength) + " mm"
+}
+
+// HasLocation reports whether we know where the photo was taken
+func (i Image) HasLocation() bool {
+	return i.Latitude != nil && i.Longitude != nil
+}
+
+// Coordinates is where the photo was taken, like "51.50073, -0.12463"
+func (i Image) Coordinates() string {
+	if !i.HasLocation() {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f", *i.Latitude, *i.Longitude)
+}
+
+// MapURL links to where the photo was taken on OpenStreetMap
+func (i Image) MapURL() string {
+	if !i.HasLocation() {
+		return ""
+	}
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=15/%.6f/%.6f",
+		*i.Latitude, *i.Longitude, *i.Latitude, *i.Longitude)
+}
+
+// formatDecimal rounds f to one decimal place, dropping it if it is 0
+func formatDecimal(f float64) string {
+	return strconv.FormatFloat(math.Round(f*10)/10, 'f', -1, 64)
+}
+
+// Ways a gallery's images can be sorted
+const (
+	// SortByCaptureTime puts images in the order they were taken.
+	// Images without a capture time go last, by filename.
+	SortByCaptureTime = "captured"
+	SortByFilename    = "filename"
 )
 
+// sortImages sorts images in place
+func sortImages(images []Image, by string) error {
+	byFilename := func(a, b *Image) bool {
+		fa, fb := strings.ToLower(a.Filename), strings.ToLower(b.Filename)
+		if fa != fb {
+			return fa < fb
+		}
+		return a.ID < b.ID
+	}
+	var less func(a, b *Image) bool
+	switch by {
+	case SortByFilename:
+		less = byFilename
+	case SortByCaptureTime:
+		less = func(a, b *Image) bool {
+			switch {
+			case a.CapturedAt != nil && b.CapturedAt != nil:
+				if !a.CapturedAt.Equal(*b.CapturedAt) {
+					return a.CapturedAt.Before(*b.CapturedAt)
+				}
+			case a.CapturedAt != nil || b.CapturedAt != nil:
+				return a.CapturedAt != nil
+			}
+			return byFilename(a, b)
+		}
+	default:
+		return ErrImageSortInvalid
+	}
+	sort.Slice(images, func(x, y int) bool {
+		return less(&images[x], &images[y])
+	})
+	return nil
+}
+
+// sidecarMatches reports whether the XMP sidecar named sidecar
+// belongs with the image named filename. Editors name sidecars
+// either IMG_1234.xmp or IMG_1234.jpg.xmp, and a sidecar made for a
+// raw file like IMG_1234.CR2.xmp is also used for the JPEG of it.
+func sidecarMatches(sidecar, filename string) bool {
+	ext := path.Ext(sidecar)
+	if !strings.EqualFold(ext, ".xmp") {
+		return false
+	}
+	name := strings.TrimSuffix(sidecar, ext)
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	return strings.EqualFold(name, filename) || strings.EqualFold(name, base) ||
+		strings.EqualFold(strings.TrimSuffix(name, path.Ext(name)), base)
+}
+
+// variantSizes are the widths images are resized to. Images are never
+// scaled up, so smaller images get fewer variants.
+var variantSizes = []int{320, 800, 1600}
+
+// variantKey is where the variant of the image stored at key lives.
+// Variants sit next to the original:
+//
+//	galleries/1/9f/86/9f86...0a08.jpg
+//	galleries/1/9f/86/9f86...0a08_320.jpg
+//	galleries/1/9f/86/9f86...0a08_320.webp
+func variantKey(key string, width int, ext string) string {
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(key, path.Ext(key)), width, ext)
+}
+
+// publicKey is where the public copy of the original stored at key
+// lives, next to its variants
+func publicKey(key string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_public" + ext
+}
+
+// isDerivedKey reports whether key is a variant or public copy
+// rather than an original
+func isDerivedKey(key string) bool {
+	_, ok := originalPrefix(key)
+	return ok
+}
+
+// originalPrefix returns what the key of the original a variant or
+// public copy was made from starts with, everything but its
+// extension. Originals imported from before keys were hashes can
+// have underscores of their own, so only the suffixes we add count.
+func originalPrefix(key string) (string, bool) {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	i := strings.LastIndexByte(base, '_')
+	if i < 0 || strings.Contains(base[i:], "/") {
+		return "", false
+	}
+	suffix := base[i+1:]
+	if suffix == "public" {
+		return base[:i], true
+	}
+	for _, w := range variantSizes {
+		if suffix == strconv.Itoa(w) {
+			return base[:i], true
+		}
+	}
+	return "", false
+}
+
+// keyGalleryID is the gallery a key is stored under, as in
+// "galleries/1/..."
+func keyGalleryID(key string) (uint, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || parts[0] != "galleries" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// SizedKey is the key of the smallest variant at least width pixels
+// wide, or of the biggest one, in format ("jpg" or "webp") if it was
+// made in that format. Without variants it is the full size image.
+func (i Image) SizedKey(width int, format string) string {
+	widths := i.Widths()
+	if len(widths) == 0 {
+		if i.PublicKey != "" {
+			return i.PublicKey
+		}
+		return i.Key
+	}
+	ext := ".jpg"
+	if format == "webp" && i.VariantWebP {
+		ext = ".webp"
+	}
+	for _, w := range widths {
+		if w >= width {
+			return variantKey(i.Key, w, ext)
+		}
+	}
+	return variantKey(i.Key, widths[len(widths)-1], ext)
+}
+
+// ImageFile is one of the files stored for an image: the original,
+// its public copy or one of its variants
+type ImageFile struct {
+	Image *Image
+	Key   string
+	// Original is set for the file as it was uploaded, which still
+	// has all of its metadata
+	Original bool
+}
+
+// Files lists the keys of every file stored for the image other
+// than the original
+func (i Image) Files() []string {
+	var keys []string
+	if i.PublicKey != "" {
+		keys = append(keys, i.PublicKey)
+	}
+	for _, w := range i.Widths() {
+		keys = append(keys, variantKey(i.Key, w, ".jpg"))
+		if i.VariantWebP {
+			keys = append(keys, variantKey(i.Key, w, ".webp"))
+		}
+	}
+	return keys
+}
+
+// ImageDB is used to interact with the images table
+type ImageDB interface {
+	ByID(id uint) (*Image, error)
+	// ByGalleryID returns a gallery's images in display order
+	ByGalleryID(galleryID uint) ([]Image, error)
+	ByKey(key string) (*Image, error)
+	// ByKeyPrefix returns the gallery's images whose keys start with
+	// prefix
+	ByKeyPrefix(galleryID uint, prefix string) ([]Image, error)
+	// MetadataPolicy is the metadata.Policy for the gallery's images,
+	// its own if it has one or else its owner's
+	MetadataPolicy(galleryID uint) (string, error)
+	// ByOwnerPolicy returns the images in the user's galleries that
+	// follow the user's metadata policy
+	ByOwnerPolicy(userID uint) ([]Image, error)
+
+	Create(image *Image) error
+	Update(image *Image) error
+	// UpdateProcessed only saves the columns processing sets:
+	// PublicKey, VariantWidths, VariantWebP and ProcessedAt. Edits
+	// made to the image while it was being processed are kept.
+	UpdateProcessed(image *Image) error
+	// UpdateJobID only saves JobID
+	UpdateJobID(image *Image) error
+	// UpdateMetadata only saves the metadata fields, the ones
+	// setMetadata sets
+	UpdateMetadata(image *Image) error
+	Delete(id uint) error
+}
+
+// ImageService keeps uploaded images in a storage.BlobStore and
+// tracks them in the database
 type ImageService interface {
-	Create(galleryId uint, r io.ReadCloser, filename string) error
-	ByGalleryID(galleryID uint) ([]string, error)
+	// Create stores the contents of r and records it as a new image
+	// at the end of the gallery. filename is only kept for display,
+	// images are stored under a key made from a hash of their
+	// contents. Uploading a file that is already in the gallery
+	// returns the existing image.
+	//
+	// Files that aren't JPEG, PNG, GIF or WebP images, are bigger than
+	// MaxImageBytes or have too many pixels are rejected with an error
+	// that can be shown to the user. Any EXIF, IPTC or XMP metadata in
+	// the file is read into the image when it is processed.
+	Create(galleryID uint, r io.Reader, filename string) (*Image, error)
+	ByID(id uint) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+	// ByKey finds the image whose original is stored at key
+	ByKey(key string) (*Image, error)
+	// File finds the image the file stored at key belongs to. Keys
+	// that aren't the original, public copy or a variant of an image
+	// we know about return ErrNotFound.
+	File(key string) (*ImageFile, error)
+	// MetadataPolicy is the metadata.Policy for the gallery's images
+	MetadataPolicy(galleryID uint) (string, error)
+	// Delete removes the image from storage and from the database
+	Delete(image *Image) error
+	// Reorder sets the display order of a gallery's images. ids must
+	// hold every image in the gallery exactly once, first to last.
+	Reorder(galleryID uint, ids []uint) error
+	// Move takes the image out of its gallery and adds it to the end
+	// of the gallery with galleryID. If that gallery already has the
+	// same image the two are merged and image is set to the existing one.
+	Move(image *Image, galleryID uint) error
+	// Copy adds a duplicate of the image to the end of the gallery
+	// with galleryID, unless it is already there
+	Copy(image *Image, galleryID uint) (*Image, error)
+	// GenerateVariants (re)creates the resized copies of the image
+	// and records which ones exist
+	GenerateVariants(image *Image) error
+	// Process does the slow work needed on a new image and marks it
+	// processed. New uploads are processed by a background job when
+	// the service has a job queue.
+	Process(image *Image) error
+	// Status reports whether the image is still being processed,
+	// is ready, or failed to process
+	Status(image *Image) (string, error)
+	// Original opens the file as it was uploaded, metadata and all.
+	// It must only be given to the gallery's owner.
+	Original(image *Image) (io.ReadSeekCloser, error)
+	// Public opens the full size file visitors are shown, which is
+	// the public copy when the image has one
+	Public(image *Image) (io.ReadSeekCloser, error)
+	// Reprocess queues every image in the gallery to be processed
+	// again, making new public copies after the metadata policy
+	// changes
+	Reprocess(galleryID uint) error
+	// ReprocessUser does the same for the images that follow the
+	// user's own metadata policy
+	ReprocessUser(userID uint) error
+	// ApplySidecar reads an XMP sidecar and adds what is in it to the
+	// gallery's images it belongs with (see sidecarMatches). Fields in
+	// the sidecar win over ones read from the image. It returns
+	// ErrSidecarUnmatched if no image matches the sidecar's filename.
+	ApplySidecar(galleryID uint, r io.Reader, filename string) ([]Image, error)
+	// ReadMetadata reads the metadata in the stored file, filling in
+	// any fields the image doesn't have yet
+	ReadMetadata(image *Image) error
+	// Sort reorders the gallery's images by SortByCaptureTime or
+	// SortByFilename
+	Sort(galleryID uint, by string) error
+	// Import records any files found in the gallery's storage that
+	// aren't in the database yet. It returns how many images were
+	// added.
+	Import(galleryID uint) (int, error)
+}
+
+// NewImageService keeps image files in store. Resized variants are
+// always made in JPEG, and in WebP too if webp isn't nil. When queue
+// isn't nil new images are processed by its workers, otherwise they
+// are processed before Create returns.
+func NewImageService(db *gorm.DB, store storage.BlobStore, webp imaging.Encoder, queue *jobs.Queue) ImageService {
+	is := &imageService{
+		ImageDB: &imageValidator{&imageGorm{db}},
+		store:   store,
+		jpeg:    imaging.NewJPEG(82),
+		webp:    webp,
+		jobs:    queue,
+	}
+	if queue != nil {
+		queue.Handle(ProcessImageJob, is.processJob)
+	}
+	return is
+}
+
+type imageService struct {
+	ImageDB
+	store storage.BlobStore
+	jpeg  imaging.Encoder
+	webp  imaging.Encoder
+	jobs  *jobs.Queue
+}
+
+// processImagePayload is the payload of a ProcessImageJob
+type processImagePayload struct {
+	ImageID uint `json:"image_id"`
+	// NewImage is set for uploads and imports, whose metadata is
+	// read from the original before it is processed
+	NewImage bool `json:"new_image,omitempty"`
+}
+
+func (is *imageService) Create(galleryID uint, r io.Reader, filename string) (*Image, error) {
+	filename = cleanFilename(filename)
+	if filename == "" {
+		return nil, ErrFilenameRequired
+	}
+	// Spool the upload to a temp file so that we can hash it before
+	// we know where it is going to be stored
+	tmp, err := ioutil.TempFile("", "lenslocked-upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, err := io.Copy(tmp, io.LimitReader(r, MaxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxImageBytes {
+		return nil, ErrImageTooBig
+	}
+	image := Image{
+		GalleryID: galleryID,
+		Filename:  filename,
+	}
+	if err := describeImage(tmp, &image); err != nil {
+		return nil, err
+	}
+	if err := checkUpload(&image); err != nil {
+		return nil, err
+	}
+	image.Key = is.contentKey(galleryID, image.Checksum, image.ContentType)
+	switch existing, err := is.ByKey(image.Key); err {
+	case nil:
+		// The same file is already in this gallery
+		return existing, nil
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := is.store.Put(image.Key, tmp, image.ContentType); err != nil {
+		return nil, err
+	}
+	if err := is.create(&image); err != nil {
+		// The same file uploaded at the same time is stored under
+		// the same key, so the blob may belong to the upload that
+		// got its row in first. It is only removed when no row
+		// points at it.
+		switch existing, findErr := is.ByKey(image.Key); findErr {
+		case nil:
+			return existing, nil
+		case ErrNotFound:
+			is.store.Delete(image.Key)
+		}
+		return nil, err
+	}
+	if err := is.queueProcessing(&image, true); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (is *imageService) Import(galleryID uint) (int, error) {
+	blobs, err := is.store.List(fmt.Sprintf("galleries/%v/", galleryID))
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, blob := range blobs {
+		if isDerivedKey(blob.Key) {
+			continue
+		}
+		switch _, err := is.ByKey(blob.Key); err {
+		case nil:
+			continue
+		case ErrNotFound:
+		default:
+			return added, err
+		}
+		r, _, err := is.store.Get(blob.Key)
+		if err != nil {
+			return added, err
+		}
+		image := Image{GalleryID: galleryID, Key: blob.Key, Filename: path.Base(blob.Key)}
+		err = describeImage(r, &image)
+		r.Close()
+		if err != nil {
+			return added, err
+		}
+		if err := is.create(&image); err != nil {
+			return added, err
+		}
+		if err := is.queueProcessing(&image, true); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+func (is *imageService) Delete(image *Image) error {
+	err := is.store.Delete(image.Key)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	is.deleteVariants(image)
+	return is.ImageDB.Delete(image.ID)
+}
+
+func (is *imageService) GenerateVariants(image *Image) error {
+	r, _, err := is.store.Get(image.Key)
+	if err != nil {
+		return err
+	}
+	src, _, err := goimage.Decode(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	old := *image
+	encoders := []imaging.Encoder{is.jpeg}
+	if is.webp != nil {
+		encoders = append(encoders, is.webp)
+	}
+	var widths []string
+	for _, width := range variantSizes {
+		if width >= src.Bounds().Dx() {
+			break
+		}
+		resized := imaging.Resize(src, width)
+		for _, enc := range encoders {
+			var buf bytes.Buffer
+			if err := enc.Encode(&buf, resized); err != nil {
+				return err
+			}
+			key := variantKey(image.Key, width, enc.Ext())
+			if err := is.store.Put(key, &buf, enc.ContentType()); err != nil {
+				return err
+			}
+		}
+		widths = append(widths, strconv.Itoa(width))
+	}
+	image.VariantWidths = strings.Join(widths, ",")
+	image.VariantWebP = is.webp != nil && len(widths) > 0
+	if err := is.UpdateProcessed(image); err != nil {
+		return err
+	}
+	// Drop anything an earlier run made that this one didn't
+	made := make(map[string]bool)
+	for _, w := range image.Widths() {
+		made[variantKey(image.Key, w, is.jpeg.Ext())] = true
+		if image.VariantWebP {
+			made[variantKey(image.Key, w, is.webp.Ext())] = true
+		}
+	}
+	for _, w := range old.Widths() {
+		for _, ext := range []string{".jpg", ".webp"} {
+			if key := variantKey(old.Key, w, ext); !made[key] {
+				is.store.Delete(key)
+			}
+		}
+	}
+	return nil
 }
 
-func NewImageService() ImageService {
-	return &imageService{}
+func (is *imageService) ApplySidecar(galleryID uint, r io.Reader, filename string) ([]Image, error) {
+	filename = cleanFilename(filename)
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	var matched []Image
+	for _, image := range images {
+		if sidecarMatches(filename, image.Filename) {
+			matched = append(matched, image)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, ErrSidecarUnmatched
+	}
+	sidecar, err := metadata.ReadXMP(r)
+	if err != nil {
+		return nil, ErrSidecarInvalid
+	}
+	for i := range matched {
+		m := *sidecar
+		m.Merge(matched[i].Metadata())
+		matched[i].setMetadata(&m)
+		if err := is.Update(&matched[i]); err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
 }
 
-type imageService struct{}
+func (is *imageService) ReadMetadata(image *Image) error {
+	r, _, err := is.store.Get(image.Key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	m, err := metadata.Read(r)
+	if err != nil && err != metadata.ErrMalformed {
+		return err
+	}
+	current := image.Metadata()
+	current.Merge(m)
+	image.setMetadata(current)
+	return is.Update(image)
+}
 
-func (is *imageService) Create(galleryId uint, r io.ReadCloser, filename string) error {
+// readOriginalMetadata fills in the metadata of a new image from its
+// original. Anything set on the image since it was uploaded, like an
+// XMP sidecar, is kept. Metadata is nice to have, so problems reading
+// it are only logged.
+func (is *imageService) readOriginalMetadata(image *Image) error {
+	r, _, err := is.store.Get(image.Key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	m, err := metadata.Read(r)
+	if err != nil {
+		log.Printf("images: reading metadata of %s: %v", image.Filename, err)
+	}
+	current := image.Metadata()
+	current.Merge(m)
+	image.setMetadata(current)
+	return is.UpdateMetadata(image)
+}
+
+func (is *imageService) Sort(galleryID uint, by string) error {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return err
+	}
+	if err := sortImages(images, by); err != nil {
+		return err
+	}
+	ids := make([]uint, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	return is.Reorder(galleryID, ids)
+}
+
+func (is *imageService) Process(image *Image) error {
+	if err := is.makePublicCopy(image); err != nil {
+		return err
+	}
+	if err := is.GenerateVariants(image); err != nil {
+		return err
+	}
+	now := time.Now()
+	image.ProcessedAt = &now
+	return is.UpdateProcessed(image)
+}
+
+// makePublicCopy stores the original with metadata stripped by the
+// gallery's policy at PublicKey. Variants are re-encoded and carry
+// no metadata, so the public copy is the only file that needs it.
+func (is *imageService) makePublicCopy(image *Image) error {
+	policy, err := is.MetadataPolicy(image.GalleryID)
+	if err != nil {
+		return err
+	}
+	r, _, err := is.store.Get(image.Key)
+	if err != nil {
+		return err
+	}
 	defer r.Close()
-	path, err := is.mkImagePath(galleryId)
+	var buf bytes.Buffer
+	if err := metadata.Strip(&buf, r, metadata.Policy(policy)); err != nil {
+		return err
+	}
+	key := publicKey(image.Key)
+	if err := is.store.Put(key, &buf, image.ContentType); err != nil {
+		return err
+	}
+	image.PublicKey = key
+	return is.UpdateProcessed(image)
+}
+
+func (is *imageService) File(key string) (*ImageFile, error) {
+	image, err := is.ByKey(key)
+	switch err {
+	case nil:
+		return &ImageFile{Image: image, Key: key, Original: true}, nil
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+	prefix, ok := originalPrefix(key)
+	galleryID, inGallery := keyGalleryID(key)
+	if !ok || !inGallery {
+		return nil, ErrNotFound
+	}
+	// Variants can have a different extension to their original
+	images, err := is.ByKeyPrefix(galleryID, prefix+".")
+	if err != nil {
+		return nil, err
+	}
+	for i := range images {
+		for _, file := range images[i].Files() {
+			if file == key {
+				return &ImageFile{Image: &images[i], Key: key}, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (is *imageService) Original(image *Image) (io.ReadSeekCloser, error) {
+	r, _, err := is.store.Get(image.Key)
+	return r, err
+}
+
+func (is *imageService) Public(image *Image) (io.ReadSeekCloser, error) {
+	key := image.Key
+	if image.PublicKey != "" {
+		key = image.PublicKey
+	}
+	r, _, err := is.store.Get(key)
+	return r, err
+}
+
+func (is *imageService) Reprocess(galleryID uint) error {
+	images, err := is.ByGalleryID(galleryID)
 	if err != nil {
 		return err
 	}
-	// Create the destination file
-	dst, err := os.Create(path + filename)
+	return is.reprocess(images)
+}
+
+func (is *imageService) ReprocessUser(userID uint) error {
+	images, err := is.ByOwnerPolicy(userID)
+	if err != nil {
+		return err
+	}
+	return is.reprocess(images)
+}
+
+func (is *imageService) reprocess(images []Image) error {
+	for i := range images {
+		if err := is.queueProcessing(&images[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (is *imageService) Status(image *Image) (string, error) {
+	if image.ProcessedAt != nil {
+		return ImageReady, nil
+	}
+	if is.jobs == nil || image.JobID == 0 {
+		return ImageProcessing, nil
+	}
+	job, err := is.jobs.ByID(image.JobID)
+	switch err {
+	case nil:
+	case jobs.ErrNotFound:
+		// Finished jobs get cleaned up, but then the image would
+		// have been marked as processed
+		return ImageFailed, nil
+	default:
+		return "", err
+	}
+	if job.Status == jobs.StatusDead {
+		return ImageFailed, nil
+	}
+	return ImageProcessing, nil
+}
+
+// queueProcessing marks the image as unprocessed and queues a job to
+// process it, or processes it right away if there is no job queue.
+// newImage also has the job read the metadata from the original, so
+// that is kept off the upload request as well.
+func (is *imageService) queueProcessing(image *Image, newImage bool) error {
+	image.ProcessedAt = nil
+	if is.jobs == nil {
+		// Without variants the original is shown, so a failure here
+		// isn't worth failing the upload over
+		if err := is.process(image, newImage); err != nil {
+			log.Printf("images: processing image %d: %v", image.ID, err)
+		}
+		return nil
+	}
+	// Saved before the job is queued, so a worker that finishes
+	// before we get back isn't undone
+	if err := is.UpdateProcessed(image); err != nil {
+		return err
+	}
+	id, err := is.jobs.Enqueue(ProcessImageJob, processImagePayload{ImageID: image.ID, NewImage: newImage})
+	if err != nil {
+		return err
+	}
+	image.JobID = id
+	return is.UpdateJobID(image)
+}
+
+// processJob is the handler for ProcessImageJob
+func (is *imageService) processJob(ctx context.Context, job *jobs.Job) error {
+	var payload processImagePayload
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+	image, err := is.ByID(payload.ImageID)
+	if err == ErrNotFound {
+		// Deleted before we got to it
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
+	return is.process(image, payload.NewImage)
+}
+
+// process reads the metadata of new images before processing them
+func (is *imageService) process(image *Image, newImage bool) error {
+	if newImage {
+		if err := is.readOriginalMetadata(image); err != nil {
+			return err
+		}
+	}
+	return is.Process(image)
+}
+
+// deleteVariants removes the image's variants and public copy from
+// the store. It is best effort, a leftover variant is harmless.
+func (is *imageService) deleteVariants(image *Image) {
+	for _, key := range image.Files() {
+		is.store.Delete(key)
+	}
+}
 
-	// Copy the reder data to our destination file
-	_, err = io.Copy(dst, r)
+func (is *imageService) Reorder(galleryID uint, ids []uint) error {
+	images, err := is.ByGalleryID(galleryID)
 	if err != nil {
 		return err
 	}
+	if len(ids) != len(images) {
+		return ErrImageOrderInvalid
+	}
+	byID := make(map[uint]*Image, len(images))
+	for i := range images {
+		byID[images[i].ID] = &images[i]
+	}
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if byID[id] == nil || seen[id] {
+			return ErrImageOrderInvalid
+		}
+		seen[id] = true
+	}
+	for pos, id := range ids {
+		image := byID[id]
+		if image.Position == pos {
+			continue
+		}
+		image.Position = pos
+		if err := is.Update(image); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (is *imageService) ByGalleryID(id uint) ([]string, error) {
-	// get the path
-	path := is.imagePath(id)
-	files, err := filepath.Glob(path + "*")
+// Move copies the blob to its new key, points the record at it and
+// only then removes the old blob
+func (is *imageService) Move(image *Image, galleryID uint) error {
+	if image.GalleryID == galleryID {
+		return nil
+	}
+	key := is.contentKey(galleryID, image.Checksum, image.ContentType)
+	switch existing, err := is.ByKey(key); err {
+	case nil:
+		// Already there, moving it just removes this copy
+		if err := is.Delete(image); err != nil {
+			return err
+		}
+		*image = *existing
+		return nil
+	case ErrNotFound:
+	default:
+		return err
+	}
+	if err := is.copyBlob(image.Key, key, image.ContentType); err != nil {
+		return err
+	}
+	moved := *image
+	moved.GalleryID = galleryID
+	moved.Key = key
+	// The new gallery may have a different policy, so the public copy
+	// is made again along with the variants
+	moved.PublicKey = ""
+	position, err := is.nextPosition(galleryID)
+	if err != nil {
+		return err
+	}
+	moved.Position = position
+	if err := is.Update(&moved); err != nil {
+		is.store.Delete(key)
+		return err
+	}
+	if err := is.store.Delete(image.Key); err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	is.deleteVariants(image)
+	*image = moved
+	// The variants have to be made again under the new key
+	return is.queueProcessing(image, false)
+}
+
+func (is *imageService) Copy(image *Image, galleryID uint) (*Image, error) {
+	src, _, err := is.store.Get(image.Key)
 	if err != nil {
 		return nil, err
 	}
-	for i := range files {
-		files[i] = "/" + files[i]
+	defer src.Close()
+	copied, err := is.Create(galleryID, src, image.Filename)
+	if err != nil {
+		return nil, err
+	}
+	// Keep anything that came from a sidecar rather than the file
+	m := image.Metadata()
+	m.Merge(copied.Metadata())
+	copied.setMetadata(m)
+	if err := is.Update(copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+func (is *imageService) copyBlob(from, to, contentType string) error {
+	src, _, err := is.store.Get(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return is.store.Put(to, src, contentType)
+}
+
+// nextPosition is the position of a new image added to the end of
+// the gallery
+func (is *imageService) nextPosition(galleryID uint) (int, error) {
+	images, err := is.ByGalleryID(galleryID)
+	if err != nil {
+		return 0, err
+	}
+	if n := len(images); n > 0 {
+		return images[n-1].Position + 1, nil
+	}
+	return 0, nil
+}
+
+// create adds the image to the end of its gallery
+func (is *imageService) create(image *Image) error {
+	position, err := is.nextPosition(image.GalleryID)
+	if err != nil {
+		return err
+	}
+	image.Position = position
+	return is.ImageDB.Create(image)
+}
+
+// contentKey is where an image is kept in the store. It is made from
+// the SHA-256 of the file so names chosen by users never reach the
+// store, and the same file is only ever stored once per gallery. The
+// hash is sharded into two levels of directories so no one directory
+// gets too big:
+//
+//	galleries/1/9f/86/9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08.jpg
+//
+// Keys always use forward slashes since they are also used to build URLs.
+func (is *imageService) contentKey(galleryID uint, checksum, contentType string) string {
+	return fmt.Sprintf("galleries/%v/%s/%s/%s%s",
+		galleryID, checksum[0:2], checksum[2:4], checksum, imageExtensions[contentType])
+}
+
+// imageExtensions are the extensions stored images get, picked from
+// the sniffed content type rather than the uploaded filename
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// cleanFilename turns an uploaded filename into something safe to
+// show, dropping any directories and control characters. It returns
+// an empty string if nothing is left.
+func cleanFilename(filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = path.Base(path.Clean("/" + strings.Replace(filename, "\\", "/", -1)))
+	if filename == "/" || filename == "." || filename == ".." {
+		return ""
+	}
+	return filename
+}
+
+// checkUpload makes sure a described upload is an image we accept
+func checkUpload(img *Image) error {
+	if !uploadTypes[img.ContentType] {
+		return ErrImageType
+	}
+	if img.Width <= 0 || img.Height <= 0 {
+		return ErrImageCorrupt
+	}
+	if img.Width > MaxImageSide || img.Height > MaxImageSide ||
+		img.Width*img.Height > MaxImagePixels {
+		return ErrImageDimensions
+	}
+	return nil
+}
+
+// describeImage fills in the size, checksum, content type and
+// dimensions of img from the file contents in r, reading it from the
+// start
+func describeImage(r io.ReadSeeker, img *Image) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	img.ContentType = http.DetectContentType(head[:n])
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	img.Size = size
+	img.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	if !strings.HasPrefix(img.ContentType, "image/") {
+		return nil
 	}
-	return files, nil
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// Formats we can't decode just don't get dimensions
+	if cfg, _, err := goimage.DecodeConfig(r); err == nil {
+		img.Width = cfg.Width
+		img.Height = cfg.Height
+	}
+	return nil
 }
 
-func (is *imageService) imagePath(galleryID uint) string {
-	return fmt.Sprintf("images/galleries/%v/", galleryID)
+type imageValidator struct {
+	ImageDB
 }
 
-func (is *imageService) mkImagePath(galleryID uint) (string, error) {
-	galleryPath := is.imagePath(galleryID)
-	err := os.MkdirAll(galleryPath, 0755)
+func (iv *imageValidator) Create(image *Image) error {
+	err := runImageValidationFuncs(image,
+		iv.galleryIDRequired,
+		iv.keyRequired,
+		iv.filenameRequired,
+		iv.normalizePosition)
 	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Create(image)
+}
+
+func (iv *imageValidator) UpdateProcessed(image *Image) error {
+	err := runImageValidationFuncs(image,
+		iv.ensureIDGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.UpdateProcessed(image)
+}
+
+func (iv *imageValidator) UpdateJobID(image *Image) error {
+	err := runImageValidationFuncs(image,
+		iv.ensureIDGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.UpdateJobID(image)
+}
+
+func (iv *imageValidator) UpdateMetadata(image *Image) error {
+	err := runImageValidationFuncs(image,
+		iv.ensureIDGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.UpdateMetadata(image)
+}
+
+func (iv *imageValidator) Update(image *Image) error {
+	err := runImageValidationFuncs(image,
+		iv.ensureIDGreaterThan(0),
+		iv.galleryIDRequired,
+		iv.keyRequired,
+		iv.filenameRequired,
+		iv.normalizePosition)
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Update(image)
+}
+
+func (iv *imageValidator) Delete(id uint) error {
+	var image Image
+	image.ID = id
+
+	err := runImageValidationFuncs(&image,
+		iv.ensureIDGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Delete(id)
+}
+
+func (iv *imageValidator) galleryIDRequired(i *Image) error {
+	if i.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func (iv *imageValidator) keyRequired(i *Image) error {
+	if i.Key == "" {
+		return ErrKeyRequired
+	}
+	return nil
+}
+
+func (iv *imageValidator) filenameRequired(i *Image) error {
+	if i.Filename == "" {
+		return ErrFilenameRequired
+	}
+	return nil
+}
+
+func (iv *imageValidator) normalizePosition(i *Image) error {
+	if i.Position < 0 {
+		i.Position = 0
+	}
+	return nil
+}
+
+func (iv *imageValidator) ensureIDGreaterThan(n uint) imageValidatorFunc {
+	return imageValidatorFunc(func(image *Image) error {
+		if image.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+var _ ImageDB = &imageGorm{}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+func (ig *imageGorm) ByID(id uint) (*Image, error) {
+	var image Image
+	db := ig.db.Where("id = ?", id)
+	err := first(db, &image)
+	return &image, err
+}
+
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("gallery_id = ?", galleryID).
+		Order("position, id").Find(&images).Error
+	return images, err
+}
+
+func (ig *imageGorm) ByKey(key string) (*Image, error) {
+	var image Image
+	db := ig.db.Where("key = ?", key)
+	err := first(db, &image)
+	return &image, err
+}
+
+func (ig *imageGorm) ByKeyPrefix(galleryID uint, prefix string) ([]Image, error) {
+	var images []Image
+	// left() rather than LIKE so nothing in prefix needs escaping
+	err := ig.db.Where("gallery_id = ? AND left(key, char_length(?)) = ?", galleryID, prefix, prefix).
+		Find(&images).Error
+	return images, err
+}
+
+func (ig *imageGorm) MetadataPolicy(galleryID uint) (string, error) {
+	var policy string
+	row := ig.db.Raw(`SELECT COALESCE(NULLIF(galleries.metadata_policy, ''), users.metadata_policy)
+		FROM galleries JOIN users ON users.id = galleries.user_id
+		WHERE galleries.id = ? AND galleries.deleted_at IS NULL`, galleryID).Row()
+	switch err := row.Scan(&policy); err {
+	case nil:
+		return policy, nil
+	case sql.ErrNoRows:
+		return "", ErrNotFound
+	default:
 		return "", err
 	}
-	return galleryPath, nil
+}
+
+func (ig *imageGorm) ByOwnerPolicy(userID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Joins("JOIN galleries ON galleries.id = images.gallery_id").
+		Where("galleries.user_id = ? AND galleries.metadata_policy = '' AND galleries.deleted_at IS NULL", userID).
+		Order("images.id").Find(&images).Error
+	return images, err
+}
+
+func (ig *imageGorm) Create(image *Image) error {
+	return ig.db.Create(image).Error
+}
+
+func (ig *imageGorm) Update(image *Image) error {
+	return ig.db.Save(image).Error
+}
+
+func (ig *imageGorm) UpdateProcessed(image *Image) error {
+	return ig.db.Model(&Image{Model: gorm.Model{ID: image.ID}}).Updates(map[string]interface{}{
+		"public_key":     image.PublicKey,
+		"variant_widths": image.VariantWidths,
+		"variant_webp":   image.VariantWebP,
+		"processed_at":   image.ProcessedAt,
+	}).Error
+}
+
+func (ig *imageGorm) UpdateJobID(image *Image) error {
+	return ig.db.Model(&Image{Model: gorm.Model{ID: image.ID}}).
+		Update("job_id", image.JobID).Error
+}
+
+func (ig *imageGorm) UpdateMetadata(image *Image) error {
+	return ig.db.Model(&Image{Model: gorm.Model{ID: image.ID}}).Updates(map[string]interface{}{
+		"camera_make":   image.CameraMake,
+		"camera_model":  image.CameraModel,
+		"lens":          image.Lens,
+		"focal_length":  image.FocalLength,
+		"aperture":      image.Aperture,
+		"exposure_time": image.ExposureTime,
+		"iso":           image.ISO,
+		"captured_at":   image.CapturedAt,
+		"latitude":      image.Latitude,
+		"longitude":     image.Longitude,
+		"title":         image.Title,
+		"caption":       image.Caption,
+		"creator":       image.Creator,
+		"copyright":     image.Copyright,
+		"keywords":      image.Keywords,
+	}).Error
+}
+
+func (ig *imageGorm) Delete(id uint) error {
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Delete(&image).Error
+}
+
+type imageValidatorFunc func(*Image) error
+
+func runImageValidationFuncs(image *Image, fns ...imageValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(image); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,390 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	goimage "image"
+	"image/jpeg"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lenslocked.com/imaging"
+	"lenslocked.com/storage"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestCleanFilename(t *testing.T) {
+	cases := map[string]string{
+		"photo.jpg":               "photo.jpg",
+		"../../etc/passwd":        "passwd",
+		"..\\..\\windows\\ok.png": "ok.png",
+		"/abs/path/me.gif":        "me.gif",
+		"new\nline.jpg":           "newline.jpg",
+		"..":                      "",
+		"":                        "",
+	}
+	for in, want := range cases {
+		if got := cleanFilename(in); got != want {
+			t.Errorf("cleanFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestContentKey(t *testing.T) {
+	is := &imageService{}
+	sum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	got := is.contentKey(12, sum, "image/jpeg")
+	want := "galleries/12/9f/86/" + sum + ".jpg"
+	if got != want {
+		t.Errorf("Expected key %q, received %q", want, got)
+	}
+	// Types we don't know get no extension at all
+	if got := is.contentKey(12, sum, "text/html; charset=utf-8"); got != "galleries/12/9f/86/"+sum {
+		t.Errorf("Unexpected key for html %q", got)
+	}
+}
+
+func TestCheckUpload(t *testing.T) {
+	cases := []struct {
+		image Image
+		want  error
+	}{
+		{Image{ContentType: "image/jpeg", Width: 4000, Height: 3000}, nil},
+		{Image{ContentType: "image/webp", Width: 10, Height: 10}, nil},
+		{Image{ContentType: "text/html; charset=utf-8"}, ErrImageType},
+		{Image{ContentType: "image/bmp", Width: 10, Height: 10}, ErrImageType},
+		{Image{ContentType: "image/png"}, ErrImageCorrupt},
+		{Image{ContentType: "image/png", Width: MaxImageSide + 1, Height: 1}, ErrImageDimensions},
+		{Image{ContentType: "image/gif", Width: 15000, Height: 15000}, ErrImageDimensions},
+	}
+	for _, c := range cases {
+		if err := checkUpload(&c.image); err != c.want {
+			t.Errorf("checkUpload(%+v) = %v, want %v", c.image, err, c.want)
+		}
+	}
+}
+
+func TestImageVariants(t *testing.T) {
+	image := Image{Key: "galleries/1/9f/86/abc.png", Width: 1000, VariantWidths: "320,800", VariantWebP: true}
+	if got := image.Thumb(); got != "/images/galleries/1/9f/86/abc_320.jpg" {
+		t.Errorf("Unexpected thumb %q", got)
+	}
+	if got := image.Large(); got != "/images/galleries/1/9f/86/abc_800.jpg" {
+		t.Errorf("Unexpected large %q", got)
+	}
+	want := "/images/galleries/1/9f/86/abc_320.webp 320w, /images/galleries/1/9f/86/abc_800.webp 800w"
+	if got := image.Srcset(".webp"); got != want {
+		t.Errorf("Expected srcset %q, received %q", want, got)
+	}
+
+	// Images without variants fall back to the original
+	image.VariantWidths = ""
+	if image.Thumb() != image.Path() || image.Srcset(".jpg") != "/images/galleries/1/9f/86/abc.png 1000w" {
+		t.Errorf("Expected the original to be used, received %q and %q", image.Thumb(), image.Srcset(".jpg"))
+	}
+}
+
+func TestImageExposure(t *testing.T) {
+	image := Image{
+		CameraMake:   "Canon",
+		CameraModel:  "Canon EOS R5",
+		ExposureTime: 0.004,
+		Aperture:     2.8000001,
+		FocalLength:  50,
+	}
+	if image.Camera() != "Canon EOS R5" || image.ShutterSpeed() != "1/250 s" ||
+		image.FStop() != "f/2.8" || image.Focal() != "50 mm" {
+		t.Errorf("Unexpected exposure %q %q %q %q", image.Camera(), image.ShutterSpeed(), image.FStop(), image.Focal())
+	}
+	image = Image{CameraMake: "FUJIFILM", CameraModel: "X-T4", ExposureTime: 2.5}
+	if image.Camera() != "FUJIFILM X-T4" || image.ShutterSpeed() != "2.5 s" || image.FStop() != "" {
+		t.Errorf("Unexpected exposure %q %q %q", image.Camera(), image.ShutterSpeed(), image.FStop())
+	}
+}
+
+func TestSortImages(t *testing.T) {
+	at := func(hour int) *time.Time {
+		t := time.Date(2020, 1, 1, hour, 0, 0, 0, time.UTC)
+		return &t
+	}
+	images := []Image{
+		{Model: gorm.Model{ID: 1}, Filename: "c.jpg"},
+		{Model: gorm.Model{ID: 2}, Filename: "B.jpg", CapturedAt: at(12)},
+		{Model: gorm.Model{ID: 3}, Filename: "a.jpg"},
+		{Model: gorm.Model{ID: 4}, Filename: "d.jpg", CapturedAt: at(9)},
+	}
+	order := func() string {
+		var ids []string
+		for _, image := range images {
+			ids = append(ids, strconv.Itoa(int(image.ID)))
+		}
+		return strings.Join(ids, ",")
+	}
+	if err := sortImages(images, SortByCaptureTime); err != nil || order() != "4,2,3,1" {
+		t.Errorf("Expected capture order 4,2,3,1, received %s (%v)", order(), err)
+	}
+	if err := sortImages(images, SortByFilename); err != nil || order() != "3,2,1,4" {
+		t.Errorf("Expected filename order 3,2,1,4, received %s (%v)", order(), err)
+	}
+	if err := sortImages(images, "size"); err != ErrImageSortInvalid {
+		t.Errorf("Expected ErrImageSortInvalid, received %v", err)
+	}
+}
+
+func TestSidecarMatches(t *testing.T) {
+	cases := []struct {
+		sidecar, filename string
+		want              bool
+	}{
+		{"IMG_1234.xmp", "IMG_1234.jpg", true},
+		{"img_1234.XMP", "IMG_1234.JPG", true},
+		{"IMG_1234.jpg.xmp", "IMG_1234.jpg", true},
+		{"IMG_1234.CR2.xmp", "IMG_1234.jpg", true},
+		{"IMG_1235.xmp", "IMG_1234.jpg", false},
+		{"IMG_1234.jpg", "IMG_1234.jpg", false},
+	}
+	for _, c := range cases {
+		if got := sidecarMatches(c.sidecar, c.filename); got != c.want {
+			t.Errorf("sidecarMatches(%q, %q) = %v, want %v", c.sidecar, c.filename, got, c.want)
+		}
+	}
+}
+
+func TestImagePublicCopy(t *testing.T) {
+	key := "galleries/1/9f/86/abc.jpg"
+	image := Image{Key: key, PublicKey: publicKey(key)}
+	if image.Path() != "/images/galleries/1/9f/86/abc_public.jpg" {
+		t.Errorf("Unexpected public path %q", image.Path())
+	}
+	if isDerivedKey(key) || !isDerivedKey(image.PublicKey) || !isDerivedKey(variantKey(key, 320, ".webp")) {
+		t.Errorf("Expected only the public copy and variants to be derived")
+	}
+	// Imported originals can have underscores of their own
+	if prefix, ok := originalPrefix("galleries/1/IMG_1234_800.webp"); !ok || prefix != "galleries/1/IMG_1234" {
+		t.Errorf("Unexpected prefix %q", prefix)
+	}
+	if isDerivedKey("galleries/1/IMG_1234.jpg") || isDerivedKey("galleries/1/a_b/c.jpg") {
+		t.Errorf("Expected imported originals not to be derived")
+	}
+	image.VariantWidths, image.VariantWebP = "320", true
+	want := "galleries/1/9f/86/abc_public.jpg galleries/1/9f/86/abc_320.jpg galleries/1/9f/86/abc_320.webp"
+	if got := strings.Join(image.Files(), " "); got != want {
+		t.Errorf("Expected files %q, received %q", want, got)
+	}
+	if id, ok := keyGalleryID(key); !ok || id != 1 {
+		t.Errorf("Expected gallery 1, received %d", id)
+	}
+}
+
+func TestImageRedacted(t *testing.T) {
+	lat, lon := 51.5, -0.125
+	image := Image{
+		CameraModel: "X-T4",
+		Latitude:    &lat,
+		Longitude:   &lon,
+		Title:       "Big Ben",
+		Keywords:    []string{"london"},
+	}
+	if r := image.Redacted("keep"); !r.HasLocation() {
+		t.Errorf("Expected keep to leave the location")
+	}
+	if r := image.Redacted("strip-gps"); r.HasLocation() || r.CameraModel != "X-T4" {
+		t.Errorf("Expected only the location to be removed, received %+v", r)
+	}
+	r := image.Redacted("strip-all")
+	if r.HasLocation() || r.CameraModel != "" || r.Title != "Big Ben" || len(r.Keywords) != 1 {
+		t.Errorf("Expected only the title and keywords to be left, received %+v", r)
+	}
+	if !image.HasLocation() {
+		t.Errorf("Redacted changed the original image")
+	}
+}
+
+// processDB records what processing saves, and fails the test when
+// the whole image is saved over edits made in the meantime
+type processDB struct {
+	ImageDB
+	t        *testing.T
+	saved    []Image
+	metadata []Image
+}
+
+func (db *processDB) MetadataPolicy(galleryID uint) (string, error) {
+	return "strip-gps", nil
+}
+
+func (db *processDB) Update(image *Image) error {
+	db.t.Error("Expected processing to only save the columns it sets")
+	return nil
+}
+
+func (db *processDB) UpdateProcessed(image *Image) error {
+	db.saved = append(db.saved, *image)
+	return nil
+}
+
+func (db *processDB) UpdateMetadata(image *Image) error {
+	db.metadata = append(db.metadata, *image)
+	return nil
+}
+
+func TestProcessOnlySavesProcessedColumns(t *testing.T) {
+	store := storage.NewMemoryStore()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, goimage.NewGray(goimage.Rect(0, 0, 400, 300)), nil); err != nil {
+		t.Fatal(err)
+	}
+	image := &Image{Model: gorm.Model{ID: 3}, GalleryID: 7, Key: "galleries/7/ab.jpg", ContentType: "image/jpeg"}
+	if err := store.Put(image.Key, &buf, image.ContentType); err != nil {
+		t.Fatal(err)
+	}
+	db := &processDB{t: t}
+	is := &imageService{ImageDB: db, store: store, jpeg: imaging.NewJPEG(82)}
+	if err := is.Process(image); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.saved) == 0 {
+		t.Fatal("Expected the processed columns to be saved")
+	}
+	last := db.saved[len(db.saved)-1]
+	if last.PublicKey != "galleries/7/ab_public.jpg" || last.VariantWidths != "320" || last.ProcessedAt == nil {
+		t.Errorf("Unexpected processed image %q %q %v", last.PublicKey, last.VariantWidths, last.ProcessedAt)
+	}
+}
+
+func TestDescribeImageRewinds(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, goimage.NewGray(goimage.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Uploads are described straight after being spooled to disk,
+	// with the file's offset at its end
+	r := bytes.NewReader(buf.Bytes())
+	r.Seek(0, io.SeekEnd)
+	var image Image
+	if err := describeImage(r, &image); err != nil {
+		t.Fatal(err)
+	}
+	if image.ContentType != "image/jpeg" || image.Width != 40 || image.Height != 30 || image.Size != int64(buf.Len()) {
+		t.Errorf("Unexpected description %s %dx%d %d bytes", image.ContentType, image.Width, image.Height, image.Size)
+	}
+}
+
+// dupUploadDB has a unique index on keys like the images table, and
+// holds both uploads back until each has stored its blob
+type dupUploadDB struct {
+	ImageDB
+	mu      sync.Mutex
+	stored  sync.WaitGroup
+	byKey   map[string]Image
+	inserts int
+}
+
+func (db *dupUploadDB) ByKey(key string) (*Image, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	image, ok := db.byKey[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &image, nil
+}
+
+func (db *dupUploadDB) ByGalleryID(galleryID uint) ([]Image, error) {
+	db.stored.Done()
+	db.stored.Wait()
+	return nil, nil
+}
+
+func (db *dupUploadDB) Create(image *Image) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.byKey[image.Key]; ok {
+		return errors.New(`pq: duplicate key value violates unique constraint "uix_images_key"`)
+	}
+	db.inserts++
+	image.ID = uint(db.inserts)
+	db.byKey[image.Key] = *image
+	return nil
+}
+
+func (db *dupUploadDB) MetadataPolicy(galleryID uint) (string, error) {
+	return "keep", nil
+}
+
+func (db *dupUploadDB) UpdateProcessed(image *Image) error {
+	return nil
+}
+
+func (db *dupUploadDB) UpdateMetadata(image *Image) error {
+	return nil
+}
+
+func TestCreateConcurrentDuplicates(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, goimage.NewGray(goimage.Rect(0, 0, 400, 300)), nil); err != nil {
+		t.Fatal(err)
+	}
+	db := &dupUploadDB{byKey: make(map[string]Image)}
+	db.stored.Add(2)
+	store := storage.NewMemoryStore()
+	is := &imageService{ImageDB: db, store: store, jpeg: imaging.NewJPEG(82)}
+
+	var wg sync.WaitGroup
+	images := make([]*Image, 2)
+	errs := make([]error, 2)
+	for i := range images {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			images[i], errs[i] = is.Create(7, bytes.NewReader(buf.Bytes()), "a.jpg")
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("upload %d: %v", i, err)
+		}
+	}
+	if db.inserts != 1 || images[0].ID != images[1].ID {
+		t.Errorf("Expected both uploads to get the one image, received %d inserts and ids %d %d",
+			db.inserts, images[0].ID, images[1].ID)
+	}
+	blob, _, err := store.Get(images[0].Key)
+	if err != nil {
+		t.Fatalf("Expected the blob to be kept for the image, received %v", err)
+	}
+	blob.Close()
+}
+
+func TestProcessNewImageReadsMetadata(t *testing.T) {
+	store := storage.NewMemoryStore()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, goimage.NewGray(goimage.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// A sidecar uploaded with the image got to it before the job did
+	image := &Image{Model: gorm.Model{ID: 3}, GalleryID: 7, Key: "galleries/7/ab.jpg", ContentType: "image/jpeg", Title: "From the sidecar"}
+	if err := store.Put(image.Key, &buf, image.ContentType); err != nil {
+		t.Fatal(err)
+	}
+	db := &processDB{t: t}
+	is := &imageService{ImageDB: db, store: store, jpeg: imaging.NewJPEG(82)}
+	if err := is.process(image, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.metadata) != 0 {
+		t.Errorf("Expected images being reprocessed to keep their metadata")
+	}
+	if err := is.process(image, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.metadata) != 1 || db.metadata[0].Title != "From the sidecar" {
+		t.Errorf("Expected the metadata to be saved on its own, keeping the sidecar's title, received %v", db.metadata)
+	}
+}
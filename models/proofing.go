@@ -0,0 +1,275 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxProofNoteLength is how long a client's note on an image can be
+const MaxProofNoteLength = 2000
+
+// ProofSelection is what a client said about one image of a gallery
+// in proofing mode, through one share link. Each link is one client's
+// set of selects.
+type ProofSelection struct {
+	ShareLinkID uint   `gorm:"primary_key;auto_increment:false"`
+	ImageID     uint   `gorm:"primary_key;auto_increment:false"`
+	Selected    bool   `gorm:"not null;default:false"`
+	Note        string `gorm:"not null;default:''"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ProofDB is used to interact with the proof_selections table
+type ProofDB interface {
+	// ByShareLink returns everything said through the link
+	ByShareLink(linkID uint) ([]ProofSelection, error)
+	// CountSelected counts the images selected through the link,
+	// leaving out exceptImageID
+	CountSelected(linkID, exceptImageID uint) (int, error)
+	// Save creates or updates the selection
+	Save(selection *ProofSelection) error
+	// SaveWithinLimit saves the selection unless limit images are
+	// already selected through its link, not counting its own image,
+	// and returns ErrProofSelectLimit then. The link is locked while
+	// counting and saving, so clients selecting at the same time
+	// can't go over the limit between them.
+	SaveWithinLimit(selection *ProofSelection, limit int) error
+	// MarkSubmitted marks the link's selection as done.
+	// ErrProofSubmitted is returned when it already was.
+	MarkSubmitted(link *ShareLink) error
+	// Reopen lets the client change their selection again
+	Reopen(link *ShareLink) error
+}
+
+// ProofService lets clients pick the images they want from galleries
+// in proofing mode, up to the gallery's SelectLimit, and leave notes
+// on them before submitting their selection. Clients have no account,
+// they come in with a share link.
+type ProofService interface {
+	ProofDB
+	// Select marks the image as selected, or not, for the link. It
+	// returns ErrProofSelectLimit when the gallery's limit has been
+	// reached.
+	Select(gallery *Gallery, link *ShareLink, imageID uint, selected bool) error
+	// SetNote leaves a note on the image for the photographer
+	SetNote(gallery *Gallery, link *ShareLink, imageID uint, note string) error
+	// Submit sends the selection to the photographer. It can't be
+	// changed afterwards unless they reopen it.
+	Submit(gallery *Gallery, link *ShareLink) error
+}
+
+func NewProofService(db *gorm.DB) ProofService {
+	return &proofService{
+		ProofDB: &proofValidator{&proofGorm{db}},
+	}
+}
+
+type proofService struct {
+	ProofDB
+}
+
+func (ps *proofService) Select(gallery *Gallery, link *ShareLink, imageID uint, selected bool) error {
+	if err := proofOpen(gallery, link); err != nil {
+		return err
+	}
+	selection, err := ps.selection(link.ID, imageID)
+	if err != nil {
+		return err
+	}
+	selection.Selected = selected
+	if selected && gallery.SelectLimit > 0 {
+		return ps.SaveWithinLimit(selection, gallery.SelectLimit)
+	}
+	return ps.Save(selection)
+}
+
+func (ps *proofService) SetNote(gallery *Gallery, link *ShareLink, imageID uint, note string) error {
+	if err := proofOpen(gallery, link); err != nil {
+		return err
+	}
+	selection, err := ps.selection(link.ID, imageID)
+	if err != nil {
+		return err
+	}
+	selection.Note = note
+	return ps.Save(selection)
+}
+
+func (ps *proofService) Submit(gallery *Gallery, link *ShareLink) error {
+	if err := proofOpen(gallery, link); err != nil {
+		return err
+	}
+	n, err := ps.CountSelected(link.ID, 0)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrProofNothingSelected
+	}
+	return ps.MarkSubmitted(link)
+}
+
+// selection finds what has been said about the image so far, or
+// starts a new selection
+func (ps *proofService) selection(linkID, imageID uint) (*ProofSelection, error) {
+	selections, err := ps.ByShareLink(linkID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range selections {
+		if selections[i].ImageID == imageID {
+			return &selections[i], nil
+		}
+	}
+	return &ProofSelection{ShareLinkID: linkID, ImageID: imageID}, nil
+}
+
+// proofOpen makes sure the link can still change its selection
+func proofOpen(gallery *Gallery, link *ShareLink) error {
+	switch {
+	case !gallery.Proofing || link.GalleryID != gallery.ID:
+		return ErrProofingOff
+	case link.SubmittedAt != nil:
+		return ErrProofSubmitted
+	}
+	return nil
+}
+
+type proofValidator struct {
+	ProofDB
+}
+
+func (pv *proofValidator) Save(selection *ProofSelection) error {
+	err := runProofValidationFuncs(selection,
+		pv.idsRequired,
+		pv.noteMaxLength)
+	if err != nil {
+		return err
+	}
+	return pv.ProofDB.Save(selection)
+}
+
+func (pv *proofValidator) SaveWithinLimit(selection *ProofSelection, limit int) error {
+	err := runProofValidationFuncs(selection,
+		pv.idsRequired,
+		pv.noteMaxLength)
+	if err != nil {
+		return err
+	}
+	return pv.ProofDB.SaveWithinLimit(selection, limit)
+}
+
+func (pv *proofValidator) idsRequired(selection *ProofSelection) error {
+	if selection.ShareLinkID <= 0 || selection.ImageID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+func (pv *proofValidator) noteMaxLength(selection *ProofSelection) error {
+	if len([]rune(selection.Note)) > MaxProofNoteLength {
+		return ErrProofNoteTooLong
+	}
+	return nil
+}
+
+var _ ProofDB = &proofGorm{}
+
+type proofGorm struct {
+	db *gorm.DB
+}
+
+func (pg *proofGorm) ByShareLink(linkID uint) ([]ProofSelection, error) {
+	var selections []ProofSelection
+	err := pg.db.Where("share_link_id = ?", linkID).
+		Order("image_id").Find(&selections).Error
+	return selections, err
+}
+
+func (pg *proofGorm) CountSelected(linkID, exceptImageID uint) (int, error) {
+	return countSelected(pg.db, linkID, exceptImageID)
+}
+
+func (pg *proofGorm) Save(selection *ProofSelection) error {
+	return pg.db.Save(selection).Error
+}
+
+// SaveWithinLimit locks the link's share_links row for the length of
+// a transaction, so selections through the same link are counted and
+// saved one at a time
+func (pg *proofGorm) SaveWithinLimit(selection *ProofSelection, limit int) error {
+	tx := pg.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var link ShareLink
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("id = ?", selection.ShareLinkID).First(&link).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return ErrNotFound
+		}
+		return err
+	}
+	n, err := countSelected(tx, selection.ShareLinkID, selection.ImageID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n >= limit {
+		tx.Rollback()
+		return ErrProofSelectLimit
+	}
+	if err := tx.Save(selection).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// countSelected counts with db, which may be a transaction. Images
+// that were deleted or moved out of the gallery don't count.
+func countSelected(db *gorm.DB, linkID, exceptImageID uint) (int, error) {
+	var count int
+	err := db.Table("proof_selections").
+		Joins("JOIN share_links ON share_links.id = proof_selections.share_link_id").
+		Joins("JOIN images ON images.id = proof_selections.image_id AND images.gallery_id = share_links.gallery_id AND images.deleted_at IS NULL").
+		Where("proof_selections.share_link_id = ? AND proof_selections.image_id <> ? AND proof_selections.selected", linkID, exceptImageID).
+		Count(&count).Error
+	return count, err
+}
+
+func (pg *proofGorm) MarkSubmitted(link *ShareLink) error {
+	now := time.Now()
+	db := pg.db.Model(&ShareLink{}).
+		Where("id = ? AND submitted_at IS NULL", link.ID).
+		Update("submitted_at", now)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrProofSubmitted
+	}
+	link.SubmittedAt = &now
+	return nil
+}
+
+func (pg *proofGorm) Reopen(link *ShareLink) error {
+	link.SubmittedAt = nil
+	return pg.db.Model(&ShareLink{}).Where("id = ?", link.ID).
+		Update("submitted_at", gorm.Expr("NULL")).Error
+}
+
+type proofValidatorFunc func(*ProofSelection) error
+
+func runProofValidationFuncs(selection *ProofSelection, fns ...proofValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(selection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestProofOpen(t *testing.T) {
+	gallery := &Gallery{Model: gorm.Model{ID: 7}}
+	link := &ShareLink{GalleryID: 7}
+	if err := proofOpen(gallery, link); err != ErrProofingOff {
+		t.Errorf("Expected ErrProofingOff, received %v", err)
+	}
+	gallery.Proofing = true
+	if err := proofOpen(gallery, link); err != nil {
+		t.Errorf("Expected selections to be open, received %v", err)
+	}
+	if err := proofOpen(gallery, &ShareLink{GalleryID: 8}); err != ErrProofingOff {
+		t.Errorf("Expected another gallery's link to be refused, received %v", err)
+	}
+	now := time.Now()
+	link.SubmittedAt = &now
+	if err := proofOpen(gallery, link); err != ErrProofSubmitted {
+		t.Errorf("Expected ErrProofSubmitted, received %v", err)
+	}
+}
+
+func TestProofValidator(t *testing.T) {
+	pv := &proofValidator{}
+	selection := &ProofSelection{}
+	if err := pv.idsRequired(selection); err != ErrIDInvalid {
+		t.Errorf("Expected ErrIDInvalid, received %v", err)
+	}
+	selection.Note = strings.Repeat("é", MaxProofNoteLength)
+	if err := pv.noteMaxLength(selection); err != nil {
+		t.Errorf("Expected a note of %d characters to be allowed, received %v", MaxProofNoteLength, err)
+	}
+	selection.Note += "!"
+	if err := pv.noteMaxLength(selection); err != ErrProofNoteTooLong {
+		t.Errorf("Expected ErrProofNoteTooLong, received %v", err)
+	}
+
+	gv := &galleryValidator{}
+	if err := gv.selectLimitValid(&Gallery{SelectLimit: -1}); err != ErrSelectLimitInvalid {
+		t.Errorf("Expected ErrSelectLimitInvalid, received %v", err)
+	}
+}
+
+// lockedProofDB keeps selections in memory, counting and saving under
+// one lock the way SaveWithinLimit locks the link's row
+type lockedProofDB struct {
+	ProofDB
+	mu        sync.Mutex
+	selected  map[uint]bool
+	unlimited int
+}
+
+func (db *lockedProofDB) ByShareLink(linkID uint) ([]ProofSelection, error) {
+	return nil, nil
+}
+
+func (db *lockedProofDB) Save(selection *ProofSelection) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.unlimited++
+	db.selected[selection.ImageID] = selection.Selected
+	return nil
+}
+
+func (db *lockedProofDB) SaveWithinLimit(selection *ProofSelection, limit int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for id, ok := range db.selected {
+		if ok && id != selection.ImageID {
+			n++
+		}
+	}
+	if n >= limit {
+		return ErrProofSelectLimit
+	}
+	db.selected[selection.ImageID] = selection.Selected
+	return nil
+}
+
+func TestProofSelectLimit(t *testing.T) {
+	db := &lockedProofDB{selected: make(map[uint]bool)}
+	ps := &proofService{ProofDB: db}
+	gallery := &Gallery{Model: gorm.Model{ID: 7}, Proofing: true, SelectLimit: 3}
+	link := &ShareLink{Model: gorm.Model{ID: 2}, GalleryID: 7}
+
+	var wg sync.WaitGroup
+	for i := uint(1); i <= 20; i++ {
+		wg.Add(1)
+		go func(imageID uint) {
+			defer wg.Done()
+			if err := ps.Select(gallery, link, imageID, true); err != nil && err != ErrProofSelectLimit {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if db.unlimited != 0 || len(db.selected) != 3 {
+		t.Errorf("Expected 3 images to be selected within the limit, received %d with %d unchecked saves", len(db.selected), db.unlimited)
+	}
+
+	if err := ps.Select(gallery, link, 30, false); err != nil || db.unlimited != 1 {
+		t.Errorf("Expected unselecting to skip the limit, received %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"time"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+// pwResetTTL is how long a password reset token can be used for
+const pwResetTTL = time.Hour
+
+// pwReset is a single use token emailed to a user who has forgotten
+// their password. Only the HMAC of the token is stored.
+type pwReset struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+}
+
+type pwResetDB interface {
+	// Consume hashes the token and deletes the reset it belongs to,
+	// returning it if it had not expired yet. Only one of several
+	// requests with the same token gets the reset back.
+	Consume(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	// DeleteByUserID removes every reset for the user, so tokens
+	// emailed before the password changed can't be used
+	DeleteByUserID(userID uint) error
+}
+
+func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmac:      hmac,
+	}
+}
+
+type pwResetValidator struct {
+	pwResetDB
+	hmac hash.HMAC
+}
+
+func (pwrv *pwResetValidator) Consume(token string) (*pwReset, error) {
+	pwr := pwReset{Token: token}
+	err := runPwResetValidationFuncs(&pwr, pwrv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	return pwrv.pwResetDB.Consume(pwr.TokenHash)
+}
+
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValidationFuncs(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+func (pwrv *pwResetValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return pwrv.pwResetDB.DeleteByUserID(userID)
+}
+
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	pwr.Token = token
+	return nil
+}
+
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return nil
+	}
+	pwr.TokenHash = pwrv.hmac.Hash(pwr.Token)
+	return nil
+}
+
+type pwResetValidatorFunc func(*pwReset) error
+
+func runPwResetValidationFuncs(pwr *pwReset, fns ...pwResetValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ pwResetDB = &pwResetGorm{}
+
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+// Consume expects the token to already be hashed. The reset is
+// deleted and returned in one statement, so two requests racing with
+// the same token can't both use it.
+func (pwrg *pwResetGorm) Consume(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	err := pwrg.db.Raw("DELETE FROM pw_resets WHERE token_hash = ? AND created_at > ? RETURNING *",
+		tokenHash, time.Now().Add(-pwResetTTL)).Scan(&pwr).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pwr, nil
+}
+
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+// DeleteByUserID removes the resets for good so their tokens can't
+// be reused
+func (pwrg *pwResetGorm) DeleteByUserID(userID uint) error {
+	return pwrg.db.Unscoped().Where("user_id = ?", userID).Delete(&pwReset{}).Error
+}
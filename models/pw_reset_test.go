@@ -0,0 +1,177 @@
+package models
+
+import (
+	"sync"
+	"testing"
+
+	"lenslocked.com/hash"
+
+	"github.com/jinzhu/gorm"
+)
+
+// memPwResets keeps resets by token hash, the way the pw_resets
+// table does
+type memPwResets struct {
+	mu     sync.Mutex
+	nextID uint
+	byHash map[string]pwReset
+}
+
+func (db *memPwResets) Consume(tokenHash string) (*pwReset, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	pwr, ok := db.byHash[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(db.byHash, tokenHash)
+	return &pwr, nil
+}
+
+func (db *memPwResets) Create(pwr *pwReset) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.nextID++
+	pwr.ID = db.nextID
+	db.byHash[pwr.TokenHash] = *pwr
+	return nil
+}
+
+func (db *memPwResets) DeleteByUserID(userID uint) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for h, pwr := range db.byHash {
+		if pwr.UserID == userID {
+			delete(db.byHash, h)
+		}
+	}
+	return nil
+}
+
+type resetUserDB struct {
+	UserDB
+	mu    sync.Mutex
+	user  User
+	saves int
+}
+
+func (db *resetUserDB) ByEmail(email string) (*User, error) {
+	if email != db.user.Email {
+		return nil, ErrNotFound
+	}
+	user := db.user
+	return &user, nil
+}
+
+func (db *resetUserDB) ByID(id uint) (*User, error) {
+	if id != db.user.ID {
+		return nil, ErrNotFound
+	}
+	user := db.user
+	return &user, nil
+}
+
+func (db *resetUserDB) Update(user *User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.saves++
+	return nil
+}
+
+type resetSessionDB struct {
+	SessionDB
+	deleted []uint
+}
+
+func (db *resetSessionDB) DeleteByUserID(userID uint) error {
+	db.deleted = append(db.deleted, userID)
+	return nil
+}
+
+func testingResetService() (*userService, *memPwResets, *resetUserDB, *resetSessionDB) {
+	resets := &memPwResets{byHash: make(map[string]pwReset)}
+	users := &resetUserDB{user: User{Model: gorm.Model{ID: 4}, Email: "pam@dundermifflin.com"}}
+	sessions := &resetSessionDB{}
+	us := &userService{
+		UserDB:    users,
+		pwResetDB: newPwResetValidator(resets, hash.NewHMAC("test-key")),
+		sessions:  sessions,
+	}
+	return us, resets, users, sessions
+}
+
+func TestCompleteReset(t *testing.T) {
+	us, resets, users, sessions := testingResetService()
+	token, err := us.InitiateReset("pam@dundermifflin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resets.byHash[token]; ok {
+		t.Error("Expected only the HMAC of the token to be stored")
+	}
+	older, err := us.InitiateReset("pam@dundermifflin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := us.CompleteReset(token, ""); err != ErrPasswordRequired {
+		t.Errorf("Expected ErrPasswordRequired, received %v", err)
+	}
+	if _, err := us.CompleteReset(token, "short"); err != ErrPasswordTooShort {
+		t.Errorf("Expected ErrPasswordTooShort, received %v", err)
+	}
+	if len(resets.byHash) != 2 {
+		t.Errorf("Expected an invalid password not to use up the token, %d resets left", len(resets.byHash))
+	}
+	user, err := us.CompleteReset(token, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "new password" || user.Remember == "" {
+		t.Errorf("Expected the password and remember token to be set, received %q %q", user.Password, user.Remember)
+	}
+	if len(sessions.deleted) != 1 || sessions.deleted[0] != users.user.ID {
+		t.Errorf("Expected the user's sessions to be deleted, received %v", sessions.deleted)
+	}
+	if len(resets.byHash) != 0 {
+		t.Errorf("Expected every reset for the user to be deleted, %d left", len(resets.byHash))
+	}
+	for _, tok := range []string{token, older, "not-a-token"} {
+		if _, err := us.CompleteReset(tok, "another password"); err != ErrTokenInvalid {
+			t.Errorf("Expected ErrTokenInvalid, received %v", err)
+		}
+	}
+}
+
+func TestCompleteResetConcurrent(t *testing.T) {
+	us, _, users, _ := testingResetService()
+	token, err := us.InitiateReset("pam@dundermifflin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := us.CompleteReset(token, "new password")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	ok := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			ok++
+		case ErrTokenInvalid:
+		default:
+			t.Errorf("Unexpected error %v", err)
+		}
+	}
+	if ok != 1 || users.saves != 1 {
+		t.Errorf("Expected the token to be used once, used %d times and saved %d times", ok, users.saves)
+	}
+}
@@ -1,28 +1,129 @@
 package models
 
 import (
+	"lenslocked.com/imaging"
+	"lenslocked.com/jobs"
+	"lenslocked.com/migrations"
+	"lenslocked.com/storage"
+
 	"github.com/jinzhu/gorm"
 )
 
-func NewServices(connectionInfo string) (*Services, error) {
-	db, err := gorm.Open("postgres", connectionInfo)
-	if err != nil {
-		return nil, err
+// ServicesConfig is a functional option used to set up a Services
+type ServicesConfig func(*Services) error
+
+// WithGorm opens the gorm database connection used by the other services.
+// It must be provided before any option that needs the db.
+func WithGorm(dialect, connectionInfo string) ServicesConfig {
+	return func(s *Services) error {
+		db, err := gorm.Open(dialect, connectionInfo)
+		if err != nil {
+			return err
+		}
+		s.db = db
+		return nil
+	}
+}
+
+// WithLogMode turns gorm's SQL logging on or off
+func WithLogMode(mode bool) ServicesConfig {
+	return func(s *Services) error {
+		s.db.LogMode(mode)
+		return nil
+	}
+}
+
+// WithUser sets up the UserService using the given password pepper
+// and HMAC key for remember tokens
+func WithUser(pepper, hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.User = NewUserService(s.db, pepper, hmacKey)
+		return nil
+	}
+}
+
+// WithSession sets up the SessionService, hashing tokens with hmacKey
+func WithSession(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Session = NewSessionService(s.db, hmacKey)
+		return nil
+	}
+}
+
+// WithGallery sets up the GalleryService using the same pepper as
+// user passwords and an HMAC key for unlock tokens
+func WithGallery(pepper, hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Gallery = NewGalleryService(s.db, pepper, hmacKey)
+		return nil
+	}
+}
+
+// WithShareLink sets up the ShareLinkService, hashing tokens with
+// hmacKey. It must be the same key as WithGallery's, which looks the
+// tokens up.
+func WithShareLink(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.ShareLink = NewShareLinkService(s.db, hmacKey)
+		return nil
+	}
+}
+
+// WithProof sets up the ProofService
+func WithProof() ServicesConfig {
+	return func(s *Services) error {
+		s.Proof = NewProofService(s.db)
+		return nil
+	}
+}
+
+// WithJobs sets up the background job queue. It must come before
+// WithImage for uploads to be processed in the background.
+func WithJobs(cfg jobs.Config) ServicesConfig {
+	return func(s *Services) error {
+		s.Jobs = jobs.NewQueue(s.db.DB(), cfg)
+		return nil
 	}
-	db.LogMode(true)
-	return &Services{
-		User:    NewUserService(db),
-		Gallery: NewGalleryService(db),
-		Image:   NewImageService(),
-		db:      db,
-	}, nil
+}
+
+// WithImage sets up the ImageService, keeping image files in store.
+// webp encodes WebP variants and may be nil to only make JPEGs.
+func WithImage(store storage.BlobStore, webp imaging.Encoder) ServicesConfig {
+	return func(s *Services) error {
+		s.Image = NewImageService(s.db, store, webp, s.Jobs)
+		return nil
+	}
+}
+
+// WithImageURLs sets up signing of image URLs with hmacKey
+func WithImageURLs(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.ImageURLs = NewImageURLSigner(hmacKey)
+		return nil
+	}
+}
+
+// NewServices applies each of the provided options in order
+func NewServices(cfgs ...ServicesConfig) (*Services, error) {
+	var s Services
+	for _, cfg := range cfgs {
+		if err := cfg(&s); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
 }
 
 type Services struct {
-	Gallery GalleryService
-	User    UserService 
-	Image   ImageService
-	db      *gorm.DB
+	Gallery   GalleryService
+	ShareLink ShareLinkService
+	Proof     ProofService
+	User      UserService
+	Session   SessionService
+	Image     ImageService
+	ImageURLs *ImageURLSigner
+	Jobs      *jobs.Queue
+	db        *gorm.DB
 }
 
 // Close closes the database connection
@@ -30,16 +131,27 @@ func (s *Services) Close() error {
 	return s.db.Close()
 }
 
-// DestructiveReset drops the all tables and rebuilds them
+// Migrator returns a migrator for the embedded schema migrations
+func (s *Services) Migrator() (*migrations.Migrator, error) {
+	return migrations.NewMigrator(s.db.DB())
+}
+
+// DestructiveReset rolls back every migration and then applies
+// them all again, leaving an empty database
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}).Error
+	m, err := s.Migrator()
 	if err != nil {
 		return err
 	}
-	return s.AutoMigrate()
+	return m.Reset()
 }
 
-// AutoMigrate will attempt to automatically migrate all tables
-func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}).Error
+// MigrateUp applies any pending schema migrations
+func (s *Services) MigrateUp() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	_, err = m.Up()
+	return err
 }
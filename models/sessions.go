@@ -0,0 +1,375 @@
+package models
+
+import (
+	"time"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// SessionIdleTimeout is how long a session lives without being used.
+	// Every request made with the session pushes its expiry back.
+	SessionIdleTimeout = 14 * 24 * time.Hour
+	// SessionRotateAfter is how often a session is given a new token
+	SessionRotateAfter = 24 * time.Hour
+	// SessionRotateGrace is how long the token a session had before
+	// it was rotated keeps working, for requests that were already on
+	// their way with it, such as image loads and other tabs
+	SessionRotateGrace = time.Minute
+
+	// sessionTouchInterval stops us from writing to the db on every
+	// single request just to bump the last seen time
+	sessionTouchInterval = time.Minute
+)
+
+// Session is a single signed in device. The raw token only ever lives
+// in the user's cookie, we store an HMAC of it just like remember tokens.
+type Session struct {
+	gorm.Model
+	UserID     uint      `gorm:"not null;index"`
+	Token      string    `gorm:"-"`
+	TokenHash  string    `gorm:"not null;unique_index"`
+	UserAgent  string    `gorm:"not null"`
+	IP         string    `gorm:"not null"`
+	LastSeenAt time.Time `gorm:"not null"`
+	RotatedAt  time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	// PrevTokenHash is the token the session had before it was last
+	// rotated, which keeps working until PrevExpiresAt
+	PrevTokenHash string `gorm:"not null;default:'';index"`
+	PrevExpiresAt *time.Time
+}
+
+// SessionDB is used to interact with the sessions table
+type SessionDB interface {
+	// ByToken hashes the raw token and looks up the unexpired
+	// session it belongs to, either as its token or as its previous
+	// one within SessionRotateGrace
+	ByToken(token string) (*Session, error)
+	ByID(id uint) (*Session, error)
+	ByUserID(userID uint) ([]Session, error)
+
+	Create(session *Session) error
+	Update(session *Session) error
+	// UpdateSeen only saves IP, LastSeenAt and ExpiresAt, so it can't
+	// undo a rotation made by another request
+	UpdateSeen(session *Session) error
+	// Rotate saves the session's new token, keeping oldHash as its
+	// previous one. It only does so while oldHash is still the
+	// session's token, and returns false when another request
+	// rotated it first.
+	Rotate(session *Session, oldHash string) (bool, error)
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+	DeleteExpired() error
+}
+
+// SessionService is used to sign devices in and keep their
+// sessions alive
+type SessionService interface {
+	// Start creates a session with a new token for the user
+	Start(userID uint, userAgent, ip string) (*Session, error)
+	// Touch slides the session's expiry forward and, once the token is
+	// older than SessionRotateAfter, replaces it. When the token changes
+	// rotated is true and session.Token holds the new raw token. When
+	// another request rotated it first rotated is false, and the token
+	// the request came with keeps working for SessionRotateGrace.
+	Touch(session *Session, ip string) (rotated bool, err error)
+	SessionDB
+}
+
+// NewSessionService hashes session tokens with hmacKey
+func NewSessionService(db *gorm.DB, hmacKey string) SessionService {
+	return &sessionService{
+		SessionDB: &sessionValidator{
+			SessionDB: &sessionGorm{db},
+			hmac:      hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+}
+
+func (ss *sessionService) Start(userID uint, userAgent, ip string) (*Session, error) {
+	session := Session{
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+	}
+	if err := ss.Create(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (ss *sessionService) Touch(session *Session, ip string) (bool, error) {
+	now := time.Now()
+	rotate := now.Sub(session.RotatedAt) > SessionRotateAfter
+	if !rotate && now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return false, nil
+	}
+	session.IP = ip
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(SessionIdleTimeout)
+	if !rotate {
+		return false, ss.UpdateSeen(session)
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return false, err
+	}
+	old := *session
+	grace := now.Add(SessionRotateGrace)
+	session.Token = token
+	session.RotatedAt = now
+	session.PrevTokenHash = old.TokenHash
+	session.PrevExpiresAt = &grace
+	rotated, err := ss.Rotate(session, old.TokenHash)
+	if err != nil || !rotated {
+		*session = old
+		return false, err
+	}
+	return true, nil
+}
+
+type sessionValidatorFunc func(*Session) error
+
+func runSessionValidationFuncs(session *Session, fns ...sessionValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionValidator{}
+
+// sessionValidator hashes tokens and fills in timestamps before
+// anything reaches the db
+type sessionValidator struct {
+	SessionDB
+	hmac hash.HMAC
+}
+
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	session := Session{Token: token}
+	if err := runSessionValidationFuncs(&session, sv.hmacToken); err != nil {
+		return nil, err
+	}
+	return sv.SessionDB.ByToken(session.TokenHash)
+}
+
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValidationFuncs(session,
+		sv.userIDRequired,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired,
+		sv.setTimesIfUnset)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) Update(session *Session) error {
+	err := runSessionValidationFuncs(session,
+		sv.userIDRequired,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Update(session)
+}
+
+func (sv *sessionValidator) UpdateSeen(session *Session) error {
+	if session.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return sv.SessionDB.UpdateSeen(session)
+}
+
+func (sv *sessionValidator) Rotate(session *Session, oldHash string) (bool, error) {
+	err := runSessionValidationFuncs(session,
+		sv.userIDRequired,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired)
+	if err != nil {
+		return false, err
+	}
+	return sv.SessionDB.Rotate(session, oldHash)
+}
+
+func (sv *sessionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return sv.SessionDB.Delete(id)
+}
+
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+func (sv *sessionValidator) userIDRequired(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) tokenMinBytes(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	n, err := rand.NBytes(session.Token)
+	if err != nil {
+		return err
+	}
+	if n < rand.RememberTokenBytes {
+		return ErrRememberTooShort
+	}
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+func (sv *sessionValidator) tokenHashRequired(session *Session) error {
+	if session.TokenHash == "" {
+		return ErrRememberRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTimesIfUnset(session *Session) error {
+	now := time.Now()
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = now
+	}
+	if session.RotatedAt.IsZero() {
+		session.RotatedAt = now
+	}
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = now.Add(SessionIdleTimeout)
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+// ByToken expects the token to already be hashed
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	var session Session
+	now := time.Now()
+	db := sg.db.Where("(token_hash = ? OR (prev_token_hash = ? AND prev_expires_at > ?)) AND expires_at > ?",
+		tokenHash, tokenHash, now, now)
+	err := first(db, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByID(id uint) (*Session, error) {
+	var session Session
+	db := sg.db.Where("id = ?", id)
+	err := first(db, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ByUserID returns the user's unexpired sessions, most recently used first
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+func (sg *sessionGorm) UpdateSeen(session *Session) error {
+	return sg.db.Model(&Session{Model: gorm.Model{ID: session.ID}}).Updates(map[string]interface{}{
+		"ip":           session.IP,
+		"last_seen_at": session.LastSeenAt,
+		"expires_at":   session.ExpiresAt,
+	}).Error
+}
+
+// Rotate only updates the row while it still has oldHash, so when two
+// requests rotate the session at once only the first one wins
+func (sg *sessionGorm) Rotate(session *Session, oldHash string) (bool, error) {
+	db := sg.db.Model(&Session{}).
+		Where("id = ? AND token_hash = ?", session.ID, oldHash).
+		Updates(map[string]interface{}{
+			"token_hash":      session.TokenHash,
+			"prev_token_hash": session.PrevTokenHash,
+			"prev_expires_at": session.PrevExpiresAt,
+			"rotated_at":      session.RotatedAt,
+			"ip":              session.IP,
+			"last_seen_at":    session.LastSeenAt,
+			"expires_at":      session.ExpiresAt,
+		})
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected > 0, nil
+}
+
+func (sg *sessionGorm) Delete(id uint) error {
+	return sg.db.Unscoped().Delete(&Session{Model: gorm.Model{ID: id}}).Error
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Unscoped().Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+func (sg *sessionGorm) DeleteExpired() error {
+	return sg.db.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&Session{}).Error
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+// touchSessionDB keeps one session's token hash the way the sessions
+// table does, rotating it only from the hash it has
+type touchSessionDB struct {
+	SessionDB
+	tokenHash string
+	seen      []Session
+	rotated   []Session
+}
+
+func (db *touchSessionDB) UpdateSeen(session *Session) error {
+	db.seen = append(db.seen, *session)
+	return nil
+}
+
+func (db *touchSessionDB) Rotate(session *Session, oldHash string) (bool, error) {
+	if oldHash != db.tokenHash {
+		return false, nil
+	}
+	db.tokenHash = session.TokenHash
+	db.rotated = append(db.rotated, *session)
+	return true, nil
+}
+
+func TestSessionTouch(t *testing.T) {
+	hmac := hash.NewHMAC("test-key")
+	token, err := rand.RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &touchSessionDB{tokenHash: hmac.Hash(token)}
+	ss := &sessionService{SessionDB: &sessionValidator{SessionDB: db, hmac: hmac}}
+	now := time.Now()
+	session := &Session{
+		UserID:     3,
+		Token:      token,
+		TokenHash:  db.tokenHash,
+		LastSeenAt: now,
+		RotatedAt:  now,
+		ExpiresAt:  now.Add(SessionIdleTimeout),
+	}
+	session.ID = 8
+
+	rotated, err := ss.Touch(session, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated || len(db.seen) != 0 {
+		t.Errorf("Expected a session just seen to be left alone, rotated %v with %d updates", rotated, len(db.seen))
+	}
+
+	session.LastSeenAt = now.Add(-2 * sessionTouchInterval)
+	rotated, err = ss.Touch(session, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated || session.Token != token || len(db.seen) != 1 || len(db.rotated) != 0 {
+		t.Fatalf("Expected the session to be touched but keep its token, rotated %v with %d updates", rotated, len(db.seen))
+	}
+	if db.seen[0].IP != "10.0.0.1" || time.Until(session.ExpiresAt) < SessionIdleTimeout-time.Minute {
+		t.Errorf("Expected the ip and expiry to be updated, received %s %s", db.seen[0].IP, session.ExpiresAt)
+	}
+
+	// Another request with the same cookie loaded the session before
+	// this one rotated it
+	stale := *session
+	session.RotatedAt = now.Add(-SessionRotateAfter - time.Minute)
+	stale.RotatedAt = session.RotatedAt
+	oldHash := session.TokenHash
+	rotated, err = ss.Touch(session, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated || session.Token == token {
+		t.Fatalf("Expected the session to get a new token, rotated %v", rotated)
+	}
+	if len(db.rotated) != 1 || db.tokenHash != hmac.Hash(session.Token) {
+		t.Errorf("Expected the new token's hash to be saved")
+	}
+	saved := db.rotated[0]
+	if saved.PrevTokenHash != oldHash || saved.PrevExpiresAt == nil || time.Until(*saved.PrevExpiresAt) > SessionRotateGrace {
+		t.Errorf("Expected the old token to keep working for a short while, received %q %v", saved.PrevTokenHash, saved.PrevExpiresAt)
+	}
+
+	rotated, err = ss.Touch(&stale, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated || stale.Token != token || len(db.rotated) != 1 {
+		t.Errorf("Expected the request that lost the race to keep its token, rotated %v", rotated)
+	}
+}
@@ -0,0 +1,247 @@
+package models
+
+import (
+	"time"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ShareLinkTokenBytes is how many random bytes make up a share link's
+// token
+const ShareLinkTokenBytes = 18
+
+// Share link statuses, see ShareLink.Status
+const (
+	ShareLinkActive  = "active"
+	ShareLinkExpired = "expired"
+	ShareLinkUsedUp  = "used up"
+	ShareLinkRevoked = "revoked"
+)
+
+// ShareLink is one of the links an owner hands out to their gallery,
+// /s/<token>. Unlike the gallery's share link each one can expire,
+// be limited to a number of views and allow downloads, and counts
+// its own views. It lets people in whatever the gallery's visibility.
+//
+// Only the HMAC of the token is stored, so the raw token is only
+// known when the link is made or the gallery is opened with it.
+type ShareLink struct {
+	gorm.Model
+	GalleryID uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	// ExpiresAt is nil for links that don't expire
+	ExpiresAt *time.Time
+	// MaxViews is how many times the gallery's page can be opened
+	// with the link, 0 for no limit
+	MaxViews       int  `gorm:"not null;default:0"`
+	AllowDownloads bool `gorm:"not null;default:false"`
+	Views          int  `gorm:"not null;default:0"`
+	LastViewedAt   *time.Time
+	RevokedAt      *time.Time
+	// SubmittedAt is when the client sent their selection, for
+	// galleries in proofing mode
+	SubmittedAt *time.Time
+}
+
+// Path is the link's URL. It is only known while Token is set.
+func (l ShareLink) Path() string {
+	return "/s/" + l.Token
+}
+
+// Expired reports whether the link's time is up at now
+func (l ShareLink) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// UsedUp reports whether the gallery has been opened with the link
+// as many times as it allows
+func (l ShareLink) UsedUp() bool {
+	return l.MaxViews > 0 && l.Views >= l.MaxViews
+}
+
+// Open reports whether the link still lets people in at now, that
+// is it hasn't been revoked, expired or used up. Every page opened
+// with the link checks it, not only the gallery's.
+func (l ShareLink) Open(now time.Time) bool {
+	return l.RevokedAt == nil && !l.Expired(now) && !l.UsedUp()
+}
+
+// Status is one of ShareLinkActive, ShareLinkExpired, ShareLinkUsedUp
+// or ShareLinkRevoked
+func (l ShareLink) Status() string {
+	switch {
+	case l.RevokedAt != nil:
+		return ShareLinkRevoked
+	case l.Expired(time.Now()):
+		return ShareLinkExpired
+	case l.UsedUp():
+		return ShareLinkUsedUp
+	}
+	return ShareLinkActive
+}
+
+// ShareLinkDB is used to interact with the share_links table
+type ShareLinkDB interface {
+	ByID(id uint) (*ShareLink, error)
+	// ByGalleryID returns all of the gallery's links, revoked ones
+	// too, newest first
+	ByGalleryID(galleryID uint) ([]ShareLink, error)
+	Create(link *ShareLink) error
+	// Revoke stops the link from working. It is kept so its views
+	// can still be seen.
+	Revoke(link *ShareLink) error
+	// RecordView counts the gallery being opened with the link. It
+	// returns ErrShareLinkUsedUp or ErrShareLinkExpired, without
+	// counting, when the link no longer works.
+	RecordView(link *ShareLink) error
+}
+
+// ShareLinkService manages the share links of galleries. Galleries
+// are opened with them through GalleryService.ByShareLink.
+type ShareLinkService interface {
+	ShareLinkDB
+}
+
+// NewShareLinkService hashes share link tokens with hmacKey
+func NewShareLinkService(db *gorm.DB, hmacKey string) ShareLinkService {
+	return &shareLinkService{
+		ShareLinkDB: &shareLinkValidator{
+			ShareLinkDB: &shareLinkGorm{db},
+			hmac:        hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+type shareLinkService struct {
+	ShareLinkDB
+}
+
+type shareLinkValidator struct {
+	ShareLinkDB
+	hmac hash.HMAC
+}
+
+func (sv *shareLinkValidator) Create(link *ShareLink) error {
+	err := runShareLinkValidationFuncs(link,
+		sv.galleryIDRequired,
+		sv.maxViewsValid,
+		sv.expiresInFuture,
+		sv.setTokenIfUnset,
+		sv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return sv.ShareLinkDB.Create(link)
+}
+
+func (sv *shareLinkValidator) galleryIDRequired(link *ShareLink) error {
+	if link.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) maxViewsValid(link *ShareLink) error {
+	if link.MaxViews < 0 {
+		return ErrShareLinkMaxViewsInvalid
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) expiresInFuture(link *ShareLink) error {
+	if link.Expired(time.Now()) {
+		return ErrShareLinkExpiresInPast
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) setTokenIfUnset(link *ShareLink) error {
+	if link.Token != "" {
+		return nil
+	}
+	token, err := rand.String(ShareLinkTokenBytes)
+	if err != nil {
+		return err
+	}
+	link.Token = token
+	return nil
+}
+
+func (sv *shareLinkValidator) hmacToken(link *ShareLink) error {
+	if link.Token == "" {
+		return nil
+	}
+	link.TokenHash = sv.hmac.Hash(link.Token)
+	return nil
+}
+
+var _ ShareLinkDB = &shareLinkGorm{}
+
+type shareLinkGorm struct {
+	db *gorm.DB
+}
+
+func (sg *shareLinkGorm) ByID(id uint) (*ShareLink, error) {
+	var link ShareLink
+	db := sg.db.Where("id = ?", id)
+	err := first(db, &link)
+	return &link, err
+}
+
+func (sg *shareLinkGorm) ByGalleryID(galleryID uint) ([]ShareLink, error) {
+	var links []ShareLink
+	err := sg.db.Where("gallery_id = ?", galleryID).
+		Order("created_at DESC").Find(&links).Error
+	return links, err
+}
+
+func (sg *shareLinkGorm) Create(link *ShareLink) error {
+	return sg.db.Create(link).Error
+}
+
+func (sg *shareLinkGorm) Revoke(link *ShareLink) error {
+	now := time.Now()
+	link.RevokedAt = &now
+	return sg.db.Model(link).Update("revoked_at", now).Error
+}
+
+// RecordView counts the view in one statement, so two visitors can't
+// both take the last one
+func (sg *shareLinkGorm) RecordView(link *ShareLink) error {
+	now := time.Now()
+	db := sg.db.Model(&ShareLink{}).
+		Where("id = ? AND revoked_at IS NULL", link.ID).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("max_views = 0 OR views < max_views").
+		Updates(map[string]interface{}{
+			"views":          gorm.Expr("views + 1"),
+			"last_viewed_at": now,
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		if link.Expired(now) {
+			return ErrShareLinkExpired
+		}
+		return ErrShareLinkUsedUp
+	}
+	link.Views++
+	link.LastViewedAt = &now
+	return nil
+}
+
+type shareLinkValidatorFunc func(*ShareLink) error
+
+func runShareLinkValidationFuncs(link *ShareLink, fns ...shareLinkValidatorFunc) error {
+	for _, fn := range fns {
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"lenslocked.com/hash"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestShareLinkStatus(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	cases := []struct {
+		link ShareLink
+		want string
+	}{
+		{ShareLink{}, ShareLinkActive},
+		{ShareLink{ExpiresAt: &future, MaxViews: 3, Views: 2}, ShareLinkActive},
+		{ShareLink{ExpiresAt: &past}, ShareLinkExpired},
+		{ShareLink{MaxViews: 3, Views: 3}, ShareLinkUsedUp},
+		{ShareLink{RevokedAt: &past, ExpiresAt: &past}, ShareLinkRevoked},
+	}
+	for i, c := range cases {
+		if got := c.link.Status(); got != c.want {
+			t.Errorf("%d: Status() = %q, want %q", i, got, c.want)
+		}
+		if open := c.link.Open(time.Now()); open != (c.want == ShareLinkActive) {
+			t.Errorf("%d: Open() = %v for a %s link", i, open, c.want)
+		}
+	}
+}
+
+func TestShareLinkValidator(t *testing.T) {
+	sv := &shareLinkValidator{}
+	past := time.Now().Add(-time.Minute)
+	link := ShareLink{GalleryID: 7, MaxViews: -1}
+	if err := sv.maxViewsValid(&link); err != ErrShareLinkMaxViewsInvalid {
+		t.Errorf("Expected ErrShareLinkMaxViewsInvalid, received %v", err)
+	}
+	link.ExpiresAt = &past
+	if err := sv.expiresInFuture(&link); err != ErrShareLinkExpiresInPast {
+		t.Errorf("Expected ErrShareLinkExpiresInPast, received %v", err)
+	}
+	if err := sv.setTokenIfUnset(&link); err != nil || len(link.Token) != 24 {
+		t.Errorf("Expected a 24 character token, received %q %v", link.Token, err)
+	}
+	sv.hmac = hash.NewHMAC("test-key")
+	if err := sv.hmacToken(&link); err != nil || link.TokenHash != sv.hmac.Hash(link.Token) {
+		t.Errorf("Expected the token's HMAC to be stored, received %q %v", link.TokenHash, err)
+	}
+}
+
+type shareLinkGalleryDB struct {
+	GalleryDB
+	tokenHash string
+}
+
+func (db *shareLinkGalleryDB) ByShareLink(tokenHash string) (*Gallery, error) {
+	if tokenHash != db.tokenHash {
+		return nil, ErrNotFound
+	}
+	return &Gallery{shareLink: &ShareLink{TokenHash: tokenHash}}, nil
+}
+
+func TestGalleryByShareLink(t *testing.T) {
+	hmac := hash.NewHMAC("test-key")
+	gv := &galleryValidator{GalleryDB: &shareLinkGalleryDB{tokenHash: hmac.Hash("tok")}, hmac: hmac}
+	gallery, err := gv.ByShareLink("tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gallery.Path() != "/s/tok" {
+		t.Errorf("Expected the raw token to be kept for links, received %q", gallery.Path())
+	}
+	if _, err := gv.ByShareLink(hmac.Hash("tok")); err != ErrNotFound {
+		t.Errorf("Expected the stored hash not to work as a token, received %v", err)
+	}
+}
+
+func TestGalleryShareLink(t *testing.T) {
+	now := time.Now()
+	gs := &galleryService{now: func() time.Time { return now }}
+	expires := now.Add(time.Hour)
+	link := &ShareLink{Token: "tok", ExpiresAt: &expires}
+	gallery := &Gallery{Model: gorm.Model{ID: 7}, Visibility: VisibilityPrivate, Slug: "abc", shareLink: link}
+	if gallery.Path() != "/s/tok" {
+		t.Errorf("Expected the share link to be kept, received %q", gallery.Path())
+	}
+	if ok, err := gs.CanView(gallery, nil, ""); !ok || err != nil {
+		t.Errorf("Expected the link to open a private gallery, received %v %v", ok, err)
+	}
+	link.MaxViews, link.Views = 2, 2
+	if ok, _ := gs.CanView(gallery, nil, ""); ok {
+		t.Error("Expected a used up link not to open the gallery")
+	}
+	link.Views = 1
+	now = expires
+	if ok, _ := gs.CanView(gallery, nil, ""); ok {
+		t.Error("Expected an expired link not to open the gallery")
+	}
+	gallery.PasswordHash = "x"
+	if !gallery.Unlockable() {
+		t.Error("Expected galleries opened with a link to be unlockable")
+	}
+}
@@ -3,8 +3,10 @@ package models
 import (
 	"regexp"
 	"strings"
+	"time"
 
 	"lenslocked.com/hash"
+	"lenslocked.com/metadata"
 	"lenslocked.com/rand"
 
 	"golang.org/x/crypto/bcrypt"
@@ -13,9 +15,6 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
-const userPwPepper = "aaaafe93-7942-4e3d-a4fc-e295ba99d571"
-const hmacSecretKey = "secret-hmac-key"
-
 // User represents the user model stored in our db
 // This is used for user accounts storing both email and passwords
 // so users can login and gian access to their content
@@ -27,6 +26,17 @@ type User struct {
 	PasswordHash string `gorm:"not null"`
 	Remember     string `gorm:"-"`
 	RememberHash string `gorm:"not null; unique_index"`
+	Disabled     bool   `gorm:"not null;default:false"`
+	// Verified is set once the user clicks the link emailed to Email
+	Verified   bool `gorm:"not null;default:false"`
+	VerifiedAt *time.Time
+	// PendingEmail is an address the user has asked to change to. It
+	// only replaces Email once the new address has been verified.
+	PendingEmail string `gorm:"not null;default:''"`
+	// MetadataPolicy is what metadata is left in the public copies of
+	// images in the user's galleries, unless a gallery sets its own.
+	// It is one of the metadata.Policy values.
+	MetadataPolicy string `gorm:"not null;default:'strip-gps'"`
 }
 
 // UserDB is used to interact with the user database
@@ -44,6 +54,9 @@ type UserDB interface {
 	ByEmail(email string) (*User, error)
 	ByRemember(token string) (*User, error)
 
+	// Methods for querying multiple users
+	All() ([]User, error)
+
 	// Methods for altering users
 	Create(user *User) error
 	Update(user *User) error
@@ -58,17 +71,42 @@ type UserService interface {
 	// that email will be returned. Otherwise, you will receive:
 	// ErrNotFound, ErrInvalidPassword, or another error
 	Authenticate(email, password string) (*User, error)
+
+	// InitiateReset creates a password reset for the user with the
+	// given email address and returns the raw token to email them.
+	InitiateReset(email string) (string, error)
+	// CompleteReset sets a new password for the user the token belongs
+	// to. The token is used up and every device the user was signed
+	// in on is signed out.
+	CompleteReset(token, newPw string) (*User, error)
+
+	// VerificationToken returns a signed token for the link emailed
+	// to user to verify email, which is either their current address
+	// or their pending one.
+	VerificationToken(user *User, email string) string
+	// Verify checks a token from a verification link. Verifying the
+	// current address marks the user as verified, verifying a pending
+	// address makes it the user's email address.
+	Verify(token string) (*User, error)
+	// RequestEmailChange validates newEmail and stores it as the
+	// user's pending address until it is verified
+	RequestEmailChange(user *User, newEmail string) error
 	UserDB
 }
 
 // NewUserService takes care of setting up the db for the userService.
-// If there is an error opening the db
-func NewUserService(db *gorm.DB) UserService {
+// The pepper is appended to every password before it is hashed and
+// hmacKey is used to hash remember tokens.
+func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
 	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
+	hmac := hash.NewHMAC(hmacKey)
+	uv := newUserValidator(ug, hmac, pepper)
 	return &userService{
-		UserDB: uv,
+		UserDB:    uv,
+		pepper:    pepper,
+		pwResetDB: newPwResetValidator(&pwResetGorm{db}, hmac),
+		sessions:  &sessionGorm{db},
+		verifier:  &emailVerifier{hmac: hmac, now: time.Now},
 	}
 }
 
@@ -77,6 +115,10 @@ var _ UserService = &userService{}
 //userService interacts with user objects
 type userService struct {
 	UserDB
+	pepper    string
+	pwResetDB pwResetDB
+	sessions  SessionDB
+	verifier  *emailVerifier
 }
 
 // Authenticate can be used to authenticate a user with the
@@ -85,6 +127,8 @@ type userService struct {
 //   nil, ErrNotFound
 // If the password provided is invalid, this will return
 //   nil, ErrInvalidPassword
+// If the account has been disabled, this will return
+//   nil, ErrUserDisabled
 // If the email and password are both valid, this will return
 //   user, nil
 // Otherwise if another error is encountered this will return
@@ -95,7 +139,7 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+userPwPepper))
+	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+us.pepper))
 	if err != nil {
 		switch err {
 		case bcrypt.ErrMismatchedHashAndPassword:
@@ -104,10 +148,114 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 			return nil, err
 		}
 	}
+	if foundUser.Disabled {
+		return nil, ErrUserDisabled
+	}
 
 	return foundUser, nil
 }
 
+func (us *userService) InitiateReset(email string) (string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return "", err
+	}
+	return pwr.Token, nil
+}
+
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	// The password is checked before the token is used up, so one
+	// that is too short can be fixed without another email. These
+	// validators don't need anything set on userValidator.
+	var uv userValidator
+	err := runUserValidationFuncs(&User{Password: newPw},
+		uv.passwordRequired,
+		uv.passwordMinLength)
+	if err != nil {
+		return nil, err
+	}
+	pwr, err := us.pwResetDB.Consume(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = newPw
+	// A new remember token kills any cookie from before sessions
+	remember, err := rand.RememberToken()
+	if err != nil {
+		return nil, err
+	}
+	user.Remember = remember
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	if err := us.pwResetDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+	if err := us.sessions.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (us *userService) VerificationToken(user *User, email string) string {
+	return us.verifier.token(user.ID, email)
+}
+
+func (us *userService) Verify(token string) (*User, error) {
+	id, email, err := us.verifier.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(id)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	switch {
+	case email == user.Email:
+	case email != "" && email == user.PendingEmail:
+		// Update checks the address wasn't taken while it was pending
+		user.Email = user.PendingEmail
+		user.PendingEmail = ""
+	default:
+		// The user has since asked to change to a different address
+		return nil, ErrTokenInvalid
+	}
+	now := time.Now()
+	user.Verified = true
+	user.VerifiedAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (us *userService) RequestEmailChange(user *User, newEmail string) error {
+	// Work on a copy so user is left alone if the address is rejected
+	updated := *user
+	updated.PendingEmail = newEmail
+	if err := us.Update(&updated); err != nil {
+		return err
+	}
+	*user = updated
+	return nil
+}
+
 // Ensure uservalidator implements this interface
 var _ UserDB = &userValidator{}
 
@@ -122,10 +270,11 @@ func runUserValidationFuncs(user *User, fns ...userValidatorFunc) error {
 	return nil
 }
 
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
 		hmac:       hmac,
+		pepper:     pepper,
 		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
 	}
 }
@@ -137,6 +286,7 @@ type userValidator struct {
 	UserDB
 	hmac       hash.HMAC
 	emailRegex *regexp.Regexp
+	pepper     string
 }
 
 // byEmail will normalize the email address before calling
@@ -179,7 +329,8 @@ func (uv *userValidator) Create(user *User) error {
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.metadataPolicyValid)
 	if err != nil {
 		return err
 	}
@@ -200,7 +351,11 @@ func (uv *userValidator) Update(user *User) error {
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.normalizePendingEmail,
+		uv.pendingEmailFormat,
+		uv.pendingEmailIsAvail,
+		uv.metadataPolicyValid)
 	if err != nil {
 		return err
 	}
@@ -218,14 +373,13 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
-// bcryptPassword will hash a user's password with a predefined
-// pepper (userPwPepper) and bcrypt if the password is not the empty
-// string
+// bcryptPassword will hash a user's password with the configured
+// pepper and bcrypt if the password is not the empty string
 func (uv *userValidator) bcryptPassword(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
-	pwBytes := []byte(user.Password + userPwPepper)
+	pwBytes := []byte(user.Password + uv.pepper)
 	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -328,6 +482,49 @@ func (uv *userValidator) emailIsAvail(user *User) error {
 	return nil
 }
 
+// normalizePendingEmail clears the pending address when it is just
+// the user's current address
+func (uv *userValidator) normalizePendingEmail(user *User) error {
+	user.PendingEmail = strings.ToLower(strings.TrimSpace(user.PendingEmail))
+	if user.PendingEmail == user.Email {
+		user.PendingEmail = ""
+	}
+	return nil
+}
+
+// metadataPolicyValid defaults users to having GPS stripped from
+// their images
+func (uv *userValidator) metadataPolicyValid(user *User) error {
+	if user.MetadataPolicy == "" {
+		user.MetadataPolicy = string(metadata.StripGPS)
+	}
+	if !metadata.Policy(user.MetadataPolicy).Valid() {
+		return ErrMetadataPolicyInvalid
+	}
+	return nil
+}
+
+func (uv *userValidator) pendingEmailFormat(user *User) error {
+	if user.PendingEmail == "" {
+		return nil
+	}
+	if !uv.emailRegex.MatchString(user.PendingEmail) {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+// pendingEmailIsAvail makes sure nobody else is using the address
+// the user wants to change to
+func (uv *userValidator) pendingEmailIsAvail(user *User) error {
+	if user.PendingEmail == "" {
+		return nil
+	}
+	pending := User{Email: user.PendingEmail}
+	pending.ID = user.ID
+	return uv.emailIsAvail(&pending)
+}
+
 func (uv *userValidator) passwordMinLength(user *User) error {
 	if user.Password == "" {
 		return nil
@@ -390,6 +587,13 @@ func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
 	return &user, err
 }
 
+// All returns every user ordered by ID
+func (ug *userGorm) All() ([]User, error) {
+	var users []User
+	err := ug.db.Order("id").Find(&users).Error
+	return users, err
+}
+
 // Create creates a user in the db via GORM
 func (ug *userGorm) Create(user *User) error {
 	return ug.db.Create(user).Error
@@ -1,33 +1,28 @@
 package models
 
 import (
-	"fmt"
 	"testing"
 	"time"
+
+	"lenslocked.com/config"
 )
 
 // TODO: write tests for byID, by Email, Update, and delete
 
-func testingUserService() (*UserService, error) {
-	const (
-		host     = "localhost"
-		port     = 5432
-		password = ""
-		user     = "fenderjazzplayer"
-		dbname   = "lenslocked_test"
+func testingUserService() (UserService, error) {
+	cfg := config.Default(config.EnvTest)
+	dbCfg := cfg.Database
+	services, err := NewServices(
+		WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
+		WithLogMode(false),
+		WithUser(cfg.Pepper, cfg.HMACKey),
 	)
-
-	connString := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
-		host, port, user, dbname)
-
-	us, err := NewUserService(connString)
 	if err != nil {
 		return nil, err
 	}
-	us.db.LogMode(false)
 	// clear the user table between test
-	us.DesctructiveReset()
-	return us, nil
+	services.DestructiveReset()
+	return services.User, nil
 }
 
 func TestCreateUser(t *testing.T) {
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"lenslocked.com/hash"
+)
+
+// verifyEmailTTL is how long an emailed verification link works for
+const verifyEmailTTL = 48 * time.Hour
+
+// verifyEmailPurpose is mixed into the signature so a verification
+// token can never be confused with anything else we sign
+const verifyEmailPurpose = "verify-email:"
+
+// emailVerifier signs and checks the tokens in verification links.
+// A token ties a user ID to the address being verified and an expiry,
+// so nothing needs to be stored until the link is clicked.
+type emailVerifier struct {
+	hmac hash.HMAC
+	now  func() time.Time
+}
+
+func (ev *emailVerifier) token(userID uint, email string) string {
+	exp := ev.now().Add(verifyEmailTTL).Unix()
+	payload := fmt.Sprintf("%d|%d|%s", userID, exp, email)
+	sig := ev.hmac.Hash(verifyEmailPurpose + payload)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sig
+}
+
+// parse returns the user ID and email address the token was made
+// for, or ErrTokenInvalid if it was tampered with or has expired
+func (ev *emailVerifier) parse(token string) (uint, string, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return 0, "", ErrTokenInvalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return 0, "", ErrTokenInvalid
+	}
+	payload := string(b)
+	if !ev.hmac.Equal(verifyEmailPurpose+payload, token[i+1:]) {
+		return 0, "", ErrTokenInvalid
+	}
+	parts := strings.SplitN(payload, "|", 3)
+	if len(parts) != 3 {
+		return 0, "", ErrTokenInvalid
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrTokenInvalid
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || ev.now().Unix() > exp {
+		return 0, "", ErrTokenInvalid
+	}
+	return uint(id), parts[2], nil
+}
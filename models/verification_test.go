@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"lenslocked.com/hash"
+)
+
+func TestEmailVerifier(t *testing.T) {
+	now := time.Now()
+	ev := &emailVerifier{
+		hmac: hash.NewHMAC("test-key"),
+		now:  func() time.Time { return now },
+	}
+	token := ev.token(42, "mscott@dundermifflin.com")
+
+	id, email, err := ev.parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 || email != "mscott@dundermifflin.com" {
+		t.Errorf("Expected 42 mscott@dundermifflin.com, received %d %s", id, email)
+	}
+
+	other := &emailVerifier{hmac: hash.NewHMAC("other-key"), now: ev.now}
+	if _, _, err := other.parse(token); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for a different key, received %v", err)
+	}
+	tampered := ev.token(43, "mscott@dundermifflin.com")
+	tampered = tampered[:len(tampered)-4] + token[len(token)-4:]
+	if _, _, err := ev.parse(tampered); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for a tampered token, received %v", err)
+	}
+
+	now = now.Add(verifyEmailTTL + time.Minute)
+	if _, _, err := ev.parse(token); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for an expired token, received %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package ratelimit counts attempts, such as password guesses, per
+// key so that guessing can be slowed down. Counts are kept in memory,
+// so they are per process and start over on restart.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneSize is how many keys are kept before expired ones are
+// cleared out
+const pruneSize = 1024
+
+// NewLimiter allows max attempts per key in each window. The window
+// starts at a key's first attempt.
+func NewLimiter(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:      max,
+		window:   window,
+		now:      time.Now,
+		attempts: make(map[string]*attempts),
+	}
+}
+
+// Limiter is safe to use from multiple goroutines at once
+type Limiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	attempts map[string]*attempts
+}
+
+type attempts struct {
+	count int
+	reset time.Time
+}
+
+// Allow reserves an attempt for key and reports whether it may go
+// ahead. Checking and counting happen together, so requests racing
+// each other can't make more than max attempts between them. When key
+// may not try again, Allow also returns how long until it can.
+// Call Reset once an attempt succeeds.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	a, ok := l.attempts[key]
+	if !ok || !now.Before(a.reset) {
+		if len(l.attempts) >= pruneSize {
+			l.prune(now)
+		}
+		a = &attempts{reset: now.Add(l.window)}
+		l.attempts[key] = a
+	}
+	if a.count >= l.max {
+		return false, a.reset.Sub(now)
+	}
+	a.count++
+	return true, 0
+}
+
+// Reset forgets the attempts of key, for example after it succeeded
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// prune drops keys whose window is over. l.mu must be held.
+func (l *Limiter) prune(now time.Time) {
+	for key, a := range l.attempts {
+		if !now.Before(a.reset) {
+			delete(l.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("Expected attempt %d to be allowed", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != time.Minute {
+		t.Errorf("Expected to wait a minute after 3 attempts, received %v %s", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("Expected other keys to be allowed")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Expected to be allowed again once the window is over")
+	}
+
+	l.Allow("a")
+	l.Allow("a")
+	l.Reset("a")
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Expected Reset to forget the attempts")
+	}
+}
+
+func TestLimiterConcurrent(t *testing.T) {
+	l := NewLimiter(5, time.Minute)
+	var allowed int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if ok, _ := l.Allow("a"); ok {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if allowed != 5 {
+		t.Errorf("Expected 5 of 100 concurrent attempts to be allowed, received %d", allowed)
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(1, time.Minute)
+	l.now = func() time.Time { return now }
+	for i := 0; i < pruneSize; i++ {
+		l.Allow(string(rune('a' + i)))
+	}
+	now = now.Add(time.Minute)
+	l.Allow("new")
+	if len(l.attempts) != 1 {
+		t.Errorf("Expected expired keys to be pruned, %d left", len(l.attempts))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runServer serves until one of the servers fails or the process
+// receives SIGINT or SIGTERM. On a signal we stop accepting connections,
+// give in-flight requests until the shutdown timeout to finish and then
+// stop the background workers, all within that same deadline. The
+// database is closed by the caller once we return.
+//
+// Servers with a TLSConfig are served over HTTPS.
+func runServer(a *app, servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if srv.TLSConfig != nil {
+				// The certificate is already in TLSConfig
+				errs <- srv.ListenAndServeTLS("", "")
+				return
+			}
+			errs <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errs:
+		// A server never started or died on its own, take the rest down too
+	case <-ctx.Done():
+	}
+	// A second signal while we are draining kills the process right away
+	stop()
+
+	fmt.Fprintln(a.out, "Shutting down, waiting for in-flight requests.....")
+	timeout := time.Duration(a.cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var shutdownErr error
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			srv.Close()
+			shutdownErr = err
+		}
+	}
+	if err := a.stopBackground(shutdownCtx); err != nil && shutdownErr == nil {
+		shutdownErr = err
+	}
+
+	switch {
+	case serveErr != nil:
+		return serveErr
+	case shutdownErr == context.DeadlineExceeded:
+		return errShutdownTimeout
+	case shutdownErr != nil:
+		return shutdownErr
+	}
+	fmt.Fprintln(a.out, "Server stopped")
+	return nil
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewLocalStore keeps blobs as files inside of the root directory
+func NewLocalStore(root string) BlobStore {
+	return &localStore{root: root}
+}
+
+type localStore struct {
+	root string
+}
+
+var _ BlobStore = &localStore{}
+
+// Put writes to a temporary file first and renames it into place so
+// readers never see half written blobs
+func (ls *localStore) Put(key string, r io.Reader, contentType string) error {
+	file, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (ls *localStore) Get(key string) (io.ReadSeekCloser, BlobInfo, error) {
+	file, err := ls.path(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, BlobInfo{}, notFound(err)
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		return nil, BlobInfo{}, ErrNotFound
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, BlobInfo{}, err
+	}
+	info := ls.info(key, fi)
+	info.ContentType = contentType(key, head[:n])
+	return f, info, nil
+}
+
+func (ls *localStore) Delete(key string) error {
+	file, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	return notFound(os.Remove(file))
+}
+
+func (ls *localStore) List(prefix string) ([]BlobInfo, error) {
+	var infos []BlobInfo
+	err := filepath.Walk(ls.root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(ls.root, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, ls.info(key, fi))
+		}
+		return nil
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, err
+}
+
+func (ls *localStore) Stat(key string) (BlobInfo, error) {
+	file, err := ls.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return BlobInfo{}, notFound(err)
+	}
+	if fi.IsDir() {
+		return BlobInfo{}, ErrNotFound
+	}
+	return ls.info(path.Clean(key), fi), nil
+}
+
+// SignedURL isn't supported, files on local disk are served by the app
+func (ls *localStore) SignedURL(key string, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+func (ls *localStore) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ls.root, filepath.FromSlash(key)), nil
+}
+
+func (ls *localStore) info(key string, fi os.FileInfo) BlobInfo {
+	return BlobInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: contentType(key, nil),
+		ModTime:     fi.ModTime(),
+	}
+}
+
+// notFound swaps os not exist errors for ErrNotFound
+func notFound(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryStore keeps blobs in memory. It is meant for tests.
+func NewMemoryStore() BlobStore {
+	return &memoryStore{blobs: make(map[string]memoryBlob)}
+}
+
+type memoryBlob struct {
+	data []byte
+	info BlobInfo
+}
+
+type memoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+var _ BlobStore = &memoryStore{}
+
+func (ms *memoryStore) Put(key string, r io.Reader, ct string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if ct == "" {
+		ct = contentType(key, data)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.blobs[key] = memoryBlob{
+		data: data,
+		info: BlobInfo{
+			Key:         key,
+			Size:        int64(len(data)),
+			ContentType: ct,
+			ModTime:     time.Now(),
+		},
+	}
+	return nil
+}
+
+func (ms *memoryStore) Get(key string) (io.ReadSeekCloser, BlobInfo, error) {
+	blob, err := ms.blob(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return nopCloser{bytes.NewReader(blob.data)}, blob.info, nil
+}
+
+func (ms *memoryStore) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.blobs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(ms.blobs, key)
+	return nil
+}
+
+func (ms *memoryStore) List(prefix string) ([]BlobInfo, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var infos []BlobInfo
+	for key, blob := range ms.blobs {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, blob.info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+func (ms *memoryStore) Stat(key string) (BlobInfo, error) {
+	blob, err := ms.blob(key)
+	return blob.info, err
+}
+
+// SignedURL isn't supported, there is nothing outside of the app
+// that could serve the blob
+func (ms *memoryStore) SignedURL(key string, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+func (ms *memoryStore) blob(key string) (memoryBlob, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return memoryBlob{}, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	blob, ok := ms.blobs[key]
+	if !ok {
+		return memoryBlob{}, ErrNotFound
+	}
+	return blob, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,520 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3AmzDate       = "20060102T150405Z"
+	s3ShortDate     = "20060102"
+	s3UnsignedBody  = "UNSIGNED-PAYLOAD"
+	s3MaxSignedTTL  = 7 * 24 * time.Hour
+	s3DefaultRegion = "us-east-1"
+)
+
+// S3Config is everything needed to talk to Amazon S3 or any server
+// that speaks the same API, like MinIO
+type S3Config struct {
+	// Endpoint is the base URL of the server, for example
+	// https://s3.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path rather than the host
+	// name, which most self hosted servers need
+	PathStyle bool
+	// Client is used to make requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// NewS3Store keeps blobs in an S3 bucket. Requests are signed with
+// AWS signature version 4.
+func NewS3Store(cfg S3Config) (BlobStore, error) {
+	if cfg.Region == "" {
+		cfg.Region = s3DefaultRegion
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("storage: bad s3 endpoint: %v", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: bad s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("storage: an s3 bucket is required")
+	}
+	return &s3Store{cfg: cfg, endpoint: endpoint, now: time.Now}, nil
+}
+
+type s3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	now      func() time.Time
+}
+
+var _ BlobStore = &s3Store{}
+
+func (ss *s3Store) Put(key string, r io.Reader, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	// S3 needs the length up front and we sign the payload hash, so
+	// the body is read twice. Seekable readers are rewound, anything
+	// else is buffered in memory.
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		rs = bytes.NewReader(data)
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, rs)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, ss.objectURL(key).String(), ioutil.NopCloser(rs))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := ss.do(req, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (ss *s3Store) Get(key string) (io.ReadSeekCloser, BlobInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	obj := &s3Object{store: ss, key: key}
+	resp, err := obj.open()
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	info := ss.info(key, resp)
+	obj.size = info.Size
+	return obj, info, nil
+}
+
+func (ss *s3Store) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	// S3 happily deletes keys that don't exist, so check first to
+	// behave like the other stores
+	if _, err := ss.Stat(key); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, ss.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ss.do(req, emptySHA256)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response that we use
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+func (ss *s3Store) List(prefix string) ([]BlobInfo, error) {
+	var infos []BlobInfo
+	token := ""
+	for {
+		u := ss.bucketURL()
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := ss.do(req, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			infos = append(infos, BlobInfo{
+				Key:         c.Key,
+				Size:        c.Size,
+				ContentType: contentType(c.Key, nil),
+				ModTime:     c.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+func (ss *s3Store) Stat(key string) (BlobInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	req, err := http.NewRequest(http.MethodHead, ss.objectURL(key).String(), nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp, err := ss.do(req, emptySHA256)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp.Body.Close()
+	return ss.info(key, resp), nil
+}
+
+// SignedURL returns a presigned GET url for the blob. S3 won't accept
+// signatures that last longer than a week.
+func (ss *s3Store) SignedURL(key string, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 || ttl > s3MaxSignedTTL {
+		return "", fmt.Errorf("storage: s3 signed urls must last between 1s and %s", s3MaxSignedTTL)
+	}
+	return ss.presign(http.MethodGet, ss.objectURL(key), ttl, ss.now().UTC()), nil
+}
+
+// do signs and sends req, turning error responses into errors
+func (ss *s3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	ss.sign(req, payloadHash, ss.now().UTC())
+	resp, err := ss.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var s3Err struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&s3Err)
+	if s3Err.Code == "" {
+		s3Err.Code = resp.Status
+	}
+	return nil, fmt.Errorf("storage: s3 %s %s: %s %s", req.Method, req.URL.Path, s3Err.Code, s3Err.Message)
+}
+
+func (ss *s3Store) info(key string, resp *http.Response) BlobInfo {
+	info := BlobInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		// bytes 0-99/1234
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				info.Size = size
+			}
+		}
+	}
+	if info.ContentType == "" {
+		info.ContentType = contentType(key, nil)
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info
+}
+
+func (ss *s3Store) bucketURL() *url.URL {
+	u := *ss.endpoint
+	if ss.cfg.PathStyle {
+		u.Path += "/" + ss.cfg.Bucket + "/"
+	} else {
+		u.Host = ss.cfg.Bucket + "." + u.Host
+		u.Path += "/"
+	}
+	return &u
+}
+
+func (ss *s3Store) objectURL(key string) *url.URL {
+	u := ss.bucketURL()
+	u.Path += key
+	u.RawPath = s3EscapePath(u.Path)
+	return u
+}
+
+// sign adds the headers for an AWS signature version 4 to req
+func (ss *s3Store) sign(req *http.Request, payloadHash string, t time.Time) {
+	req.Header.Set("X-Amz-Date", t.Format(s3AmzDate))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	scope := ss.scope(t)
+	signed, canonical := s3CanonicalRequest(req, payloadHash)
+	sig := ss.signature(t, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, ss.cfg.AccessKey, scope, signed, sig))
+}
+
+// presign builds a url carrying its signature in the query string
+func (ss *s3Store) presign(method string, u *url.URL, ttl time.Duration, t time.Time) string {
+	scope := ss.scope(t)
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", s3Algorithm)
+	q.Set("X-Amz-Credential", ss.cfg.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", t.Format(s3AmzDate))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	signed := *u
+	signed.RawQuery = s3EscapeQuery(q)
+	req := &http.Request{Method: method, URL: &signed, Host: u.Host, Header: http.Header{}}
+	_, canonical := s3CanonicalRequest(req, s3UnsignedBody)
+	signed.RawQuery += "&X-Amz-Signature=" + ss.signature(t, scope, canonical)
+	return signed.String()
+}
+
+func (ss *s3Store) scope(t time.Time) string {
+	return t.Format(s3ShortDate) + "/" + ss.cfg.Region + "/s3/aws4_request"
+}
+
+func (ss *s3Store) signature(t time.Time, scope, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := s3Algorithm + "\n" + t.Format(s3AmzDate) + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+ss.cfg.SecretKey), t.Format(s3ShortDate))
+	key = hmacSHA256(key, ss.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// s3CanonicalRequest returns the signed header list and the canonical
+// form of req that gets hashed into the signature. The host header,
+// range, content type and any x-amz- headers are signed.
+func s3CanonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers["host"] = host
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(unescapePath(path)),
+		s3EscapeQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	return signed, canonical
+}
+
+func unescapePath(p string) string {
+	if u, err := url.PathUnescape(p); err == nil {
+		return u
+	}
+	return p
+}
+
+// s3Escape percent encodes everything other than the unreserved
+// characters, the way AWS expects
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// s3EscapeQuery encodes the query sorted by key, and then by value
+func s3EscapeQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// emptySHA256 is the payload hash of requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Object reads a blob from S3. Seeking is free, the next Read
+// after a seek starts a new ranged GET from the new offset.
+type s3Object struct {
+	store   *s3Store
+	key     string
+	size    int64
+	off     int64
+	body    io.ReadCloser
+	bodyOff int64
+}
+
+func (o *s3Object) open() (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, o.store.objectURL(o.key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if o.off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.off))
+	}
+	resp, err := o.store.do(req, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	if o.off > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("storage: s3 ignored range request for %s", o.key)
+	}
+	o.body = resp.Body
+	o.bodyOff = o.off
+	return resp, nil
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body != nil && o.bodyOff != o.off {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		if _, err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	o.bodyOff = o.off
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, fmt.Errorf("storage: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("storage: negative position")
+	}
+	o.off = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
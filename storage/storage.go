@@ -0,0 +1,76 @@
+// Package storage holds the blob stores that uploaded images are kept
+// in. Keys are slash separated paths like "galleries/1/photo.jpg" no
+// matter which backend is used.
+package storage
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when there is no blob with the given key
+	ErrNotFound = errors.New("storage: blob not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute or
+	// try to climb out of the store with ".."
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrSignedURLUnsupported is returned by SignedURL when the backend
+	// has no way of handing out direct links. Blobs in these stores
+	// have to be served by the app.
+	ErrSignedURLUnsupported = errors.New("storage: signed urls are not supported")
+)
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore is somewhere we can keep files
+type BlobStore interface {
+	// Put stores everything read from r at key, replacing any blob
+	// that is already there
+	Put(key string, r io.Reader, contentType string) error
+	// Get opens the blob at key. The caller must close it.
+	Get(key string) (io.ReadSeekCloser, BlobInfo, error)
+	Delete(key string) error
+	// List returns every blob whose key starts with prefix, sorted
+	// by key
+	List(prefix string) ([]BlobInfo, error)
+	Stat(key string) (BlobInfo, error)
+	// SignedURL returns a link that can be used to download the blob
+	// directly from the backend until ttl has passed
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// cleanKey checks that key is a relative path that stays inside of
+// the store and returns it in its clean form
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	clean := path.Clean(key)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", ErrInvalidKey
+	}
+	return clean, nil
+}
+
+// contentType guesses the type of a blob stored without one from its
+// extension, falling back to sniffing the first bytes when we have them
+func contentType(key string, head []byte) string {
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	if len(head) == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(head)
+}
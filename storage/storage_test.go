@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBlobStore runs the behaviour every backend has to share
+func testBlobStore(t *testing.T, s BlobStore) {
+	t.Helper()
+	if err := s.Put("galleries/1/a.txt", strings.NewReader("hello, world"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("galleries/1/b c.jpg", strings.NewReader("jpeg bytes"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("galleries/2/a.txt", strings.NewReader("other"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, info, err := s.Get("galleries/1/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 12 || !strings.HasPrefix(info.ContentType, "text/plain") {
+		t.Errorf("Unexpected blob info %+v", info)
+	}
+	// Seek into the middle like http.ServeContent does for ranges
+	blob.Seek(7, 0)
+	b, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "world" {
+		t.Errorf("Expected to read world after seeking, received %q", b)
+	}
+
+	info, err = s.Stat("galleries/1/b c.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 10 || info.ContentType != "image/jpeg" {
+		t.Errorf("Unexpected stat %+v", info)
+	}
+
+	infos, err := s.List("galleries/1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	if want := []string{"galleries/1/a.txt", "galleries/1/b c.jpg"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected keys %v, received %v", want, keys)
+	}
+
+	// Putting the same key again replaces it
+	if err := s.Put("galleries/1/a.txt", strings.NewReader("bye"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := s.Stat("galleries/1/a.txt"); info.Size != 3 {
+		t.Errorf("Expected the blob to be replaced, size is %d", info.Size)
+	}
+
+	if err := s.Delete("galleries/1/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("galleries/1/a.txt"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, received %v", err)
+	}
+	if _, _, err := s.Get("galleries/1/a.txt"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound from get after delete, received %v", err)
+	}
+	if err := s.Delete("galleries/1/a.txt"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting twice, received %v", err)
+	}
+
+	for _, key := range []string{"", "/etc/passwd", "../secret", "a/../../secret"} {
+		if _, err := s.Stat(key); err != ErrInvalidKey {
+			t.Errorf("Expected ErrInvalidKey for %q, received %v", key, err)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenslocked-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testBlobStore(t, NewLocalStore(dir))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testBlobStore(t, NewMemoryStore())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"lenslocked.com/models"
+)
+
+func userCommand() *command {
+	return &command{
+		name:  "user",
+		short: "manage user accounts",
+		subs: []*command{
+			{
+				name:  "create",
+				args:  "-email <email> -password <password> [-name <name>] [-verified]",
+				short: "create a new account",
+				run:   userCreate,
+			},
+			{name: "list", short: "list every account", run: userList},
+			{name: "disable", args: "<email>", short: "stop an account from logging in", run: userDisable(true)},
+			{name: "enable", args: "<email>", short: "allow a disabled account to log in again", run: userDisable(false)},
+			{
+				name:  "set-password",
+				args:  "<email> <password>",
+				short: "change the password for an account",
+				run:   userSetPassword,
+			},
+		},
+	}
+}
+
+func userCreate(a *app, args []string) error {
+	fs := newFlagSet(a, "user create")
+	name := fs.String("name", "", "full name")
+	email := fs.String("email", "", "email address")
+	password := fs.String("password", "", "password")
+	verified := fs.Bool("verified", false, "mark the email address as already verified")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *email == "" {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	user := models.User{
+		Name:     *name,
+		Email:    *email,
+		Password: *password,
+		Verified: *verified,
+	}
+	if *verified {
+		now := time.Now()
+		user.VerifiedAt = &now
+	}
+	if err := services.User.Create(&user); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Created user %d <%s>\n", user.ID, user.Email)
+	return nil
+}
+
+func userList(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	users, err := services.User.All()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tDISABLED\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%t\t%s\n",
+			u.ID, u.Email, u.Name, u.Disabled, u.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+// userDisable returns a command that sets the disabled flag for the
+// account with the given email address
+func userDisable(disabled bool) func(a *app, args []string) error {
+	return func(a *app, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		services, err := a.Services()
+		if err != nil {
+			return err
+		}
+		user, err := services.User.ByEmail(args[0])
+		if err != nil {
+			return err
+		}
+		user.Disabled = disabled
+		if err := services.User.Update(user); err != nil {
+			return err
+		}
+		state := "Enabled"
+		if disabled {
+			state = "Disabled"
+		}
+		fmt.Fprintf(a.out, "%s user %d <%s>\n", state, user.ID, user.Email)
+		return nil
+	}
+}
+
+func userSetPassword(a *app, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	services, err := a.Services()
+	if err != nil {
+		return err
+	}
+	user, err := services.User.ByEmail(args[0])
+	if err != nil {
+		return err
+	}
+	user.Password = args[1]
+	if err := services.User.Update(user); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Updated password for user %d <%s>\n", user.ID, user.Email)
+	return nil
+}
@@ -16,10 +16,12 @@ const (
 	AlertMsgGeneric = "Something with wrong. Please try again."
 )
 
-// Alert is used to render Bootstrap Alert messages in templates
+// Alert is used to render Bootstrap Alert messages in templates.
+// Details are listed underneath the message.
 type Alert struct {
 	Level   string
 	Message string
+	Details []string
 }
 
 // Data is the top level structure that views expect data to come in
@@ -48,6 +50,24 @@ func (d *Data) SetAlert(err error) {
 	}
 }
 
+// AlertErrors shows msg followed by a line for each of errs. Like
+// SetAlert only the messages of PublicErrors are shown.
+func (d *Data) AlertErrors(msg string, errs []error) {
+	alert := &Alert{
+		Level:   AlertLvlError,
+		Message: msg,
+	}
+	for _, err := range errs {
+		if pErr, ok := err.(PublicError); ok {
+			alert.Details = append(alert.Details, pErr.Public())
+		} else {
+			log.Println(err.Error())
+			alert.Details = append(alert.Details, AlertMsgGeneric)
+		}
+	}
+	d.Alert = alert
+}
+
 func (d *Data) AlertError(msg string) {
 	d.Alert = &Alert{
 		Level:   AlertLvlError,
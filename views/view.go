@@ -8,19 +8,16 @@ import (
 	"net/http"
 	"path/filepath"
 
+	"lenslocked.com/config"
 	"lenslocked.com/context"
 )
 
-var (
-	LayoutDir   string = "views/layouts/"
-	TemplateDir string = "views/"
-	TemplateExt string = ".gohtml"
-)
-
-func NewView(layout string, files ...string) *View {
-	addTemplatePath(files)
-	addTemplateExt(files)
-	files = append(files, layoutFiles()...) // ... unpacks the slice into multiple strings
+// NewView parses the given page templates along with every layout.
+// cfg tells us where the templates live on disk.
+func NewView(cfg config.TemplateConfig, layout string, files ...string) *View {
+	addTemplatePath(cfg, files)
+	addTemplateExt(cfg, files)
+	files = append(files, layoutFiles(cfg)...) // ... unpacks the slice into multiple strings
 
 	t, err := template.ParseFiles(files...)
 	if err != nil {
@@ -66,8 +63,8 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 
 // layoutFiles returhns a slice of strfings representing
 // the layouut files used in our app
-func layoutFiles() []string {
-	files, err := filepath.Glob(LayoutDir + "*" + TemplateExt)
+func layoutFiles(cfg config.TemplateConfig) []string {
+	files, err := filepath.Glob(cfg.LayoutDir + "*" + cfg.Ext)
 	if err != nil {
 		panic(err)
 	}
@@ -76,18 +73,18 @@ func layoutFiles() []string {
 
 // addTemplatePath takes in a slice of strings repr file paths for templates
 // prepends tempalte dir directory to each string in slice
-// e.g. the input "home" would resolve to "views/home" if templateDirs == "views/"
-func addTemplatePath(files []string) {
+// e.g. the input "home" would resolve to "views/home" if cfg.Dir == "views/"
+func addTemplatePath(cfg config.TemplateConfig, files []string) {
 	for i, f := range files {
-		files[i] = TemplateDir + f
+		files[i] = cfg.Dir + f
 	}
 }
 
 // addTemplateExt takes in a slice of strings representing filepaths for templates
 // it appends the template extension to each slice
-// "home" would resolve to "home.gothml" if templatExt == .gohtml
-func addTemplateExt(files []string) {
+// "home" would resolve to "home.gothml" if cfg.Ext == .gohtml
+func addTemplateExt(cfg config.TemplateConfig, files []string) {
 	for i, f := range files {
-		files[i] = f + TemplateExt
+		files[i] = f + cfg.Ext
 	}
 }